@@ -2,12 +2,9 @@ package analyzer
 
 import (
 	"fmt"
-	"strconv"
-	"strings"
 	"sync"
 	"time"
 
-	"github.com/pkg/errors"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"wanggj.com/abyss/collector"
@@ -20,23 +17,77 @@ const (
 	NoneOp AlertOp = iota
 	Bigger
 	Smaller
+	BiggerEq
+	SmallerEq
+	Equal
+	NotEqual
+	InRange
+	OutRange
 )
 
 var Str2Op = map[string]AlertOp{
 	"none":    NoneOp,
 	"bigger":  Bigger,
 	"smaller": Smaller,
+	">":       Bigger,
+	"<":       Smaller,
+	">=":      BiggerEq,
+	"<=":      SmallerEq,
+	"==":      Equal,
+	"!=":      NotEqual,
+	"range":   InRange,
+	"outside": OutRange,
+}
+
+// AlertState is the state of Alert lifecycle, an Alert moves through
+// inactive -> pending -> firing -> resolved -> inactive.
+type AlertState int
+
+const (
+	StateInactive AlertState = iota
+	StatePending
+	StateFiring
+	StateResolved
+)
+
+var alertStateNames = map[AlertState]string{
+	StateInactive: "inactive",
+	StatePending:  "pending",
+	StateFiring:   "firing",
+	StateResolved: "resolved",
+}
+
+func (s AlertState) String() string {
+	return alertStateNames[s]
 }
 
 // Alert is a Metric of type Event, which is used to alert
-// Metric that dosen't fullfill the alert rule
+// Metric that dosen't fullfill the alert rule.
+//
+// Alert is only collected when it turns into firing or resolved, or it has
+// been firing for rule.Renotify since last notification. The written Event
+// carries label "state", and labels "firing_start" (RFC 3339) and
+// "firing_duration" (seconds) once Alert is firing or resolved.
 type Alert struct {
-	desc         *collector.Desc
-	op           AlertOp
-	compareValue float64
-	metricValue  float64
-	timestamp    time.Time
-	mtx          sync.Mutex
+	desc        *collector.Desc
+	name        string
+	labels      collector.Labels
+	op          AlertOp
+	rule        AlertRule
+	metricValue float64
+	timestamp   time.Time
+
+	state AlertState
+	// pendingSince is the time condition begin to hold
+	pendingSince time.Time
+	// firingSince is the time Alert turned into firing, lastNotify is the
+	// time of last firing notification
+	firingSince time.Time
+	lastNotify  time.Time
+	// lastValue and lastTime are used to calculate rate of change
+	lastValue float64
+	lastTime  time.Time
+	mtx       sync.Mutex
 }
 
 // use opt form analyzer and labels specified by analyzer,
@@ -46,9 +97,26 @@ func NewAlertFromStr(
 	labels collector.Labels,
 	str string,
 ) (*Alert, error) {
-	if str == "none" || str == "" {
+	rule, err := ParseAlertRule(str)
+	if err != nil {
+		return nil, err
+	}
+	return NewAlert(mopt, labels, rule)
+}
+
+// NewAlert generates Alert from rule, nil is returned if rule is nil
+// or its op is "none".
+func NewAlert(
+	mopt *collector.Opts,
+	labels collector.Labels,
+	rule *AlertRule,
+) (*Alert, error) {
+	if rule == nil || rule.Op == "none" || rule.Op == "" {
 		return nil, nil
 	}
+	if err := rule.check(); err != nil {
+		return nil, err
+	}
 
 	name := mopt.Name + "(alert)"
 	help := fmt.Sprintf("Alert of metric %s.", mopt.Name)
@@ -59,66 +127,129 @@ func NewAlertFromStr(
 	for k, v := range labels {
 		constLabel[k] = v
 	}
-	constLabel["rules"] = str
+	constLabel["rules"] = rule.String()
 
-	cfg := strings.Split(str, ":")
-	if len(cfg) != 3 {
-		return nil, errors.WithStack(
-			fmt.Errorf("Alert config must have 3 fields, got %d", len(cfg)),
-		)
-	}
-	op, ok := Str2Op[cfg[0]]
-	if !ok {
-		return nil, errors.WithStack(
-			fmt.Errorf("Generate Alert error: unsupported op %s.", cfg[0]),
-		)
-	}
-	cv, err := strconv.ParseFloat(cfg[1], 64)
-	if err != nil {
-		return nil, errors.WithStack(
-			fmt.Errorf("Generate Alert error: %s.", err.Error()),
-		)
-	}
-	level, err := strconv.Atoi(cfg[2])
-	if err != nil || level < 1 || level > 4 {
-		return nil, errors.WithStack(
-			fmt.Errorf(
-				"Generate Alert error: level must between 0-4, got %s.",
-				cfg[2],
-			),
-		)
-	}
 	desc := collector.NewDesc(
 		name,
 		help,
-		collector.MetricLevel(level),
+		collector.MetricLevel(rule.Level),
 		mopt.Priority,
 		nil,
 		constLabel,
 	)
 
 	return &Alert{
-		desc:         desc,
-		compareValue: cv,
-		op:           op,
+		desc:   desc,
+		name:   name,
+		labels: constLabel,
+		op:     Str2Op[rule.Op],
+		rule:   *rule,
 	}, nil
 }
 
-// compare is used to compare CompareValue and metric value
-// if result is true, Alert should be collected
+// compare is used to compare the rule and metric value and move the
+// Alert through its lifecycle. If result is true, the state changed into
+// firing or resolved, or firing need to be notified again, and Alert
+// should be collected. Notification is sent to Notifier at the same time.
 func (a *Alert) compare(value float64, tp time.Time) bool {
+	var n *Notification
 	a.mtx.Lock()
-	defer a.mtx.Unlock()
+	emit := a.transit(value, tp)
+	if emit {
+		n = a.notification()
+	}
+	a.mtx.Unlock()
+
+	if n != nil {
+		notify(n)
+	}
+	return emit
+}
+
+// transit moves Alert to next state, returns true if Alert should be
+// collected, a.mtx must be held.
+func (a *Alert) transit(value float64, tp time.Time) bool {
+	if a.rule.Rate {
+		last, lastTime := a.lastValue, a.lastTime
+		a.lastValue, a.lastTime = value, tp
+		if lastTime.IsZero() || !tp.After(lastTime) {
+			return false
+		}
+		value = (value - last) / tp.Sub(lastTime).Seconds()
+	}
+
+	switch a.state {
+	case StateFiring:
+		if a.rule.recovered(a.op, value) {
+			a.state = StateResolved
+			a.setValue(value, tp)
+			return true
+		}
+		if a.rule.Renotify > 0 && tp.Sub(a.lastNotify) >= a.rule.Renotify {
+			a.lastNotify = tp
+			a.setValue(value, tp)
+			return true
+		}
+		return false
+	case StateInactive, StateResolved:
+		if !a.rule.match(a.op, value) {
+			a.state = StateInactive
+			return false
+		}
+		a.state = StatePending
+		a.pendingSince = tp
+	case StatePending:
+		if !a.rule.match(a.op, value) {
+			a.state = StateInactive
+			return false
+		}
+	}
+
+	// Alert is pending now
+	if tp.Sub(a.pendingSince) < a.rule.For {
+		return false
+	}
+	a.state = StateFiring
+	a.firingSince = tp
+	a.lastNotify = tp
+	a.setValue(value, tp)
+	return true
+}
+
+// setValue records the value and time of the notification
+func (a *Alert) setValue(value float64, tp time.Time) {
 	a.metricValue = value
 	a.timestamp = tp
-	switch a.op {
-	case Bigger:
-		return value > a.compareValue
-	case Smaller:
-		return value < a.compareValue
-	default:
-		return false
+}
+
+// notification generates Notification of current state, a.mtx must be held.
+func (a *Alert) notification() *Notification {
+	labels := make(map[string]string, len(a.labels)+1)
+	for k, v := range a.labels {
+		labels[k] = v
+	}
+	labels["state"] = a.state.String()
+	n := &Notification{
+		Metric:      a.name,
+		Labels:      labels,
+		Value:       a.metricValue,
+		Rule:        a.rule.String(),
+		Level:       a.rule.Level,
+		State:       a.state.String(),
+		Timestamp:   a.timestamp,
+		FiringStart: a.firingSince,
+	}
+	if a.state == StateFiring || a.state == StateResolved {
+		n.FiringDuration = a.timestamp.Sub(a.firingSince)
 	}
+	return n
+}
+
+// State returns the current state of Alert
+func (a *Alert) State() AlertState {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return a.state
 }
 
 func (a *Alert) Desc() *collector.Desc {
@@ -130,10 +261,23 @@ func (a *Alert) Write() (*module.Metric, error) {
 	defer a.mtx.Unlock()
 	result := &module.Metric{}
 	result.Event = &module.Event{
-		Value:     &a.metricValue,
+		Value:     proto.Float64(a.metricValue),
 		Timestamp: timestamppb.New(a.timestamp),
 	}
 	result.Label = collector.MakeLabelPairs(a.desc)
+	result.Label = append(result.Label, &module.LabelPair{
+		Name:  proto.String("state"),
+		Value: proto.String(a.state.String()),
+	})
+	if a.state == StateFiring || a.state == StateResolved {
+		result.Label = append(result.Label, &module.LabelPair{
+			Name:  proto.String("firing_start"),
+			Value: proto.String(a.firingSince.Format(time.RFC3339)),
+		}, &module.LabelPair{
+			Name:  proto.String("firing_duration"),
+			Value: proto.String(fmt.Sprint(a.timestamp.Sub(a.firingSince).Seconds())),
+		})
+	}
 	result.Priority = proto.Uint32(a.desc.GetPriority())
 	return result, nil
 }
@@ -0,0 +1,219 @@
+package analyzer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AlertRule is the rule used to generate Alert. It can be unmarshaled from
+// the old string form "OP:compareValue:Level", or a structured yaml block:
+//
+//	op: string(>, >=, <, <=, ==, !=, range, outside, bigger, smaller)
+//	value: float(compare value of >, >=, <, <=, ==, !=)
+//	low: float(lower bound of range and outside)
+//	high: float(upper bound of range and outside)
+//	recover: float(optional, the value must cross it before alert resolved)
+//	for: string(optional, condition must hold for this duration to fire)
+//	rate: bool(optional, compare rate of change per second instead of value)
+//	renotify: string(optional, interval to notify again while firing)
+//	level: int(1-4)
+type AlertRule struct {
+	Op       string        `yaml:"op"`
+	Value    float64       `yaml:"value,omitempty"`
+	Low      float64       `yaml:"low,omitempty"`
+	High     float64       `yaml:"high,omitempty"`
+	Recover  *float64      `yaml:"recover,omitempty"`
+	For      time.Duration `yaml:"for,omitempty"`
+	Rate     bool          `yaml:"rate,omitempty"`
+	Renotify time.Duration `yaml:"renotify,omitempty"`
+	Level    int           `yaml:"level"`
+
+	// raw is the old string form, which is kept as the "rules" label
+	raw string
+}
+
+// ParseAlertRule parses the old string form "OP:compareValue:Level",
+// nil is returned if str is "none", empty or of op "none".
+func ParseAlertRule(str string) (*AlertRule, error) {
+	if str == "none" || str == "" {
+		return nil, nil
+	}
+	cfg := strings.Split(str, ":")
+	if len(cfg) != 3 {
+		return nil, errors.WithStack(
+			fmt.Errorf("Alert config must have 3 fields, got %d", len(cfg)),
+		)
+	}
+	if cfg[0] == "none" {
+		return nil, nil
+	}
+	cv, err := strconv.ParseFloat(cfg[1], 64)
+	if err != nil {
+		return nil, errors.WithStack(
+			fmt.Errorf("Generate Alert error: %s.", err.Error()),
+		)
+	}
+	level, err := strconv.Atoi(cfg[2])
+	if err != nil {
+		return nil, errors.WithStack(
+			fmt.Errorf(
+				"Generate Alert error: level must between 0-4, got %s.",
+				cfg[2],
+			),
+		)
+	}
+	rule := &AlertRule{
+		Op:    cfg[0],
+		Value: cv,
+		Level: level,
+		raw:   str,
+	}
+	if err := rule.check(); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (r *AlertRule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err == nil {
+		rule, err := ParseAlertRule(str)
+		if err != nil {
+			return err
+		}
+		if rule == nil {
+			rule = &AlertRule{Op: "none"}
+		}
+		*r = *rule
+		return nil
+	}
+
+	type plain AlertRule
+	if err := unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+	if r.Op == "none" {
+		return nil
+	}
+	return r.check()
+}
+
+// check makes sure the rule is legal
+func (r *AlertRule) check() error {
+	op, ok := Str2Op[r.Op]
+	if !ok || op == NoneOp {
+		return errors.WithStack(
+			fmt.Errorf("Generate Alert error: unsupported op %s.", r.Op),
+		)
+	}
+	if r.Level < 1 || r.Level > 4 {
+		return errors.WithStack(
+			fmt.Errorf("Generate Alert error: level must between 0-4, got %d.", r.Level),
+		)
+	}
+	if r.For < 0 || r.Renotify < 0 {
+		return errors.WithStack(
+			fmt.Errorf("Generate Alert error: for and renotify must not be negative, got %s and %s.", r.For, r.Renotify),
+		)
+	}
+	if (op == InRange || op == OutRange) && r.Low > r.High {
+		return errors.WithStack(
+			fmt.Errorf("Generate Alert error: low %g is bigger than high %g.", r.Low, r.High),
+		)
+	}
+	if r.Recover == nil {
+		return nil
+	}
+	switch op {
+	case Bigger, BiggerEq:
+		if *r.Recover > r.Value {
+			return errors.WithStack(
+				fmt.Errorf("Generate Alert error: recover %g must not be bigger than %g.", *r.Recover, r.Value),
+			)
+		}
+	case Smaller, SmallerEq:
+		if *r.Recover < r.Value {
+			return errors.WithStack(
+				fmt.Errorf("Generate Alert error: recover %g must not be smaller than %g.", *r.Recover, r.Value),
+			)
+		}
+	default:
+		return errors.WithStack(
+			fmt.Errorf("Generate Alert error: op %s does not support recover.", r.Op),
+		)
+	}
+	return nil
+}
+
+// String returns the string used as "rules" label. The old string form is
+// returned as it is, structured rule is joined by ":" to keep label values
+// free of space, comma and equal sign.
+func (r *AlertRule) String() string {
+	if r.raw != "" {
+		return r.raw
+	}
+	fields := []string{r.Op}
+	switch Str2Op[r.Op] {
+	case InRange, OutRange:
+		fields = append(fields, fmt.Sprintf("%g~%g", r.Low, r.High))
+	default:
+		fields = append(fields, fmt.Sprint(r.Value))
+	}
+	fields = append(fields, fmt.Sprint(r.Level))
+	if r.Recover != nil {
+		fields = append(fields, fmt.Sprintf("recover%g", *r.Recover))
+	}
+	if r.For > 0 {
+		fields = append(fields, "for"+r.For.String())
+	}
+	if r.Rate {
+		fields = append(fields, "rate")
+	}
+	if r.Renotify > 0 {
+		fields = append(fields, "renotify"+r.Renotify.String())
+	}
+	return strings.Join(fields, ":")
+}
+
+// match returns true if value fulfill the alert condition
+func (r *AlertRule) match(op AlertOp, value float64) bool {
+	switch op {
+	case Bigger:
+		return value > r.Value
+	case BiggerEq:
+		return value >= r.Value
+	case Smaller:
+		return value < r.Value
+	case SmallerEq:
+		return value <= r.Value
+	case Equal:
+		return value == r.Value
+	case NotEqual:
+		return value != r.Value
+	case InRange:
+		return value >= r.Low && value <= r.High
+	case OutRange:
+		return value < r.Low || value > r.High
+	default:
+		return false
+	}
+}
+
+// recovered returns true if a firing alert should be resolved
+func (r *AlertRule) recovered(op AlertOp, value float64) bool {
+	if r.Recover == nil {
+		return !r.match(op, value)
+	}
+	switch op {
+	case Bigger, BiggerEq:
+		return value < *r.Recover
+	case Smaller, SmallerEq:
+		return value > *r.Recover
+	default:
+		return !r.match(op, value)
+	}
+}
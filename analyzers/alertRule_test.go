@@ -0,0 +1,163 @@
+package analyzer
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	"wanggj.com/abyss/collector"
+)
+
+var yamlAlertRules = `
+rules:
+- "bigger:6.8:3"
+- ">=:5:2"
+- "none"
+- op: outside
+  low: 1
+  high: 5
+  level: 3
+- op: ">"
+  value: 90
+  recover: 80
+  for: 2s
+  level: 4
+- op: ">"
+  value: 10
+  rate: true
+  level: 2
+- "none:9.8:3"
+`
+
+func TestAlertRuleYaml(t *testing.T) {
+	var cfg struct {
+		Rules []*AlertRule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal([]byte(yamlAlertRules), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Rules) != 7 {
+		t.Fatalf("Expected 7 rules, got %d.", len(cfg.Rules))
+	}
+	if r := cfg.Rules[0]; r.Op != "bigger" || r.Value != 6.8 || r.Level != 3 || r.String() != "bigger:6.8:3" {
+		t.Errorf("Old string form parsed wrong: %+v.", *r)
+	}
+	if r := cfg.Rules[1]; r.Op != ">=" || r.Value != 5 || r.Level != 2 {
+		t.Errorf("New op in string form parsed wrong: %+v.", *r)
+	}
+	if r := cfg.Rules[4]; r.Recover == nil || *r.Recover != 80 || r.For != 2*time.Second {
+		t.Errorf("Structured rule parsed wrong: %+v.", *r)
+	}
+	t.Log(cfg.Rules[4].String())
+
+	for _, idx := range []int{2, 6} {
+		a, err := NewAlert(&collector.Opts{Name: "test"}, nil, cfg.Rules[idx])
+		if a != nil || err != nil {
+			t.Errorf("Expected nil alert of none rule %d, got %v, %v.", idx, a, err)
+		}
+	}
+}
+
+func TestAlertRuleIllegal(t *testing.T) {
+	rules := []string{
+		"op: '=>'\nvalue: 1\nlevel: 3",
+		"op: range\nlow: 5\nhigh: 1\nlevel: 3",
+		"op: '>'\nvalue: 5\nrecover: 8\nlevel: 3",
+		"op: '<'\nvalue: 5\nrecover: 3\nlevel: 3",
+		"op: '=='\nvalue: 5\nrecover: 3\nlevel: 3",
+		"op: '>'\nvalue: 5\nlevel: 0",
+		"'>:5'",
+	}
+	for idx, str := range rules {
+		var r AlertRule
+		if err := yaml.Unmarshal([]byte(str), &r); err == nil {
+			t.Errorf("Expected error of %dth rule, got %+v.", idx, r)
+		}
+	}
+}
+
+func newTestAlert(t *testing.T, rule *AlertRule) *Alert {
+	a, err := NewAlert(&collector.Opts{
+		Name:  "test",
+		Help:  "this is help",
+		Level: collector.LevelInfo,
+	}, nil, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAlertOps(t *testing.T) {
+	cases := []struct {
+		rule   AlertRule
+		values []float64
+		expect []bool
+	}{
+		{AlertRule{Op: ">=", Value: 5, Level: 3}, []float64{4, 5, 6}, []bool{false, true, true}},
+		{AlertRule{Op: "<=", Value: 5, Level: 3}, []float64{4, 5, 6}, []bool{true, true, false}},
+		{AlertRule{Op: "==", Value: 5, Level: 3}, []float64{4, 5, 6}, []bool{false, true, false}},
+		{AlertRule{Op: "!=", Value: 5, Level: 3}, []float64{4, 5, 6}, []bool{true, false, true}},
+		{AlertRule{Op: "range", Low: 1, High: 5, Level: 3}, []float64{0, 3, 6}, []bool{false, true, false}},
+		{AlertRule{Op: "outside", Low: 1, High: 5, Level: 3}, []float64{0, 3, 6}, []bool{true, false, true}},
+	}
+	for idx, c := range cases {
+		a := newTestAlert(t, &c.rule)
+		for i, v := range c.values {
+			a.compare(v, time.Now())
+			if got := a.State() == StateFiring; got != c.expect[i] {
+				t.Errorf("Case %d value %g expected %t, got %t.", idx, v, c.expect[i], got)
+			}
+		}
+	}
+}
+
+func TestAlertForAndRecover(t *testing.T) {
+	recoverValue := 80.0
+	a := newTestAlert(t, &AlertRule{
+		Op:      ">",
+		Value:   90,
+		Recover: &recoverValue,
+		For:     time.Minute,
+		Level:   3,
+	})
+	now := time.Now()
+	steps := []struct {
+		offset time.Duration
+		value  float64
+		expect bool
+	}{
+		{0, 95, false},                // pending
+		{30 * time.Second, 95, false}, // still pending
+		{40 * time.Second, 85, false}, // condition broken, reset pending
+		{50 * time.Second, 95, false}, // pending again
+		{2 * time.Minute, 95, true},   // hold for a minute, fire
+		{3 * time.Minute, 85, true},   // above recover threshold, keep firing
+		{4 * time.Minute, 75, false},  // recovered
+	}
+	for idx, s := range steps {
+		a.compare(s.value, now.Add(s.offset))
+		if got := a.State() == StateFiring; got != s.expect {
+			t.Errorf("Step %d expected %t, got %t.", idx, s.expect, got)
+		}
+	}
+}
+
+func TestAlertRate(t *testing.T) {
+	a := newTestAlert(t, &AlertRule{Op: ">", Value: 10, Rate: true, Level: 3})
+	now := time.Now()
+	if a.compare(100, now) {
+		t.Error("Rate alert should not fire without last value.")
+	}
+	// 5 per second
+	if a.compare(110, now.Add(2*time.Second)) {
+		t.Error("Rate alert should not fire when rate is 5.")
+	}
+	// 20 per second
+	if !a.compare(150, now.Add(4*time.Second)) {
+		t.Error("Rate alert should fire when rate is 20.")
+	}
+	if a.metricValue != 20 {
+		t.Errorf("Rate alert should report rate 20, got %g.", a.metricValue)
+	}
+}
@@ -76,3 +76,90 @@ func TestAlertComp(t *testing.T) {
 		t.Log(a.metricValue)
 	}
 }
+
+func alertLabels(t *testing.T, a *Alert) map[string]string {
+	m, err := a.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := map[string]string{}
+	for _, lp := range m.Label {
+		result[lp.GetName()] = lp.GetValue()
+	}
+	return result
+}
+
+// recordNotifier keeps the last Notification
+type recordNotifier struct {
+	last *Notification
+}
+
+func (r *recordNotifier) Notify(n *Notification) {
+	r.last = n
+}
+
+func TestAlertLifecycle(t *testing.T) {
+	recorder := &recordNotifier{}
+	SetNotifier(recorder)
+	defer SetNotifier(nil)
+
+	desc := collector.Opts{
+		Name:     "test",
+		Help:     "this is help",
+		Level:    collector.LevelInfo,
+		Priority: 222,
+	}
+	a, err := NewAlert(&desc, nil, &AlertRule{
+		Op:       ">",
+		Value:    10,
+		For:      time.Minute,
+		Renotify: 5 * time.Minute,
+		Level:    3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	steps := []struct {
+		offset time.Duration
+		value  float64
+		emit   bool
+		state  AlertState
+	}{
+		{0, 5, false, StateInactive},
+		{time.Minute, 20, false, StatePending},
+		{2 * time.Minute, 20, true, StateFiring},
+		{3 * time.Minute, 20, false, StateFiring},
+		{7 * time.Minute, 20, true, StateFiring}, // renotify
+		{8 * time.Minute, 20, false, StateFiring},
+		{9 * time.Minute, 5, true, StateResolved},
+		{10 * time.Minute, 5, false, StateInactive},
+	}
+	for idx, s := range steps {
+		emit := a.compare(s.value, now.Add(s.offset))
+		if emit != s.emit || a.State() != s.state {
+			t.Fatalf(
+				"Step %d expected emit %t state %s, got %t %s.",
+				idx, s.emit, s.state, emit, a.State(),
+			)
+		}
+		if !emit {
+			continue
+		}
+		labels := alertLabels(t, a)
+		if labels["state"] != s.state.String() || labels["rules"] == "" {
+			t.Errorf("Step %d got wrong labels %v.", idx, labels)
+		}
+		start := now.Add(2 * time.Minute)
+		duration := s.offset - 2*time.Minute
+		if labels["firing_start"] != start.Format(time.RFC3339) ||
+			labels["firing_duration"] != fmt.Sprint(duration.Seconds()) {
+			t.Errorf("Step %d got wrong firing labels %v.", idx, labels)
+		}
+		n := recorder.last
+		if n == nil || n.State != s.state.String() || !n.FiringStart.Equal(start) || n.FiringDuration != duration {
+			t.Fatalf("Step %d got wrong notification %+v.", idx, n)
+		}
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"wanggj.com/abyss/collector"
 )
 
+// RawMessage delays decoding of opt until its type is known. It is decoded
+// by the decoder of the whole document, so unknown fields are rejected with
+// line numbers if the document is decoded by yaml.UnmarshalStrict.
 type RawMessage struct {
 	unmarshal func(interface{}) error
 }
@@ -16,6 +19,9 @@ func (msg *RawMessage) UnmarshalYAML(unmarshal func(interface{}) error) error {
 }
 
 func (msg *RawMessage) Unmarshal(v interface{}) error {
+	if msg.unmarshal == nil {
+		return fmt.Errorf("Opt is empty.")
+	}
 	return msg.unmarshal(v)
 }
 
@@ -28,58 +34,77 @@ type StatelessAnaOpt interface {
 	NewStatelessAna() (collector.StatelessAnalyzer, error)
 }
 
+// AnaConfig is the config of an analyzer. Analyzers can be chained into a
+// pipeline by Input, such as rate -> quantile.
 type AnaConfig struct {
 	Type string     `yaml:"type"`
 	Opt  RawMessage `yaml:"opt"`
+	// Name is used to refer the analyzer by Input of other analyzers
+	Name string `yaml:"name,omitempty"`
+	// Input is Name of the upstream analyzer in the same pusher, whose
+	// output is the input of this analyzer. Raw data of the pusher is the
+	// input if it is empty.
+	Input string `yaml:"input,omitempty"`
+	// Export is false if output of the analyzer is only fed into its
+	// downstream analyzers, default is true.
+	Export *bool `yaml:"export,omitempty"`
+	// Persist is true if the state of analyzer is saved and restored after
+	// abyss restarts, the analyzer must implement collector.PersistentAnalyzer.
+	Persist bool `yaml:"persist,omitempty"`
+	// Labels are added into ConstLabels of the opt, such as metadata of
+	// the process, they are set by abyss instead of config file.
+	Labels collector.Labels `yaml:"-"`
+}
+
+// Exported returns true if output of the analyzer should be collected
+func (c *AnaConfig) Exported() bool {
+	return c.Export == nil || *c.Export
+}
+
+func init() {
+	MustRegister("aggregation", func() AnaOpt { return &AggregationOpts{} })
+	MustRegister("quantile", func() AnaOpt { return &QuantileOpts{} })
+	MustRegister("histogram", func() AnaOpt { return &HistogramOpts{} })
+	MustRegister("anomaly", func() AnaOpt {
+		return &AnomalyOpts{WarmUp: defaultAnomalyWarmUp}
+	})
+	MustRegister("rate", func() AnaOpt { return &RateOpts{} })
+	MustRegister("topk", func() AnaOpt { return &TopKOpts{Mode: "count"} })
+	MustRegister("trend", func() AnaOpt {
+		return &TrendOpts{Direction: "up", MinPoints: defaultTrendMinPoints}
+	})
 }
 
 // GetAnaOptFromConfig is is used to parse AnaConfig into AnaOpt, which will be used
-// to generate Analyzer. Every Analyzer should sign in here.
+// to generate Analyzer. Every Analyzer should sign in by Register, the
+// returned AnaOpt has PID and config.Labels set in ConstLabels.
 func GetAnaOptFromConfig(pid uint32, config AnaConfig) (interface{}, error) {
-	var (
-		result interface{}
-		err    error
-	)
-	switch config.Type {
-	// all Analyzer should get install here to allow config
-	// the code is like:
-	//
-	// opt := anaOPt{} // correct type
-	// err := config.Opt.Unmarshal(&opt)
-	// if err != nil {
-	// 	return nil, err
-	// }
-	// set PID in ConstLabels
-	// return opt, nil
-	case "aggregation":
-		aggcfg := AggregationOpts{}
-		err = config.Opt.Unmarshal(&aggcfg)
-		if err != nil {
-			result = nil
-			break
-		}
-		if aggcfg.ConstLabels == nil {
-			aggcfg.ConstLabels = collector.Labels{}
-		}
-		aggcfg.ConstLabels["PID"] = fmt.Sprint(pid)
-		result, err = &aggcfg, nil
-	case "quantile":
-		quancfg := QuantileOpts{}
-		err := config.Opt.Unmarshal(&quancfg)
-		if err != nil {
-			result = nil
-			break
-		}
-		if quancfg.ConstLabels == nil {
-			quancfg.ConstLabels = collector.Labels{}
-		}
-		quancfg.ConstLabels["PID"] = fmt.Sprint(pid)
-		result, err = &quancfg, nil
-	default:
-		err = fmt.Errorf("Unrecongnized config type %q", config.Type)
-		result = nil
+	opt, err := decodeAnaOpt(config, "PID", fmt.Sprint(pid))
+	if err != nil {
+		return nil, err
+	}
+	for n, v := range config.Labels {
+		opt.SetConstLabel(n, v)
+	}
+	return opt, nil
+}
+
+// decodeAnaOpt decodes opt of config and sets the const label name, which
+// identifies where data analyzed comes from.
+func decodeAnaOpt(config AnaConfig, name, value string) (AnaOpt, error) {
+	opt, err := newAnaOpt(config.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Opt.Unmarshal(opt); err != nil {
+		return nil, fmt.Errorf(
+			"Decode opt of analyzer %q error: %s",
+			config.Type,
+			err.Error(),
+		)
 	}
-	return result, err
+	opt.SetConstLabel(name, value)
+	return opt, nil
 }
 
 func GetSfaFromConfig(pid uint32, config AnaConfig) (collector.StatefulAnalyzer, error) {
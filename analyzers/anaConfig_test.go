@@ -7,6 +7,7 @@ import (
 
 	"gopkg.in/yaml.v2"
 	analyzer "wanggj.com/abyss/analyzers"
+	"wanggj.com/abyss/collector"
 )
 
 // Test all Analyzer config file resolvation
@@ -73,6 +74,83 @@ analyzers:
     targets:
 `
 
+var yamlHistogram = `
+analyzers:
+- type: "histogram"
+  opt:
+    desc:
+      name: histogram_test
+      help: this is a histogram analyzer test
+      level: 2
+      priority: 222
+      constLabels:
+        name: 3333
+    buckets:
+      type: exponential
+      start: 0.001
+      factor: 2
+      count: 10
+    alerts:
+      0.064: "smaller:0.9:3"
+- type: "histogram"
+  opt:
+    desc:
+      name: histogram_explicit
+      help: this is a histogram analyzer test
+      level: 2
+    buckets:
+      type: explicit
+      bounds: [0.1, 0.5, 1, 5]
+`
+
+var yamlAnomaly = `
+analyzers:
+- type: "anomaly"
+  opt:
+    desc:
+      name: anomaly_test
+      help: this is an anomaly analyzer test
+      level: 2
+    alpha: 0.2
+    alert: "bigger:3:3"
+- type: "anomaly"
+  opt:
+    desc:
+      name: anomaly_seasonal
+      help: this is a seasonal anomaly analyzer test
+      level: 2
+    alpha: 0.2
+    seasonal:
+      period: 60
+      beta: 0.05
+      gamma: 0.3
+`
+
+var yamlTopK = `
+analyzers:
+- type: "topk"
+  opt:
+    desc:
+      name: topk_test
+      help: this is a topk analyzer test
+      level: 2
+    k: 5
+    mode: sum
+    window: 1m
+`
+
+var yamlTrend = `
+analyzers:
+- type: "trend"
+  opt:
+    desc:
+      name: trend_test
+      help: this is a trend analyzer test
+      level: 2
+    threshold: 1024
+    alert: "smaller:3600:3"
+`
+
 // Test aggregation
 func TestAggregationConfig(t *testing.T) {
 	var cfgs TestAnaConfigs
@@ -109,6 +187,23 @@ func TestAggregationConfig(t *testing.T) {
 	}
 }
 
+func TestAnaConfigLabels(t *testing.T) {
+	var cfgs TestAnaConfigs
+	if err := yaml.Unmarshal([]byte(yamlAggregation), &cfgs); err != nil {
+		t.Fatal(err)
+	}
+	cfg := cfgs.AnaConfigs[0]
+	cfg.Labels = collector.Labels{"exe_name": "app"}
+	ram, err := analyzer.GetAnaOptFromConfig(111, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := ram.(*analyzer.AggregationOpts)
+	if opt.ConstLabels["exe_name"] != "app" || opt.ConstLabels["PID"] != "111" {
+		t.Errorf("Expected labels of process, got %v.", opt.ConstLabels)
+	}
+}
+
 func TestQuantileConfig(t *testing.T) {
 	var cfgs TestAnaConfigs
 
@@ -145,11 +240,43 @@ func TestQuantileConfig(t *testing.T) {
 	}
 }
 
+func TestHistogramConfig(t *testing.T) {
+	var cfgs TestAnaConfigs
+
+	err := yaml.Unmarshal([]byte(yamlHistogram), &cfgs)
+	if err != nil {
+		t.Fatalf("simple Histogram config unmarshal got error: %s", err.Error())
+	}
+
+	for idx, cfg := range cfgs.AnaConfigs {
+		ram, err := analyzer.GetAnaOptFromConfig(111, cfg)
+		if err != nil {
+			t.Fatalf(
+				"Got error when resolve %dth config, type is %s, error: %s",
+				idx,
+				cfg.Type,
+				err.Error(),
+			)
+		}
+
+		opt, ok := ram.(*analyzer.HistogramOpts)
+		if !ok {
+			t.Fatalf("When resolve %dth histogram config, didn't got right type.", idx)
+		}
+
+		his, err := analyzer.NewHistogramAna(opt)
+		if err != nil {
+			t.Errorf("Can't get histogram analyzer from opt, error: %s", err.Error())
+		}
+		t.Log(his)
+	}
+}
+
 func TestStatefulAnaGen(t *testing.T) {
 	var cfgs TestAnaConfigs
 	var tmpcfgs TestAnaConfigs
 
-	testConfigs := []string{yamlQuantile}
+	testConfigs := []string{yamlQuantile, yamlHistogram, yamlAnomaly, yamlTopK}
 	for _, str := range testConfigs {
 		err := yaml.Unmarshal([]byte(str), &tmpcfgs)
 		if err != nil {
@@ -199,7 +326,7 @@ func TestStatelessAnaGen(t *testing.T) {
 	var cfgs TestAnaConfigs
 	var tmpcfgs TestAnaConfigs
 
-	testConfigs := []string{yamlQuantile, yamlAggregation}
+	testConfigs := []string{yamlQuantile, yamlAggregation, yamlHistogram, yamlTopK, yamlTrend}
 	for _, str := range testConfigs {
 		err := yaml.Unmarshal([]byte(str), &tmpcfgs)
 		if err != nil {
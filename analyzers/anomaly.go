@@ -0,0 +1,326 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+)
+
+const (
+	defaultAnomalyWarmUp = 10
+	// maxAnomalyScore is sent if data differs from a flat baseline
+	maxAnomalyScore = 1e6
+)
+
+// AnomalyDetector is a stateful analyzer. It keeps an EWMA baseline (or a
+// Holt-Winters seasonal baseline) of data observed, and scores every new data
+// by how many standard deviations it is away from the expected value. Data
+// differing from a flat baseline, whose deviation is 0, gets the max score.
+//
+// Each time Collect is called, the biggest score since last Collect is sent
+// as a gauge, and the score is compared by Alert.
+type AnomalyDetector struct {
+	Desc   *collector.Desc
+	Alpha  float64
+	WarmUp int
+
+	alert    *Alert
+	seasonal *holtWinters
+
+	count    int
+	mean     float64
+	variance float64
+	// score is the biggest score since last Collect, scored is false
+	// if no data has been scored since last Collect
+	score  float64
+	scored bool
+	mtx    sync.Mutex
+}
+
+// holtWinters is the additive Holt-Winters baseline, period is the number
+// of data in one season.
+type holtWinters struct {
+	period int
+	beta   float64
+	gamma  float64
+
+	level  float64
+	trend  float64
+	season []float64
+	// init store data of the first season, used to initialize level and season
+	init []float64
+	idx  int
+}
+
+// expect returns the forecast of next data, ok is false during initialization.
+func (hw *holtWinters) expect() (float64, bool) {
+	if len(hw.init) < hw.period {
+		return 0, false
+	}
+	return hw.level + hw.trend + hw.season[hw.idx], true
+}
+
+func (hw *holtWinters) update(value, alpha float64) {
+	if len(hw.init) < hw.period {
+		hw.init = append(hw.init, value)
+		if len(hw.init) == hw.period {
+			sum := 0.0
+			for _, v := range hw.init {
+				sum += v
+			}
+			hw.level = sum / float64(hw.period)
+			for i, v := range hw.init {
+				hw.season[i] = v - hw.level
+			}
+		}
+		return
+	}
+	lastLevel := hw.level
+	s := hw.season[hw.idx]
+	hw.level = alpha*(value-s) + (1-alpha)*(hw.level+hw.trend)
+	hw.trend = hw.beta*(hw.level-lastLevel) + (1-hw.beta)*hw.trend
+	hw.season[hw.idx] = hw.gamma*(value-hw.level) + (1-hw.gamma)*s
+	hw.idx = (hw.idx + 1) % hw.period
+}
+
+func (a *AnomalyDetector) Describe(ch chan<- *collector.Desc) {
+	ch <- a.Desc
+}
+
+// observe scores value against the baseline, then update the baseline
+func (a *AnomalyDetector) observe(value float64) {
+	var (
+		expected float64
+		ok       bool
+	)
+	if a.seasonal != nil {
+		expected, ok = a.seasonal.expect()
+	} else {
+		expected, ok = a.mean, a.count > 0
+	}
+
+	if ok {
+		residual := value - expected
+		if a.count >= a.WarmUp {
+			std := math.Sqrt(a.variance)
+			score := 0.0
+			switch {
+			case residual == 0:
+			case std == 0:
+				score = maxAnomalyScore
+			default:
+				score = math.Min(math.Abs(residual)/std, maxAnomalyScore)
+			}
+			if !a.scored || score > a.score {
+				a.score = score
+			}
+			a.scored = true
+		}
+		if a.seasonal != nil {
+			a.variance = (1-a.Alpha)*a.variance + a.Alpha*residual*residual
+		} else {
+			incr := a.Alpha * residual
+			a.mean += incr
+			a.variance = (1 - a.Alpha) * (a.variance + residual*incr)
+		}
+	} else if a.seasonal == nil {
+		a.mean = value
+	}
+
+	if a.seasonal != nil {
+		a.seasonal.update(value, a.Alpha)
+	}
+	a.count++
+}
+
+func (a *AnomalyDetector) Observe(data *pushFunc.DataPair) {
+	a.mtx.Lock()
+	a.observe(data.Value)
+	a.mtx.Unlock()
+}
+
+func (a *AnomalyDetector) Collect(ch chan<- collector.Metric) {
+	a.mtx.Lock()
+	if !a.scored {
+		a.mtx.Unlock()
+		return
+	}
+	score := a.score
+	a.scored = false
+	a.mtx.Unlock()
+
+	tp := time.Now()
+	cm, err := collector.NewConstMetric(
+		a.Desc,
+		collector.GaugeValue,
+		score,
+	)
+	if err != nil {
+		glog.Error(err)
+		return
+	}
+	ch <- collector.NewTimeStampMetric(tp, cm)
+
+	if a.alert != nil && a.alert.compare(score, tp) {
+		ch <- a.alert
+	}
+}
+
+// anomalyState is the state of AnomalyDetector saved by MarshalState, the
+// score not collected yet is not saved.
+type anomalyState struct {
+	Count    int     `json:"count"`
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+	// Holt-Winters baseline
+	Period int       `json:"period,omitempty"`
+	Level  float64   `json:"level,omitempty"`
+	Trend  float64   `json:"trend,omitempty"`
+	Season []float64 `json:"season,omitempty"`
+	Init   []float64 `json:"init,omitempty"`
+	Idx    int       `json:"idx,omitempty"`
+}
+
+func (a *AnomalyDetector) MarshalState() ([]byte, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	state := &anomalyState{
+		Count:    a.count,
+		Mean:     a.mean,
+		Variance: a.variance,
+	}
+	if hw := a.seasonal; hw != nil {
+		state.Period = hw.period
+		state.Level = hw.level
+		state.Trend = hw.trend
+		state.Season = hw.season
+		state.Init = hw.init
+		state.Idx = hw.idx
+	}
+	return json.Marshal(state)
+}
+
+func (a *AnomalyDetector) UnmarshalState(data []byte) error {
+	state := anomalyState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if hw := a.seasonal; hw != nil {
+		if state.Period != hw.period || len(state.Season) != hw.period ||
+			len(state.Init) > hw.period || state.Idx < 0 || state.Idx >= hw.period {
+			return fmt.Errorf("Anomaly state does not match seasonal period %d.", hw.period)
+		}
+		hw.level = state.Level
+		hw.trend = state.Trend
+		copy(hw.season, state.Season)
+		hw.init = append(hw.init[:0], state.Init...)
+		hw.idx = state.Idx
+	} else if state.Period != 0 {
+		return fmt.Errorf("Anomaly state of seasonal baseline does not match.")
+	}
+	a.count = state.Count
+	a.mean = state.Mean
+	a.variance = state.Variance
+	return nil
+}
+
+// SeasonalOpts enables the Holt-Winters baseline, Period is the number of
+// data in one season, Beta and Gamma are smoothing factors of trend and
+// season, both must be in (0, 1].
+type SeasonalOpts struct {
+	Period int     `yaml:"period"`
+	Beta   float64 `yaml:"beta"`
+	Gamma  float64 `yaml:"gamma"`
+}
+
+// AnomalyOpts is used to generate AnomalyDetector, Alpha is the smoothing
+// factor of EWMA in (0, 1]. No score is generated before WarmUp data have
+// been observed, when Seasonal is set, WarmUp must be no less than two
+// seasons. Alert compares the score, which is the number of standard
+// deviations.
+//
+// AnomalyOpts implements interface StatefulAnaOpt
+type AnomalyOpts struct {
+	collector.Opts `yaml:"desc"`
+	Alpha          float64       `yaml:"alpha"`
+	WarmUp         int           `yaml:"warmup,omitempty"`
+	Seasonal       *SeasonalOpts `yaml:"seasonal,omitempty"`
+	Alert          *AlertRule    `yaml:"alert,omitempty"`
+}
+
+func NewAnomalyDetector(opt *AnomalyOpts) (*AnomalyDetector, error) {
+	if opt.Alpha <= 0 || opt.Alpha > 1 {
+		return nil, fmt.Errorf("Anomaly alpha must in (0, 1], got %g.", opt.Alpha)
+	}
+	if err := checkOptLabels(opt.ConstLabels, []string{"analyzer"}); err != nil {
+		return nil, err
+	}
+
+	warmUp := opt.WarmUp
+	if warmUp <= 0 {
+		warmUp = defaultAnomalyWarmUp
+	}
+	var hw *holtWinters
+	if opt.Seasonal != nil {
+		s := opt.Seasonal
+		if s.Period < 2 {
+			return nil, fmt.Errorf("Seasonal period must be bigger than 1, got %d.", s.Period)
+		}
+		if s.Beta <= 0 || s.Beta > 1 || s.Gamma <= 0 || s.Gamma > 1 {
+			return nil, fmt.Errorf("Seasonal beta and gamma must in (0, 1], got %g and %g.", s.Beta, s.Gamma)
+		}
+		if warmUp < 2*s.Period {
+			warmUp = 2 * s.Period
+		}
+		hw = &holtWinters{
+			period: s.Period,
+			beta:   s.Beta,
+			gamma:  s.Gamma,
+			season: make([]float64, s.Period),
+			init:   make([]float64, 0, s.Period),
+		}
+	}
+
+	newLabels := collector.Labels{}
+	for n, v := range opt.ConstLabels {
+		newLabels[n] = v
+	}
+	newLabels["analyzer"] = "Anomaly"
+	desc := collector.NewDesc(
+		opt.Name,
+		opt.Help,
+		opt.Level,
+		opt.Priority,
+		nil,
+		newLabels,
+	)
+
+	alert, err := NewAlert(
+		&opt.Opts,
+		collector.Labels{"analyzer": "Anomaly"},
+		opt.Alert,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AnomalyDetector{
+		Desc:     desc,
+		Alpha:    opt.Alpha,
+		WarmUp:   warmUp,
+		alert:    alert,
+		seasonal: hw,
+	}, nil
+}
+
+func (ao *AnomalyOpts) NewStatefulAna() (collector.StatefulAnalyzer, error) {
+	return NewAnomalyDetector(ao)
+}
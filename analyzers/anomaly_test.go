@@ -0,0 +1,162 @@
+package analyzer_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	analyzer "wanggj.com/abyss/analyzers"
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+)
+
+func newTestAnomalyOpts() *analyzer.AnomalyOpts {
+	return &analyzer.AnomalyOpts{
+		Opts: collector.Opts{
+			Name:  "anomaly",
+			Help:  "this is anomaly",
+			Level: collector.LevelInfo,
+			ConstLabels: collector.Labels{
+				"a": "a",
+			},
+		},
+		Alpha:  0.3,
+		WarmUp: 5,
+		Alert:  &analyzer.AlertRule{Op: ">", Value: 3, Level: 3},
+	}
+}
+
+func TestAnomalyEWMA(t *testing.T) {
+	sfa, err := newTestAnomalyOpts().NewStatefulAna()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := []float64{10, 11, 9, 10}
+	for _, v := range values {
+		sfa.Observe(pushFunc.NewDataPair(v, time.Now()))
+	}
+	if score, _ := collectGauge(t, sfa.Collect); !math.IsNaN(score) {
+		t.Fatalf("Expected no score during warm up, got %g.", score)
+	}
+
+	for i := 0; i < 20; i++ {
+		sfa.Observe(pushFunc.NewDataPair(values[i%len(values)], time.Now()))
+	}
+	score, alerts := collectGauge(t, sfa.Collect)
+	if math.IsNaN(score) || score > 3 || alerts != 0 {
+		t.Fatalf("Expected normal score without alert, got %g and %d alerts.", score, alerts)
+	}
+
+	sfa.Observe(pushFunc.NewDataPair(100, time.Now()))
+	score, alerts = collectGauge(t, sfa.Collect)
+	if score <= 3 || alerts != 1 {
+		t.Fatalf("Expected anomaly alert, got score %g and %d alerts.", score, alerts)
+	}
+}
+
+func TestAnomalyFlat(t *testing.T) {
+	sfa, err := newTestAnomalyOpts().NewStatefulAna()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		sfa.Observe(pushFunc.NewDataPair(10, time.Now()))
+	}
+	if score, alerts := collectGauge(t, sfa.Collect); score != 0 || alerts != 0 {
+		t.Fatalf("Expected zero score of flat data, got %g and %d alerts.", score, alerts)
+	}
+
+	// any change of flat data is an anomaly
+	sfa.Observe(pushFunc.NewDataPair(11, time.Now()))
+	if score, alerts := collectGauge(t, sfa.Collect); score <= 3 || alerts != 1 {
+		t.Fatalf("Expected anomaly alert after flat data, got score %g and %d alerts.", score, alerts)
+	}
+}
+
+func TestAnomalySeasonal(t *testing.T) {
+	opt := newTestAnomalyOpts()
+	opt.Seasonal = &analyzer.SeasonalOpts{Period: 4, Beta: 0.1, Gamma: 0.3}
+	sfa, err := opt.NewStatefulAna()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a season with big amplitude should not be regarded as anomaly
+	season := []float64{10, 50, 90, 50}
+	for i := 0; i < 40; i++ {
+		sfa.Observe(pushFunc.NewDataPair(season[i%4]+float64(i%3)*0.5, time.Now()))
+	}
+	score, alerts := collectGauge(t, sfa.Collect)
+	if math.IsNaN(score) || alerts != 0 {
+		t.Fatalf("Expected seasonal data without alert, got score %g and %d alerts.", score, alerts)
+	}
+
+	// the next expected value is about 10
+	sfa.Observe(pushFunc.NewDataPair(90, time.Now()))
+	if score, alerts = collectGauge(t, sfa.Collect); alerts != 1 {
+		t.Fatalf("Expected seasonal anomaly alert, got score %g and %d alerts.", score, alerts)
+	}
+}
+
+func TestAnomalyOpts(t *testing.T) {
+	opts := []func(*analyzer.AnomalyOpts){
+		func(o *analyzer.AnomalyOpts) { o.Alpha = 0 },
+		func(o *analyzer.AnomalyOpts) { o.Alert = &analyzer.AlertRule{Op: "bigger", Value: 3, Level: 8} },
+		func(o *analyzer.AnomalyOpts) { o.Alert = &analyzer.AlertRule{Op: "range", Low: 3, High: 1, Level: 3} },
+		func(o *analyzer.AnomalyOpts) { o.Seasonal = &analyzer.SeasonalOpts{Period: 1, Beta: 0.1, Gamma: 0.1} },
+		func(o *analyzer.AnomalyOpts) { o.Seasonal = &analyzer.SeasonalOpts{Period: 4, Beta: 0, Gamma: 0.1} },
+	}
+	for idx, f := range opts {
+		opt := newTestAnomalyOpts()
+		f(opt)
+		if _, err := analyzer.NewAnomalyDetector(opt); err == nil {
+			t.Errorf("Expected error of %dth opts.", idx)
+		}
+	}
+}
+
+func TestAnomalyState(t *testing.T) {
+	opt := newTestAnomalyOpts()
+	opt.Seasonal = &analyzer.SeasonalOpts{Period: 4, Beta: 0.1, Gamma: 0.3}
+	sfa, err := analyzer.NewAnomalyDetector(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	season := []float64{10, 50, 90, 50}
+	for i := 0; i < 40; i++ {
+		sfa.Observe(pushFunc.NewDataPair(season[i%4]+float64(i%3)*0.5, time.Now()))
+	}
+	collectGauge(t, sfa.Collect)
+	data, err := sfa.MarshalState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the restored detector is warmed up and scores the same as the original
+	restored, err := analyzer.NewAnomalyDetector(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.UnmarshalState(data); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range []*analyzer.AnomalyDetector{sfa, restored} {
+		a.Observe(pushFunc.NewDataPair(90, time.Now()))
+	}
+	score, alerts := collectGauge(t, restored.Collect)
+	expected, _ := collectGauge(t, sfa.Collect)
+	if score != expected || alerts != 1 {
+		t.Fatalf("Expected restored score %g with alert, got %g and %d alerts.", expected, score, alerts)
+	}
+
+	// state of a different period is rejected
+	opt.Seasonal.Period = 5
+	other, err := analyzer.NewAnomalyDetector(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.UnmarshalState(data); err == nil {
+		t.Error("Expected error of mismatched period.")
+	}
+}
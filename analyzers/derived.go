@@ -0,0 +1,166 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+)
+
+// DataSource provides data kept by a pusher, *collector.Pusher implements it.
+type DataSource interface {
+	Snapshot() []*pushFunc.DataPair
+}
+
+// DerivedMetric is a collector that combines data of several pushers of the
+// same process. Each time Collect is called, Expr is evaluated and the result
+// is sent as a gauge, nothing is sent if the value cannot be calculated, such
+// as a referred pusher has no data or divided by zero.
+//
+// Functions over a duration only see data still kept by the pusher, so the
+// duration should not be longer than inv of the pusher.
+type DerivedMetric struct {
+	Desc *collector.Desc
+
+	expr    *expression
+	sources map[string]DataSource
+	alert   *Alert
+}
+
+func (d *DerivedMetric) Describe(ch chan<- *collector.Desc) {
+	ch <- d.Desc
+}
+
+func (d *DerivedMetric) Collect(ch chan<- collector.Metric) {
+	// every pusher is snapshotted at most once in a Collect
+	snapshots := map[string][]*pushFunc.DataPair{}
+	data := func(name string) []*pushFunc.DataPair {
+		s, ok := snapshots[name]
+		if !ok {
+			s = d.sources[name].Snapshot()
+			snapshots[name] = s
+		}
+		return s
+	}
+
+	tp := time.Now()
+	value, ok := d.expr.root.eval(data, tp)
+	if !ok {
+		return
+	}
+	cm, err := collector.NewConstMetric(
+		d.Desc,
+		collector.GaugeValue,
+		value,
+	)
+	if err != nil {
+		glog.Error(err)
+		return
+	}
+	ch <- collector.NewTimeStampMetric(tp, cm)
+
+	if d.alert != nil && d.alert.compare(value, tp) {
+		ch <- d.alert
+	}
+}
+
+// DerivedOpts is used to generate DerivedMetric, pushers are referred in
+// Expr by their names, see expr.go for the syntax.
+type DerivedOpts struct {
+	collector.Opts `yaml:"desc"`
+	Expr           string     `yaml:"expr"`
+	Alert          *AlertRule `yaml:"alert,omitempty"`
+}
+
+// Sources returns sorted names of pushers referred in Expr
+func (opt *DerivedOpts) Sources() ([]string, error) {
+	expr, err := parseExpr(opt.Expr)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(expr.names))
+	for name := range expr.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// NewDerivedMetric generates DerivedMetric, sources are pushers which can be
+// referred by name.
+func NewDerivedMetric(opt *DerivedOpts, sources map[string]DataSource) (*DerivedMetric, error) {
+	if err := checkOptLabels(opt.ConstLabels, []string{"analyzer"}); err != nil {
+		return nil, err
+	}
+	expr, err := parseExpr(opt.Expr)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := []string{}
+	used := map[string]DataSource{}
+	for name := range expr.names {
+		s, ok := sources[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		used[name] = s
+	}
+	if len(missing) != 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf(
+			"Pushers %v referred by derived metric %s not found.",
+			missing,
+			opt.Name,
+		)
+	}
+
+	newLabels := collector.Labels{}
+	for n, v := range opt.ConstLabels {
+		newLabels[n] = v
+	}
+	newLabels["analyzer"] = "Derived"
+	desc := collector.NewDesc(
+		opt.Name,
+		opt.Help,
+		opt.Level,
+		opt.Priority,
+		nil,
+		newLabels,
+	)
+
+	alert, err := NewAlert(
+		&opt.Opts,
+		collector.Labels{"analyzer": "Derived"},
+		opt.Alert,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DerivedMetric{
+		Desc:    desc,
+		expr:    expr,
+		sources: used,
+		alert:   alert,
+	}, nil
+}
+
+// GetDerivedFromConfig sets PID in ConstLabels and generates DerivedMetric.
+func GetDerivedFromConfig(
+	pid uint32,
+	opt DerivedOpts,
+	sources map[string]DataSource,
+) (*DerivedMetric, error) {
+	labels := collector.Labels{}
+	for n, v := range opt.ConstLabels {
+		labels[n] = v
+	}
+	labels["PID"] = fmt.Sprint(pid)
+	opt.ConstLabels = labels
+	return NewDerivedMetric(&opt, sources)
+}
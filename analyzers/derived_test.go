@@ -0,0 +1,150 @@
+package analyzer_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	analyzer "wanggj.com/abyss/analyzers"
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+)
+
+type testSource []*pushFunc.DataPair
+
+func (s testSource) Snapshot() []*pushFunc.DataPair {
+	return s
+}
+
+// newTestSource generates data with interval 1s, the last one is now
+func newTestSource(values ...float64) testSource {
+	now := time.Now()
+	result := testSource{}
+	for i, v := range values {
+		tp := now.Add(time.Duration(i-len(values)+1) * time.Second)
+		result = append(result, pushFunc.NewDataPair(v, tp))
+	}
+	return result
+}
+
+func newTestDerivedOpts(expr string) *analyzer.DerivedOpts {
+	return &analyzer.DerivedOpts{
+		Opts: collector.Opts{
+			Name:  "derived",
+			Help:  "this is derived",
+			Level: collector.LevelInfo,
+		},
+		Expr: expr,
+	}
+}
+
+func TestDerivedExpr(t *testing.T) {
+	sources := map[string]analyzer.DataSource{
+		"errors": newTestSource(0, 2, 4, 6),
+		"calls":  newTestSource(0, 10, 20, 40),
+		"rss":    newTestSource(10, 30, 20, 40),
+		"empty":  testSource{},
+	}
+	cases := []struct {
+		expr     string
+		expected float64
+	}{
+		{"errors / calls", 0.15},
+		{"rate(errors) / rate(calls)", 0.15},
+		{"rate(calls, 1500ms)", 20},
+		{"avg_over(rss)", 25},
+		{"max_over(rss, 2500ms)", 40},
+		{"min_over(rss)", 10},
+		{"sum_over(errors, 1500ms) * 2", 20},
+		{"-(rss - 10) * 2 + last(rss) / 4", -50},
+		{"1 - 2 - 3", -4},
+		{"2 * (3 + .5)", 7},
+	}
+	for _, c := range cases {
+		d, err := analyzer.NewDerivedMetric(newTestDerivedOpts(c.expr), sources)
+		if err != nil {
+			t.Errorf("Expression %q get error: %s", c.expr, err.Error())
+			continue
+		}
+		if v, _ := collectGauge(t, d.Collect); math.Abs(v-c.expected) > 1e-9 {
+			t.Errorf("Expression %q expected %g, got %g.", c.expr, c.expected, v)
+		}
+	}
+
+	// cannot be calculated
+	for _, expr := range []string{"errors / 0", "empty + 1", "rate(rss, 100ms)"} {
+		d, err := analyzer.NewDerivedMetric(newTestDerivedOpts(expr), sources)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := collectGauge(t, d.Collect); !math.IsNaN(v) {
+			t.Errorf("Expression %q expected nothing, got %g.", expr, v)
+		}
+	}
+}
+
+func TestDerivedError(t *testing.T) {
+	sources := map[string]analyzer.DataSource{
+		"calls": newTestSource(1),
+	}
+	for _, expr := range []string{
+		"",
+		"calls +",
+		"(calls",
+		"calls)",
+		"unknown(calls)",
+		"rate(1)",
+		"rate(calls, 1x)",
+		"calls / errors",
+		"1.2.3",
+		"calls $ 2",
+	} {
+		if _, err := analyzer.NewDerivedMetric(newTestDerivedOpts(expr), sources); err == nil {
+			t.Errorf("Expected error of expression %q.", expr)
+		}
+	}
+
+	opt := newTestDerivedOpts("calls")
+	opt.ConstLabels = collector.Labels{"analyzer": "a"}
+	if _, err := analyzer.NewDerivedMetric(opt, sources); err == nil {
+		t.Error("Expected error of illegal label.")
+	}
+}
+
+func TestDerivedAlert(t *testing.T) {
+	source := newTestSource(1)
+	ratio := funcSource(func() []*pushFunc.DataPair { return source })
+	opt := newTestDerivedOpts("ratio * 100")
+	opt.Alert = &analyzer.AlertRule{Op: ">", Value: 50, Level: 3}
+	d, err := analyzer.GetDerivedFromConfig(
+		111,
+		*opt,
+		map[string]analyzer.DataSource{"ratio": ratio},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := d.Desc.String(); !strings.Contains(s, "111") {
+		t.Fatalf("PID not set in desc %s.", s)
+	}
+
+	if v, alerts := collectGauge(t, d.Collect); v != 100 || alerts != 1 {
+		t.Errorf("Expected 100 with an alert, got %g and %d alerts.", v, alerts)
+	}
+	// alert is not sent again until resolved
+	if _, alerts := collectGauge(t, d.Collect); alerts != 0 {
+		t.Errorf("Expected no alert, got %d.", alerts)
+	}
+	source = newTestSource(0.1)
+	if v, alerts := collectGauge(t, d.Collect); v != 10 || alerts != 1 {
+		t.Errorf("Expected 10 with a resolved alert, got %g and %d alerts.", v, alerts)
+	}
+}
+
+// funcSource is a DataSource whose data can be changed during test
+type funcSource func() []*pushFunc.DataPair
+
+func (s funcSource) Snapshot() []*pushFunc.DataPair {
+	return s()
+}
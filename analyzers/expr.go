@@ -0,0 +1,329 @@
+package analyzer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	"unicode"
+
+	"wanggj.com/abyss/collector/pushFunc"
+)
+
+// This file implements the expression used by derived metrics, the grammar is:
+//
+//	expr   = term {("+" | "-") term}
+//	term   = unary {("*" | "/") unary}
+//	unary  = ["-"] primary
+//	primary = number | name | call | "(" expr ")"
+//	call   = func "(" name ["," duration] ")"
+//
+// name is the name of a pusher, it is evaluated as the latest value of the
+// pusher. Supported funcs are:
+//
+//	last(x): the latest value
+//	rate(x[, d]): change of value per second in past d
+//	avg_over(x[, d]), max_over(x[, d]), min_over(x[, d]), sum_over(x[, d]):
+//	    aggregation of values in past d
+//
+// d is a duration like 30s, all data kept by the pusher are used if d is
+// omitted.
+
+// exprData returns data of a pusher by name
+type exprData func(name string) []*pushFunc.DataPair
+
+type exprNode interface {
+	// eval returns false if the value cannot be calculated, such as
+	// no data or divided by zero
+	eval(data exprData, now time.Time) (float64, bool)
+}
+
+type numberNode float64
+
+func (n numberNode) eval(exprData, time.Time) (float64, bool) {
+	return float64(n), true
+}
+
+type negNode struct {
+	x exprNode
+}
+
+func (n *negNode) eval(data exprData, now time.Time) (float64, bool) {
+	v, ok := n.x.eval(data, now)
+	return -v, ok
+}
+
+type binaryNode struct {
+	op   byte
+	l, r exprNode
+}
+
+func (n *binaryNode) eval(data exprData, now time.Time) (float64, bool) {
+	l, ok := n.l.eval(data, now)
+	if !ok {
+		return 0, false
+	}
+	r, ok := n.r.eval(data, now)
+	if !ok {
+		return 0, false
+	}
+	switch n.op {
+	case '+':
+		return l + r, true
+	case '-':
+		return l - r, true
+	case '*':
+		return l * r, true
+	case '/':
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	}
+	return 0, false
+}
+
+type exprFunc func([]*pushFunc.DataPair) (float64, bool)
+
+var exprFuncs = map[string]exprFunc{
+	"last": func(d []*pushFunc.DataPair) (float64, bool) {
+		return d[len(d)-1].Value, true
+	},
+	"rate": func(d []*pushFunc.DataPair) (float64, bool) {
+		first, last := d[0], d[len(d)-1]
+		dt := last.Timestamp.Sub(first.Timestamp).Seconds()
+		if dt <= 0 {
+			return 0, false
+		}
+		return (last.Value - first.Value) / dt, true
+	},
+	"avg_over": func(d []*pushFunc.DataPair) (float64, bool) {
+		sum := 0.0
+		for _, dp := range d {
+			sum += dp.Value
+		}
+		return sum / float64(len(d)), true
+	},
+	"sum_over": func(d []*pushFunc.DataPair) (float64, bool) {
+		sum := 0.0
+		for _, dp := range d {
+			sum += dp.Value
+		}
+		return sum, true
+	},
+	"max_over": func(d []*pushFunc.DataPair) (float64, bool) {
+		result := math.Inf(-1)
+		for _, dp := range d {
+			result = math.Max(result, dp.Value)
+		}
+		return result, true
+	},
+	"min_over": func(d []*pushFunc.DataPair) (float64, bool) {
+		result := math.Inf(1)
+		for _, dp := range d {
+			result = math.Min(result, dp.Value)
+		}
+		return result, true
+	},
+}
+
+// callNode applies fn to data of pusher name in past duration, a bare name
+// is a callNode of "last"
+type callNode struct {
+	fn       exprFunc
+	name     string
+	duration time.Duration
+}
+
+func (n *callNode) eval(data exprData, now time.Time) (float64, bool) {
+	d := data(n.name)
+	if n.duration > 0 {
+		oldest := now.Add(-n.duration)
+		start := len(d)
+		for ; start > 0 && !d[start-1].Timestamp.Before(oldest); start-- {
+		}
+		d = d[start:]
+	}
+	if len(d) == 0 {
+		return 0, false
+	}
+	return n.fn(d)
+}
+
+// expression is a parsed expression with names of pushers it refers
+type expression struct {
+	root  exprNode
+	names map[string]struct{}
+}
+
+type exprParser struct {
+	str   string
+	pos   int
+	names map[string]struct{}
+}
+
+func parseExpr(str string) (*expression, error) {
+	p := &exprParser{str: str, names: map[string]struct{}{}}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.str) {
+		return nil, p.errorf("unexpected %q", p.str[p.pos:])
+	}
+	return &expression{root: root, names: p.names}, nil
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf(
+		"Parse expression %q error at %d: %s.",
+		p.str,
+		p.pos,
+		fmt.Sprintf(format, args...),
+	)
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.str) && unicode.IsSpace(rune(p.str[p.pos])) {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space byte, 0 if end of expression
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.str) {
+		return 0
+	}
+	return p.str[p.pos]
+}
+
+func (p *exprParser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expect %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *exprParser) parseExpr() (exprNode, error) {
+	l, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for c := p.peek(); c == '+' || c == '-'; c = p.peek() {
+		p.pos++
+		r, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: c, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseTerm() (exprNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for c := p.peek(); c == '*' || c == '/'; c = p.peek() {
+		p.pos++
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: c, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.peek() == '-' {
+		p.pos++
+		x, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return &negNode{x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func isNameByte(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+	return !first && c >= '0' && c <= '9'
+}
+
+// word returns the next token composed of letters, digits, '_' and '.'
+func (p *exprParser) word() string {
+	start := p.pos
+	for p.pos < len(p.str) && (isNameByte(p.str[p.pos], false) || p.str[p.pos] == '.') {
+		p.pos++
+	}
+	return p.str[start:p.pos]
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	c := p.peek()
+	switch {
+	case c == '(':
+		p.pos++
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		w := p.word()
+		v, err := strconv.ParseFloat(w, 64)
+		if err != nil {
+			return nil, p.errorf("illegal number %q", w)
+		}
+		return numberNode(v), nil
+	case isNameByte(c, true):
+		name := p.word()
+		if p.peek() != '(' {
+			p.names[name] = struct{}{}
+			return &callNode{fn: exprFuncs["last"], name: name}, nil
+		}
+		return p.parseCall(name)
+	case c == 0:
+		return nil, p.errorf("unexpected end")
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+func (p *exprParser) parseCall(fnName string) (exprNode, error) {
+	fn, ok := exprFuncs[fnName]
+	if !ok {
+		return nil, p.errorf("unknown func %q", fnName)
+	}
+	p.pos++ // skip '('
+	if !isNameByte(p.peek(), true) {
+		return nil, p.errorf("func %s expect a pusher name", fnName)
+	}
+	node := &callNode{fn: fn, name: p.word()}
+	p.names[node.name] = struct{}{}
+	if p.peek() == ',' {
+		p.pos++
+		p.skipSpace()
+		w := p.word()
+		d, err := time.ParseDuration(w)
+		if err != nil || d <= 0 {
+			return nil, p.errorf("illegal duration %q", w)
+		}
+		node.duration = d
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
@@ -0,0 +1,238 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+	"wanggj.com/abyss/module"
+	"wanggj.com/abyss/procfs"
+)
+
+// GroupConfig defines analyzers over a pusher of all processes in the same
+// group. Processes are grouped by:
+//
+//	exe: path of the executable
+//	config: path of the config file
+//	label: Label, processes with the same Label are in the same group
+//
+// Raw data of the pusher of every member is observed by the same analyzers,
+// so quantiles and histograms are calculated over data of the whole group.
+// Only stateful analyzers can be used, they have const label "group" instead
+// of "PID". Outlier compares each member against its siblings, see
+// OutlierAnalyzer.
+type GroupConfig struct {
+	// Pusher is the name of pusher in the same process config
+	Pusher  string       `yaml:"pusher"`
+	By      string       `yaml:"by"`
+	Label   string       `yaml:"label,omitempty"`
+	SfAna   []AnaConfig  `yaml:"sfana,omitempty"`
+	Outlier *OutlierOpts `yaml:"outlier,omitempty"`
+}
+
+// GroupValue returns value of label "group" of the process, exe and config
+// are paths of executable and config file of the process.
+func (c *GroupConfig) GroupValue(exe, config string) (string, error) {
+	var value string
+	switch c.By {
+	case "exe":
+		value = exe
+	case "config":
+		value = config
+	case "label":
+		value = c.Label
+	default:
+		return "", fmt.Errorf("Group by %q is not supported, must be exe, config or label.", c.By)
+	}
+	if value == "" {
+		return "", fmt.Errorf("Group by %s got empty value.", c.By)
+	}
+	return value, nil
+}
+
+// Group is a collector of analyzers shared by members of a group
+type Group struct {
+	Value string
+
+	analyzers []collector.StatefulAnalyzer
+	outlier   *OutlierAnalyzer
+	members   map[procfs.ProcKey]struct{}
+}
+
+func (g *Group) Describe(ch chan<- *collector.Desc) {
+	for _, a := range g.analyzers {
+		a.Describe(ch)
+	}
+	if g.outlier != nil {
+		g.outlier.Describe(ch)
+	}
+}
+
+func (g *Group) Collect(ch chan<- collector.Metric) {
+	for _, a := range g.analyzers {
+		a.Collect(ch)
+	}
+	if g.outlier != nil {
+		g.outlier.Collect(ch)
+	}
+}
+
+// Observe sends data of member key into all analyzers of the group
+func (g *Group) Observe(key procfs.ProcKey, data *pushFunc.DataPair) {
+	for _, a := range g.analyzers {
+		a.Observe(data)
+	}
+	if g.outlier != nil {
+		g.outlier.ObserveMember(key, data)
+	}
+}
+
+// groupTap is added into pusher of a member to send its raw data into the
+// group, it has no output of its own.
+type groupTap struct {
+	key   procfs.ProcKey
+	group *Group
+}
+
+func (t *groupTap) Describe(ch chan<- *collector.Desc) {}
+
+func (t *groupTap) Collect(ch chan<- collector.Metric) {}
+
+func (t *groupTap) Observe(data *pushFunc.DataPair) {
+	t.group.Observe(t.key, data)
+}
+
+// GroupRegistry keeps groups of all processes. A group is created by the
+// config of its first member, and removed when its last member leaves.
+type GroupRegistry struct {
+	registry *collector.Registry
+	groups   map[string]*Group
+	mtx      sync.Mutex
+}
+
+func NewGroupRegistry() *GroupRegistry {
+	return &GroupRegistry{
+		registry: collector.NewRegistry(),
+		groups:   map[string]*Group{},
+	}
+}
+
+// groupKey identifies a group, groups of different pushers are different
+func groupKey(value string, cfg *GroupConfig) string {
+	return fmt.Sprintf("%s\x00%s\x00%s", cfg.By, value, cfg.Pusher)
+}
+
+// Join adds process key into the group with value, the returned
+// StatefulAnalyzer must be added into the pusher named cfg.Pusher of the
+// process to observe its data.
+func (r *GroupRegistry) Join(
+	key procfs.ProcKey,
+	value string,
+	cfg *GroupConfig,
+) (collector.StatefulAnalyzer, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	gk := groupKey(value, cfg)
+	g, ok := r.groups[gk]
+	if !ok {
+		var err error
+		if g, err = newGroup(value, cfg); err != nil {
+			return nil, err
+		}
+		if err := r.registry.Register(g); err != nil {
+			return nil, err
+		}
+		r.groups[gk] = g
+	}
+	g.members[key] = struct{}{}
+	return &groupTap{key: key, group: g}, nil
+}
+
+func newGroup(value string, cfg *GroupConfig) (*Group, error) {
+	if len(cfg.SfAna) == 0 && cfg.Outlier == nil {
+		return nil, fmt.Errorf("Group %s has no analyzer.", value)
+	}
+	g := &Group{
+		Value:   value,
+		members: map[procfs.ProcKey]struct{}{},
+	}
+	for _, c := range cfg.SfAna {
+		opt, err := decodeAnaOpt(c, "group", value)
+		if err != nil {
+			return nil, err
+		}
+		sfo, ok := opt.(StatefulAnaOpt)
+		if !ok {
+			return nil, fmt.Errorf("Analyzer %s of group must be stateful.", c.Type)
+		}
+		a, err := sfo.NewStatefulAna()
+		if err != nil {
+			return nil, err
+		}
+		g.analyzers = append(g.analyzers, a)
+	}
+	if cfg.Outlier != nil {
+		// labels of cfg are shared by groups
+		opt := *cfg.Outlier
+		opt.ConstLabels = collector.Labels{}
+		for n, v := range cfg.Outlier.ConstLabels {
+			opt.ConstLabels[n] = v
+		}
+		opt.SetConstLabel("group", value)
+		o, err := NewOutlierAna(&opt)
+		if err != nil {
+			return nil, err
+		}
+		g.outlier = o
+	}
+	return g, nil
+}
+
+// Leave removes process key from all groups it joined, a process of the
+// same pid but another start time stays
+func (r *GroupRegistry) Leave(key procfs.ProcKey) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for gk, g := range r.groups {
+		if _, ok := g.members[key]; !ok {
+			continue
+		}
+		delete(g.members, key)
+		if g.outlier != nil {
+			g.outlier.RemoveMember(key)
+		}
+		if len(g.members) == 0 {
+			r.registry.Unregister(g)
+			delete(r.groups, gk)
+		}
+	}
+}
+
+// Members returns keys of the group with value, sorted by pid and start
+// time
+func (r *GroupRegistry) Members(value string, cfg *GroupConfig) []procfs.ProcKey {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	g, ok := r.groups[groupKey(value, cfg)]
+	if !ok {
+		return nil
+	}
+	keys := make([]procfs.ProcKey, 0, len(g.members))
+	for key := range g.members {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Pid != keys[j].Pid {
+			return keys[i].Pid < keys[j].Pid
+		}
+		return keys[i].StartTime < keys[j].StartTime
+	})
+	return keys
+}
+
+func (r *GroupRegistry) Gather() (map[int][]*module.MetricFamily, error) {
+	return r.registry.Gather()
+}
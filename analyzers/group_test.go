@@ -0,0 +1,119 @@
+package analyzer_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	analyzer "wanggj.com/abyss/analyzers"
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+	"wanggj.com/abyss/procfs"
+)
+
+const groupYaml = `
+pusher: latency
+by: exe
+sfana:
+- type: histogram
+  opt:
+    desc:
+      name: latency_hist
+      help: latency of the group
+      level: 1
+    buckets:
+      type: explicit
+      bounds: [10, 100]
+`
+
+func TestGroup(t *testing.T) {
+	cfg := &analyzer.GroupConfig{}
+	if err := yaml.UnmarshalStrict([]byte(groupYaml), cfg); err != nil {
+		t.Fatal(err)
+	}
+	value, err := cfg.GroupValue("/usr/bin/worker", "/etc/worker.yaml")
+	if err != nil || value != "/usr/bin/worker" {
+		t.Fatalf("Expected group of exe, got %q and %v.", value, err)
+	}
+
+	groups := analyzer.NewGroupRegistry()
+	taps := []interface{ Observe(*pushFunc.DataPair) }{}
+	for _, pid := range []uint32{3, 1, 2} {
+		tap, err := groups.Join(procfs.ProcKey{Pid: pid, StartTime: 100}, value, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		taps = append(taps, tap)
+	}
+	if members := groups.Members(value, cfg); len(members) != 3 || members[0].Pid != 1 {
+		t.Fatalf("Expected members [1 2 3], got %v.", members)
+	}
+
+	// data of all members are merged into the same histogram
+	for i, tap := range taps {
+		tap.Observe(pushFunc.NewDataPair(float64(i*50), time.Now()))
+	}
+	mfs, err := groups.Gather()
+	if errs, ok := err.(collector.MultiError); ok && len(errs) > 0 {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, l := range mfs {
+		for _, mf := range l {
+			for _, m := range mf.Metric {
+				if m.Histogram == nil {
+					continue
+				}
+				count++
+				if m.Histogram.GetSampleCount() != 3 {
+					t.Errorf("Expected 3 samples in group, got %d.", m.Histogram.GetSampleCount())
+				}
+				if !strings.Contains(m.String(), "/usr/bin/worker") || strings.Contains(m.String(), "PID") {
+					t.Errorf("Expected group label instead of PID, got %s.", m.String())
+				}
+			}
+		}
+	}
+	if count != 1 {
+		t.Fatalf("Expected 1 histogram of group, got %d.", count)
+	}
+
+	// a process reusing pid 1 is not a member
+	groups.Leave(procfs.ProcKey{Pid: 1, StartTime: 200})
+	if members := groups.Members(value, cfg); len(members) != 3 {
+		t.Fatalf("Expected members [1 2 3], got %v.", members)
+	}
+	// the group is removed after all members leave
+	for _, pid := range []uint32{1, 2, 3} {
+		groups.Leave(procfs.ProcKey{Pid: pid, StartTime: 100})
+	}
+	if members := groups.Members(value, cfg); members != nil {
+		t.Errorf("Expected group removed, got members %v.", members)
+	}
+	if _, err := groups.Join(procfs.ProcKey{Pid: 4}, value, cfg); err != nil {
+		t.Errorf("Expected group created again, got %v.", err)
+	}
+}
+
+func TestGroupConfig(t *testing.T) {
+	cfgs := []analyzer.GroupConfig{
+		{Pusher: "p", By: "pid"},
+		{Pusher: "p", By: "label"},
+	}
+	for idx := range cfgs {
+		if _, err := cfgs[idx].GroupValue("/usr/bin/worker", ""); err == nil {
+			t.Errorf("Expected error of %dth config.", idx)
+		}
+	}
+
+	// stateless analyzers cannot be shared
+	cfg := &analyzer.GroupConfig{}
+	err := yaml.UnmarshalStrict([]byte(strings.Replace(groupYaml, "histogram", "trend", 1)), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := analyzer.NewGroupRegistry().Join(procfs.ProcKey{Pid: 1}, "g", cfg); err == nil {
+		t.Error("Expected error of stateless analyzer.")
+	}
+}
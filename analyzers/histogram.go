@@ -0,0 +1,326 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+)
+
+const (
+	maxBucketNum = 64
+)
+
+// HistogramAnalyzer can be either stateful or stateless, like QuantileAnalyzer.
+// When stateful, buckets are cumulative during the lifetime of analyzer,
+// otherwise buckets only count data in the window of Pusher.
+type HistogramAnalyzer struct {
+	Desc   *collector.Desc
+	Bounds []float64
+	// Alerts map upper bound of a bucket to its alert, the value compared
+	// is the ratio of data no bigger than the bound.
+	Alerts map[float64]*Alert
+
+	count  uint64
+	sum    float64
+	counts []uint64 // counts[i] is the number of data in (Bounds[i-1], Bounds[i]]
+	mtx    sync.Mutex
+}
+
+func (h *HistogramAnalyzer) Describe(ch chan<- *collector.Desc) {
+	ch <- h.Desc
+}
+
+func (h *HistogramAnalyzer) insert(value float64) {
+	idx := sort.SearchFloat64s(h.Bounds, value)
+	if idx < len(h.counts) {
+		h.counts[idx]++
+	}
+	h.count++
+	h.sum += value
+}
+
+func (h *HistogramAnalyzer) reset() {
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.count = 0
+	h.sum = 0
+}
+
+func (h *HistogramAnalyzer) getResults() []collector.Metric {
+	result := []collector.Metric{}
+	timestamp := time.Now()
+
+	buckets := make(map[float64]uint64, len(h.Bounds))
+	cumulative := uint64(0)
+	for i, b := range h.Bounds {
+		cumulative += h.counts[i]
+		buckets[b] = cumulative
+	}
+	his := collector.NewConstHistogram(h.Desc, h.count, h.sum, buckets)
+	result = append(result, collector.NewTimeStampMetric(timestamp, his))
+
+	if h.count == 0 {
+		return result
+	}
+	for _, b := range h.Bounds {
+		a := h.Alerts[b]
+		if a == nil {
+			continue
+		}
+		ratio := float64(buckets[b]) / float64(h.count)
+		if a.compare(ratio, timestamp) {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+func (h *HistogramAnalyzer) collectMetric(reset bool, ch chan<- collector.Metric) {
+	result := h.getResults()
+	if reset {
+		h.reset()
+	}
+	for _, m := range result {
+		ch <- m
+	}
+}
+
+func (h *HistogramAnalyzer) Collect(ch chan<- collector.Metric) {
+	h.mtx.Lock()
+	h.collectMetric(false, ch)
+	h.mtx.Unlock()
+}
+
+func (h *HistogramAnalyzer) Observe(data *pushFunc.DataPair) {
+	h.mtx.Lock()
+	h.insert(data.Value)
+	h.mtx.Unlock()
+}
+
+func (h *HistogramAnalyzer) Analyze(data []*pushFunc.DataPair, ch chan<- collector.Metric) {
+	if len(data) <= 0 {
+		return
+	}
+	h.mtx.Lock()
+	for _, d := range data {
+		h.insert(d.Value)
+	}
+	h.collectMetric(true, ch)
+	h.mtx.Unlock()
+}
+
+// stateBound is a bucket bound in histogramState, ±Inf which cannot be
+// encoded by json is saved as string "+Inf" or "-Inf".
+type stateBound float64
+
+func (b stateBound) MarshalJSON() ([]byte, error) {
+	if math.IsInf(float64(b), 0) {
+		return json.Marshal(fmt.Sprint(float64(b)))
+	}
+	return json.Marshal(float64(b))
+}
+
+func (b *stateBound) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return json.Unmarshal(data, (*float64)(b))
+	}
+	switch str {
+	case "+Inf":
+		*b = stateBound(math.Inf(1))
+	case "-Inf":
+		*b = stateBound(math.Inf(-1))
+	default:
+		return fmt.Errorf("Histogram state has invalid bound %q.", str)
+	}
+	return nil
+}
+
+// histogramState is the state of HistogramAnalyzer saved by MarshalState
+type histogramState struct {
+	Bounds []stateBound `json:"bounds"`
+	Count  uint64       `json:"count"`
+	Sum    float64      `json:"sum"`
+	Counts []uint64     `json:"counts"`
+}
+
+func (h *HistogramAnalyzer) MarshalState() ([]byte, error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	bounds := make([]stateBound, len(h.Bounds))
+	for i, b := range h.Bounds {
+		bounds[i] = stateBound(b)
+	}
+	return json.Marshal(&histogramState{
+		Bounds: bounds,
+		Count:  h.count,
+		Sum:    h.sum,
+		Counts: h.counts,
+	})
+}
+
+func (h *HistogramAnalyzer) UnmarshalState(data []byte) error {
+	state := histogramState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if len(state.Bounds) != len(h.Bounds) || len(state.Counts) != len(h.counts) {
+		return fmt.Errorf("Histogram state does not match buckets.")
+	}
+	for i, b := range state.Bounds {
+		if float64(b) != h.Bounds[i] {
+			return fmt.Errorf("Histogram state does not match buckets.")
+		}
+	}
+	h.count = state.Count
+	h.sum = state.Sum
+	copy(h.counts, state.Counts)
+	return nil
+}
+
+// BucketOpts describe the layout of buckets, Type can be:
+//
+//	linear: Count buckets, the first upper bound is Start, each next
+//	        bound is Width bigger than the previous one.
+//	exponential: Count buckets, the first upper bound is Start, each next
+//	        bound is Factor times of the previous one.
+//	explicit: upper bounds are given by Bounds.
+//
+// A +Inf bucket is always appended to make sure all data are counted.
+type BucketOpts struct {
+	Type   string    `yaml:"type"`
+	Start  float64   `yaml:"start,omitempty"`
+	Width  float64   `yaml:"width,omitempty"`
+	Factor float64   `yaml:"factor,omitempty"`
+	Count  int       `yaml:"count,omitempty"`
+	Bounds []float64 `yaml:"bounds,omitempty"`
+}
+
+// GetBounds generates the sorted upper bounds of buckets, including +Inf.
+func (b *BucketOpts) GetBounds() ([]float64, error) {
+	var bounds []float64
+	switch b.Type {
+	case "linear":
+		if b.Count <= 0 || b.Count > maxBucketNum {
+			return nil, fmt.Errorf("Linear buckets count must between 1 and %d, got %d.", maxBucketNum, b.Count)
+		}
+		if b.Width <= 0 {
+			return nil, fmt.Errorf("Linear buckets width must be positive, got %g.", b.Width)
+		}
+		bounds = make([]float64, b.Count)
+		for i := range bounds {
+			bounds[i] = b.Start + float64(i)*b.Width
+		}
+	case "exponential":
+		if b.Count <= 0 || b.Count > maxBucketNum {
+			return nil, fmt.Errorf("Exponential buckets count must between 1 and %d, got %d.", maxBucketNum, b.Count)
+		}
+		if b.Start <= 0 {
+			return nil, fmt.Errorf("Exponential buckets start must be positive, got %g.", b.Start)
+		}
+		if b.Factor <= 1 {
+			return nil, fmt.Errorf("Exponential buckets factor must be bigger than 1, got %g.", b.Factor)
+		}
+		bounds = make([]float64, b.Count)
+		bounds[0] = b.Start
+		for i := 1; i < b.Count; i++ {
+			bounds[i] = bounds[i-1] * b.Factor
+		}
+	case "explicit":
+		if len(b.Bounds) == 0 || len(b.Bounds) > maxBucketNum {
+			return nil, fmt.Errorf("Explicit buckets number must between 1 and %d, got %d.", maxBucketNum, len(b.Bounds))
+		}
+		bounds = make([]float64, len(b.Bounds))
+		copy(bounds, b.Bounds)
+		sort.Float64s(bounds)
+		for i := 1; i < len(bounds); i++ {
+			if bounds[i] == bounds[i-1] {
+				return nil, fmt.Errorf("Explicit buckets have duplicated bound %g.", bounds[i])
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported buckets type %q.", b.Type)
+	}
+	if !math.IsInf(bounds[len(bounds)-1], 1) {
+		bounds = append(bounds, math.Inf(1))
+	}
+	return bounds, nil
+}
+
+// Opts used to generate HistogramAnalyzer, Alerts is keyed by upper bound of
+// bucket, the bound must be one of the bounds generated by Buckets.
+// ConstLabels must not contain "analyzer" and "bucket_le".
+//
+// HistogramOpts implements interface StatefulAnaOpt and StatelessAnaOpt
+type HistogramOpts struct {
+	collector.Opts `yaml:"desc"`
+	Buckets        BucketOpts             `yaml:"buckets"`
+	Alerts         map[float64]*AlertRule `yaml:"alerts,omitempty"`
+}
+
+func NewHistogramAna(h *HistogramOpts) (*HistogramAnalyzer, error) {
+	if err := checkOptLabels(h.ConstLabels, []string{"analyzer", "bucket_le"}); err != nil {
+		return nil, err
+	}
+	bounds, err := h.Buckets.GetBounds()
+	if err != nil {
+		return nil, err
+	}
+
+	newLabels := collector.Labels{}
+	for n, v := range h.ConstLabels {
+		newLabels[n] = v
+	}
+	newLabels["analyzer"] = "Histogram"
+	desc := collector.NewDesc(
+		h.Name,
+		h.Help,
+		h.Level,
+		h.Priority,
+		nil,
+		newLabels,
+	)
+
+	alerts := map[float64]*Alert{}
+	for k, v := range h.Alerts {
+		idx := sort.SearchFloat64s(bounds, k)
+		if idx >= len(bounds) || bounds[idx] != k {
+			return nil, fmt.Errorf("Alert bound %g is not an upper bound of buckets.", k)
+		}
+		a, err := NewAlert(
+			&h.Opts,
+			collector.Labels{
+				"bucket_le": fmt.Sprint(k),
+			},
+			v,
+		)
+		if err != nil {
+			return nil, err
+		}
+		alerts[k] = a
+	}
+
+	return &HistogramAnalyzer{
+		Desc:   desc,
+		Bounds: bounds,
+		Alerts: alerts,
+		counts: make([]uint64, len(bounds)),
+	}, nil
+}
+
+func (ho *HistogramOpts) NewStatelessAna() (collector.StatelessAnalyzer, error) {
+	return NewHistogramAna(ho)
+}
+
+func (ho *HistogramOpts) NewStatefulAna() (collector.StatefulAnalyzer, error) {
+	return NewHistogramAna(ho)
+}
@@ -0,0 +1,235 @@
+package analyzer_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	analyzer "wanggj.com/abyss/analyzers"
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+)
+
+var (
+	testHisOpt = &analyzer.HistogramOpts{
+		Opts: collector.Opts{
+			Name:  "histogram",
+			Help:  "this is histogram",
+			Level: collector.LevelInfo,
+			ConstLabels: collector.Labels{
+				"a": "a",
+			},
+		},
+		Buckets: analyzer.BucketOpts{
+			Type:  "linear",
+			Start: 1,
+			Width: 1,
+			Count: 3,
+		},
+		Alerts: map[float64]*analyzer.AlertRule{
+			2: {Op: "<", Value: 0.9, Level: 3},
+		},
+	}
+)
+
+func TestBucketBounds(t *testing.T) {
+	cases := []struct {
+		opt    analyzer.BucketOpts
+		bounds []float64
+		succ   bool
+	}{
+		{analyzer.BucketOpts{Type: "linear", Start: 0, Width: 5, Count: 3}, []float64{0, 5, 10}, true},
+		{analyzer.BucketOpts{Type: "exponential", Start: 1, Factor: 2, Count: 4}, []float64{1, 2, 4, 8}, true},
+		{analyzer.BucketOpts{Type: "explicit", Bounds: []float64{10, 0.5, 3}}, []float64{0.5, 3, 10}, true},
+		{analyzer.BucketOpts{Type: "explicit", Bounds: []float64{1, 1}}, nil, false},
+		{analyzer.BucketOpts{Type: "exponential", Start: 1, Factor: 1, Count: 4}, nil, false},
+		{analyzer.BucketOpts{Type: "linear", Width: 1, Count: 0}, nil, false},
+		{analyzer.BucketOpts{Type: "log"}, nil, false},
+	}
+	for idx, c := range cases {
+		bounds, err := c.opt.GetBounds()
+		if (err == nil) != c.succ {
+			t.Errorf("Case %d expected success %t, got error %v.", idx, c.succ, err)
+			continue
+		}
+		if !c.succ {
+			continue
+		}
+		if len(bounds) != len(c.bounds)+1 || !math.IsInf(bounds[len(bounds)-1], 1) {
+			t.Errorf("Case %d expected %v with +Inf, got %v.", idx, c.bounds, bounds)
+			continue
+		}
+		for i, b := range c.bounds {
+			if bounds[i] != b {
+				t.Errorf("Case %d expected %v, got %v.", idx, c.bounds, bounds)
+				break
+			}
+		}
+	}
+}
+
+func histogramData(values ...float64) []*pushFunc.DataPair {
+	result := make([]*pushFunc.DataPair, 0, len(values))
+	for _, v := range values {
+		result = append(result, pushFunc.NewDataPair(v, time.Now()))
+	}
+	return result
+}
+
+func collectHistogram(t *testing.T, f func(ch chan<- collector.Metric)) ([]uint64, int) {
+	metrics, alerts := collectMetrics(t, f)
+	var counts []uint64
+	for _, md := range metrics {
+		if md.Histogram == nil {
+			t.Fatal("Want Histogram but Metric's Histogram is null.")
+		}
+		for _, b := range md.Histogram.Bucket {
+			counts = append(counts, b.GetCumulativeCount())
+		}
+	}
+	return counts, alerts
+}
+
+func TestHistogramStateless(t *testing.T) {
+	sla, err := testHisOpt.NewStatelessAna()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := histogramData(0.5, 1, 1.5, 2.5, 7)
+	counts, alerts := collectHistogram(t, func(ch chan<- collector.Metric) {
+		sla.Analyze(data, ch)
+	})
+	expected := []uint64{2, 3, 4, 5}
+	if len(counts) != len(expected) {
+		t.Fatalf("Expected buckets %v, got %v.", expected, counts)
+	}
+	for i := range expected {
+		if counts[i] != expected[i] {
+			t.Fatalf("Expected buckets %v, got %v.", expected, counts)
+		}
+	}
+	// 3 of 5 values are no bigger than 2, ratio 0.6 is smaller than 0.9
+	if alerts != 1 {
+		t.Errorf("Expected 1 alert, got %d.", alerts)
+	}
+
+	// stateless histogram only counts data of the window
+	counts, _ = collectHistogram(t, func(ch chan<- collector.Metric) {
+		sla.Analyze(histogramData(3), ch)
+	})
+	if counts[len(counts)-1] != 1 {
+		t.Errorf("Stateless histogram should reset, got %v.", counts)
+	}
+}
+
+func TestHistogramStateful(t *testing.T) {
+	sfa, err := testHisOpt.NewStatefulAna()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range histogramData(0.5, 1.5, 1.8) {
+		sfa.Observe(d)
+	}
+	counts, alerts := collectHistogram(t, sfa.Collect)
+	if counts[len(counts)-1] != 3 {
+		t.Errorf("Expected 3 values, got %v.", counts)
+	}
+	if alerts != 0 {
+		t.Errorf("Expected no alert, got %d.", alerts)
+	}
+
+	sfa.Observe(pushFunc.NewDataPair(10, time.Now()))
+	counts, _ = collectHistogram(t, sfa.Collect)
+	if counts[len(counts)-1] != 4 {
+		t.Errorf("Stateful histogram should be cumulative, got %v.", counts)
+	}
+}
+
+func TestHistogramAlertBound(t *testing.T) {
+	opt := *testHisOpt
+	opt.Alerts = map[float64]*analyzer.AlertRule{2.5: {Op: ">", Value: 0.5, Level: 3}}
+	if _, err := analyzer.NewHistogramAna(&opt); err == nil {
+		t.Error("Expected error when alert bound is not a bucket bound.")
+	}
+}
+
+func TestHistogramState(t *testing.T) {
+	sfa, err := testHisOpt.NewStatefulAna()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range histogramData(0.5, 1.5, 7) {
+		sfa.Observe(d)
+	}
+	data, err := sfa.(collector.PersistentAnalyzer).MarshalState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := testHisOpt.NewStatefulAna()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.(collector.PersistentAnalyzer).UnmarshalState(data); err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := collectHistogram(t, sfa.Collect)
+	counts, _ := collectHistogram(t, restored.Collect)
+	if len(counts) != len(expected) {
+		t.Fatalf("Expected buckets %v, got %v.", expected, counts)
+	}
+	for i := range expected {
+		if counts[i] != expected[i] {
+			t.Fatalf("Expected buckets %v, got %v.", expected, counts)
+		}
+	}
+
+	// state of different buckets is rejected
+	opt := *testHisOpt
+	opt.Buckets = analyzer.BucketOpts{Type: "linear", Start: 1, Width: 1, Count: 2}
+	other, err := analyzer.NewHistogramAna(&opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.UnmarshalState(data); err == nil {
+		t.Error("Expected error of mismatched bounds.")
+	}
+}
+
+func TestHistogramStateInfAlert(t *testing.T) {
+	opt := *testHisOpt
+	opt.Alerts = map[float64]*analyzer.AlertRule{math.Inf(1): {Op: ">=", Value: 1, Level: 3}}
+	sfa, err := analyzer.NewHistogramAna(&opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range histogramData(0.5, 7) {
+		sfa.Observe(d)
+	}
+	data, err := sfa.MarshalState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"+Inf"`) {
+		t.Errorf("Expected +Inf bound saved, got %s.", data)
+	}
+
+	restored, err := analyzer.NewHistogramAna(&opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.UnmarshalState(data); err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsInf(restored.Bounds[len(restored.Bounds)-1], 1) || restored.Alerts[math.Inf(1)] == nil {
+		t.Fatalf("Expected alert on +Inf bucket, got bounds %v.", restored.Bounds)
+	}
+	counts, alerts := collectHistogram(t, restored.Collect)
+	if alerts != 1 {
+		t.Errorf("Expected alert of +Inf bucket after restore, got %d.", alerts)
+	}
+	if len(counts) == 0 || counts[len(counts)-1] != 2 {
+		t.Errorf("Expected 2 data in +Inf bucket, got %v.", counts)
+	}
+}
@@ -0,0 +1,48 @@
+package analyzer
+
+import (
+	"sync"
+	"time"
+)
+
+// Notification is generated each time an Alert is collected, it is sent to
+// Notifier set by SetNotifier.
+type Notification struct {
+	Metric      string            `json:"metric"`
+	Labels      map[string]string `json:"labels"`
+	Value       float64           `json:"value"`
+	Rule        string            `json:"rule"`
+	Level       int               `json:"level"`
+	State       string            `json:"state"`
+	Timestamp   time.Time         `json:"timestamp"`
+	FiringStart time.Time         `json:"firing_start"`
+	// FiringDuration is how long Alert has been firing, or had been firing
+	// when resolved
+	FiringDuration time.Duration `json:"firing_duration"`
+}
+
+// Notifier receives Notifications of all Alerts, Notify is called in the
+// collecting routine, so it must not block.
+type Notifier interface {
+	Notify(*Notification)
+}
+
+var (
+	alertNotifier Notifier
+	notifierMtx   sync.RWMutex
+)
+
+// SetNotifier set the Notifier of all Alerts, nil disables notification.
+func SetNotifier(n Notifier) {
+	notifierMtx.Lock()
+	alertNotifier = n
+	notifierMtx.Unlock()
+}
+
+func notify(n *Notification) {
+	notifierMtx.RLock()
+	defer notifierMtx.RUnlock()
+	if alertNotifier != nil {
+		alertNotifier.Notify(n)
+	}
+}
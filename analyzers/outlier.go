@@ -0,0 +1,270 @@
+package analyzer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+	"wanggj.com/abyss/procfs"
+)
+
+const (
+	defaultOutlierMinMembers = 3
+	// madScale makes MAD a consistent estimator of standard deviation of
+	// normal distribution
+	madScale = 1.4826
+	// maxOutlierScore is sent if all other members have the same value
+	maxOutlierScore = 1e6
+)
+
+// OutlierAnalyzer compares each member of a group against its siblings. The
+// value of a member is the mean of its data since last Collect, or its last
+// value if it has no new data.
+//
+// Each time Collect is called, the distance between the value of every member
+// and the median of all members is scaled by the spread of the group and sent
+// as a gauge labeled with "PID" and "start_time" of the member:
+//
+//	mad: the spread is MAD (median absolute deviation) * 1.4826, so the score
+//	     is comparable with standard deviations.
+//	percentile: the spread is the Percentile of absolute deviations of all
+//	     members.
+//
+// The score of every member is compared by its own Alert. Nothing is sent if
+// the group has less than MinMembers members with data.
+type OutlierAnalyzer struct {
+	Desc       *collector.Desc
+	Method     string
+	Percentile float64
+	MinMembers int
+
+	opt    collector.Opts
+	labels collector.Labels
+	rule   *AlertRule
+
+	members map[procfs.ProcKey]*outlierMember
+	mtx     sync.Mutex
+}
+
+type outlierMember struct {
+	last  float64
+	sum   float64
+	count int
+	// valid is false if the member has no data yet
+	valid bool
+	alert *Alert
+}
+
+func (o *OutlierAnalyzer) Describe(ch chan<- *collector.Desc) {
+	ch <- o.Desc
+}
+
+// ObserveMember receives data of member key
+func (o *OutlierAnalyzer) ObserveMember(key procfs.ProcKey, data *pushFunc.DataPair) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	m, ok := o.members[key]
+	if !ok {
+		m = &outlierMember{}
+		o.members[key] = m
+	}
+	m.sum += data.Value
+	m.count++
+}
+
+// RemoveMember forgets member key, such as it exits
+func (o *OutlierAnalyzer) RemoveMember(key procfs.ProcKey) {
+	o.mtx.Lock()
+	delete(o.members, key)
+	o.mtx.Unlock()
+}
+
+// percentile returns the p-th percentile of sorted by linear interpolation
+func percentile(sorted []float64, p float64) float64 {
+	pos := p * float64(len(sorted)-1)
+	low := int(math.Floor(pos))
+	if low >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[low] + (pos-float64(low))*(sorted[low+1]-sorted[low])
+}
+
+// scores returns outlier score of every member with data
+func (o *OutlierAnalyzer) scores() map[procfs.ProcKey]float64 {
+	values := map[procfs.ProcKey]float64{}
+	for key, m := range o.members {
+		if m.count > 0 {
+			m.last = m.sum / float64(m.count)
+			m.sum, m.count, m.valid = 0, 0, true
+		}
+		if m.valid {
+			values[key] = m.last
+		}
+	}
+	if len(values) < o.MinMembers {
+		return nil
+	}
+
+	sorted := make([]float64, 0, len(values))
+	for _, v := range values {
+		sorted = append(sorted, v)
+	}
+	sort.Float64s(sorted)
+	median := percentile(sorted, 0.5)
+	deviations := make([]float64, 0, len(values))
+	for _, v := range values {
+		deviations = append(deviations, math.Abs(v-median))
+	}
+	sort.Float64s(deviations)
+	var spread float64
+	if o.Method == "mad" {
+		spread = madScale * percentile(deviations, 0.5)
+	} else {
+		spread = percentile(deviations, o.Percentile)
+	}
+
+	scores := make(map[procfs.ProcKey]float64, len(values))
+	for key, v := range values {
+		deviation := math.Abs(v - median)
+		switch {
+		case deviation == 0:
+			scores[key] = 0
+		case spread == 0:
+			scores[key] = maxOutlierScore
+		default:
+			scores[key] = math.Min(deviation/spread, maxOutlierScore)
+		}
+	}
+	return scores
+}
+
+func (o *OutlierAnalyzer) Collect(ch chan<- collector.Metric) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	tp := time.Now()
+	for key, score := range o.scores() {
+		labels := collector.Labels{}
+		for n, v := range o.labels {
+			labels[n] = v
+		}
+		for n, v := range key.Labels() {
+			labels[n] = v
+		}
+		desc := collector.NewDesc(
+			o.opt.Name,
+			o.opt.Help,
+			o.opt.Level,
+			o.opt.Priority,
+			nil,
+			labels,
+		)
+		cm, err := collector.NewConstMetric(
+			desc,
+			collector.GaugeValue,
+			score,
+		)
+		if err != nil {
+			glog.Error(err)
+			continue
+		}
+		ch <- collector.NewTimeStampMetric(tp, cm)
+
+		alert, err := o.memberAlert(key)
+		if err != nil {
+			glog.Error(err)
+			continue
+		}
+		if alert != nil && alert.compare(score, tp) {
+			ch <- alert
+		}
+	}
+}
+
+// memberAlert returns Alert of member key, nil if no alert rule is set
+func (o *OutlierAnalyzer) memberAlert(key procfs.ProcKey) (*Alert, error) {
+	if o.rule == nil {
+		return nil, nil
+	}
+	m := o.members[key]
+	if m.alert == nil {
+		labels := collector.Labels{"analyzer": "Outlier"}
+		for n, v := range key.Labels() {
+			labels[n] = v
+		}
+		alert, err := NewAlert(&o.opt, labels, o.rule)
+		if err != nil {
+			return nil, err
+		}
+		m.alert = alert
+	}
+	return m.alert, nil
+}
+
+// OutlierOpts is used to generate OutlierAnalyzer, Method is mad or
+// percentile, Percentile in (0, 1) is used by percentile only. Alert is
+// the rule to compare score of each member with.
+// ConstLabels must not contain "analyzer", "PID" and "start_time".
+type OutlierOpts struct {
+	collector.Opts `yaml:"desc"`
+	Method         string     `yaml:"method"`
+	Percentile     float64    `yaml:"percentile,omitempty"`
+	MinMembers     int        `yaml:"minMembers,omitempty"`
+	Alert          *AlertRule `yaml:"alert,omitempty"`
+}
+
+func NewOutlierAna(opt *OutlierOpts) (*OutlierAnalyzer, error) {
+	switch opt.Method {
+	case "mad":
+	case "percentile":
+		if opt.Percentile <= 0 || opt.Percentile >= 1 {
+			return nil, fmt.Errorf("Outlier percentile must in (0, 1), got %g.", opt.Percentile)
+		}
+	default:
+		return nil, fmt.Errorf("Outlier method must be mad or percentile, got %q.", opt.Method)
+	}
+	minMembers := opt.MinMembers
+	if minMembers == 0 {
+		minMembers = defaultOutlierMinMembers
+	}
+	if minMembers < 2 {
+		return nil, fmt.Errorf("Outlier minMembers must be at least 2, got %d.", minMembers)
+	}
+	// Alerts of members are generated lazily, check the rule here
+	if _, err := NewAlert(&opt.Opts, nil, opt.Alert); err != nil {
+		return nil, err
+	}
+	if err := checkOptLabels(opt.ConstLabels, []string{"analyzer", "PID", "start_time"}); err != nil {
+		return nil, err
+	}
+
+	newLabels := collector.Labels{}
+	for n, v := range opt.ConstLabels {
+		newLabels[n] = v
+	}
+	newLabels["analyzer"] = "Outlier"
+	desc := collector.NewDesc(
+		opt.Name,
+		opt.Help,
+		opt.Level,
+		opt.Priority,
+		nil,
+		newLabels,
+	)
+
+	return &OutlierAnalyzer{
+		Desc:       desc,
+		Method:     opt.Method,
+		Percentile: opt.Percentile,
+		MinMembers: minMembers,
+		opt:        opt.Opts,
+		labels:     newLabels,
+		rule:       opt.Alert,
+		members:    map[procfs.ProcKey]*outlierMember{},
+	}, nil
+}
@@ -0,0 +1,177 @@
+package analyzer_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	analyzer "wanggj.com/abyss/analyzers"
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+	"wanggj.com/abyss/procfs"
+)
+
+func newTestOutlierOpts() *analyzer.OutlierOpts {
+	return &analyzer.OutlierOpts{
+		Opts: collector.Opts{
+			Name:  "outlier",
+			Help:  "this is outlier",
+			Level: collector.LevelInfo,
+		},
+		Method: "mad",
+		Alert:  &analyzer.AlertRule{Op: ">", Value: 3, Level: 3},
+	}
+}
+
+// memberKey returns key of member pid, which starts at pid*100 clock ticks
+func memberKey(pid uint32) procfs.ProcKey {
+	return procfs.ProcKey{Pid: pid, StartTime: uint64(pid) * 100}
+}
+
+// collectOutlier returns scores keyed by PID label and number of alerts,
+// start_time of every score must match memberKey
+func collectOutlier(t *testing.T, o *analyzer.OutlierAnalyzer) (map[string]float64, int) {
+	metrics, alerts := collectMetrics(t, o.Collect)
+	scores := map[string]float64{}
+	for _, md := range metrics {
+		labels := map[string]string{}
+		for _, l := range md.Label {
+			labels[l.GetName()] = l.GetValue()
+		}
+		// start time of memberKey(0) is unknown
+		if start := labels["start_time"]; labels["PID"] != "0" && start != labels["PID"]+"00" {
+			t.Errorf("Expected start_time of PID %s, got %q.", labels["PID"], start)
+		}
+		scores[labels["PID"]] = md.Gauge.GetValue()
+	}
+	return scores, alerts
+}
+
+func TestOutlierMAD(t *testing.T) {
+	o, err := analyzer.NewOutlierAna(newTestOutlierOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	values := map[uint32][]float64{
+		1: {10, 12},
+		2: {11, 11},
+		3: {12, 10},
+		4: {9, 13},
+		5: {40, 60},
+	}
+	for pid, vs := range values {
+		for _, v := range vs {
+			o.ObserveMember(memberKey(pid), pushFunc.NewDataPair(v, now))
+		}
+	}
+	scores, alerts := collectOutlier(t, o)
+	if len(scores) != 5 {
+		t.Fatalf("Expected 5 scores, got %v.", scores)
+	}
+	// means are 11, 11, 11, 11, 50
+	if scores["1"] != 0 || scores["5"] <= 3 || alerts != 1 {
+		t.Errorf("Expected PID 5 is the only outlier, got %v and %d alerts.", scores, alerts)
+	}
+
+	// a process reusing pid 5 is another member
+	o.RemoveMember(procfs.ProcKey{Pid: 5, StartTime: 1})
+	if scores, _ = collectOutlier(t, o); len(scores) != 5 {
+		t.Errorf("Expected 5 scores, got %v.", scores)
+	}
+
+	// last values are kept, the outlier is gone after it is removed
+	o.RemoveMember(memberKey(5))
+	if scores, alerts = collectOutlier(t, o); len(scores) != 4 || alerts != 0 {
+		t.Errorf("Expected 4 members without alert, got %v and %d alerts.", scores, alerts)
+	}
+
+	// too few members
+	o.RemoveMember(memberKey(4))
+	o.RemoveMember(memberKey(3))
+	if scores, _ = collectOutlier(t, o); len(scores) != 0 {
+		t.Errorf("Expected no score for 2 members, got %v.", scores)
+	}
+}
+
+func TestOutlierPercentile(t *testing.T) {
+	opt := newTestOutlierOpts()
+	opt.Method = "percentile"
+	opt.Percentile = 0.5
+	opt.Alert = nil
+	o, err := analyzer.NewOutlierAna(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pid, v := range []float64{1, 2, 3, 4, 100} {
+		o.ObserveMember(memberKey(uint32(pid)), pushFunc.NewDataPair(v, time.Now()))
+	}
+	// deviations from median 3 are 2, 1, 0, 1, 97, their median is 1
+	scores, alerts := collectOutlier(t, o)
+	if scores["0"] != 2 || scores["4"] != 97 || alerts != 0 {
+		t.Errorf("Got wrong scores %v and %d alerts.", scores, alerts)
+	}
+}
+
+func TestOutlierOpts(t *testing.T) {
+	opts := []func(*analyzer.OutlierOpts){
+		func(o *analyzer.OutlierOpts) { o.Method = "zscore" },
+		func(o *analyzer.OutlierOpts) { o.Method = "percentile" },
+		func(o *analyzer.OutlierOpts) { o.Alert.Level = 8 },
+		func(o *analyzer.OutlierOpts) { o.MinMembers = 1 },
+		func(o *analyzer.OutlierOpts) { o.ConstLabels = collector.Labels{"PID": "1"} },
+		func(o *analyzer.OutlierOpts) { o.ConstLabels = collector.Labels{"start_time": "1"} },
+	}
+	for idx, f := range opts {
+		opt := newTestOutlierOpts()
+		f(opt)
+		if _, err := analyzer.NewOutlierAna(opt); err == nil {
+			t.Errorf("Expected error of %dth opts.", idx)
+		}
+	}
+}
+
+func TestGroupOutlier(t *testing.T) {
+	cfg := &analyzer.GroupConfig{
+		Pusher:  "latency",
+		By:      "label",
+		Label:   "workers",
+		Outlier: newTestOutlierOpts(),
+	}
+	groups := analyzer.NewGroupRegistry()
+	for pid := uint32(1); pid <= 4; pid++ {
+		tap, err := groups.Join(memberKey(pid), "workers", cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := 10.0
+		if pid == 4 {
+			v = 100
+		}
+		tap.Observe(pushFunc.NewDataPair(v, time.Now()))
+	}
+	mfs, err := groups.Gather()
+	if errs, ok := err.(collector.MultiError); ok && len(errs) > 0 {
+		t.Fatal(err)
+	}
+	outliers := 0
+	for _, l := range mfs {
+		for _, mf := range l {
+			for _, m := range mf.Metric {
+				s := m.String()
+				if m.Gauge != nil && m.Gauge.GetValue() > 3 {
+					outliers++
+					if !strings.Contains(s, `value:"4"`) || !strings.Contains(s, `value:"workers"`) {
+						t.Errorf("Expected outlier PID 4 of group workers, got %s.", s)
+					}
+				}
+			}
+		}
+	}
+	if outliers != 1 {
+		t.Errorf("Expected 1 outlier, got %d.", outliers)
+	}
+	if cfg.Outlier.ConstLabels != nil {
+		t.Errorf("Labels of config should not be changed, got %v.", cfg.Outlier.ConstLabels)
+	}
+}
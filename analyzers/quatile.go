@@ -1,6 +1,7 @@
 package analyzer
 
 import (
+	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
@@ -105,13 +106,60 @@ func (q *QuantileAnalyzer) Analyze(data []*pushFunc.DataPair, ch chan<- collecto
 
 }
 
+// quantileState is the state of QuantileAnalyzer saved by MarshalState
+type quantileState struct {
+	Count   uint64           `json:"count"`
+	Sum     float64          `json:"sum"`
+	Samples quantile.Samples `json:"samples"`
+}
+
+func (q *QuantileAnalyzer) MarshalState() ([]byte, error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return json.Marshal(&quantileState{
+		Count:   q.count,
+		Sum:     q.sum,
+		Samples: q.stream.Samples(),
+	})
+}
+
+func (q *QuantileAnalyzer) UnmarshalState(data []byte) error {
+	state := quantileState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	q.stream.Reset()
+	q.stream.Merge(state.Samples)
+	q.count = state.Count
+	q.sum = state.Sum
+	return nil
+}
+
 // Opts used to generate QuantileAnalyzer, Ranks is the predefined targets when analyze
 // ConstLabels must not contain "analyzer" and "quatileTarget".
 //
 // Quatile implements interface StatefulAnaOpt and StatelessAnaOpt
 type QuantileOpts struct {
 	collector.Opts `yaml:"desc"`
-	Ranks          map[float64]string `yaml:"targets"`
+	Ranks          QuantileTargets `yaml:"targets"`
+}
+
+// QuantileTargets map quantile ranks to their alert rules, it can also be
+// unmarshaled from a list of ranks without alert.
+type QuantileTargets map[float64]*AlertRule
+
+func (t *QuantileTargets) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var ranks []float64
+	if err := unmarshal(&ranks); err == nil {
+		*t = make(QuantileTargets, len(ranks))
+		for _, r := range ranks {
+			(*t)[r] = nil
+		}
+		return nil
+	}
+	return unmarshal((*map[float64]*AlertRule)(t))
 }
 
 func NewQuatileAna(q *QuantileOpts) (*QuantileAnalyzer, error) {
@@ -147,7 +195,7 @@ func NewQuatileAna(q *QuantileOpts) (*QuantileAnalyzer, error) {
 	// 	descs = append(descs, desc)
 	// }
 	for k, v := range q.Ranks {
-		r, err := NewAlertFromStr(
+		r, err := NewAlert(
 			&q.Opts,
 			collector.Labels{
 				"quantile_rank": fmt.Sprint(k),
@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"gopkg.in/yaml.v2"
 	analyzer "wanggj.com/abyss/analyzers"
 	"wanggj.com/abyss/collector"
 	"wanggj.com/abyss/collector/pushFunc"
@@ -23,12 +24,38 @@ var (
 				"b": "b",
 			},
 		},
-		Ranks: map[float64]string{
-			0.9: "bigger:0.1:3",
+		Ranks: map[float64]*analyzer.AlertRule{
+			0.9: {Op: "bigger", Value: 0.1, Level: 3},
 		},
 	}
 )
 
+func TestQuantileTargetsYaml(t *testing.T) {
+	cases := map[string]int{
+		"targets:\n- 0.5\n- 0.9\n":                     0,
+		"targets:\n  0.5: none\n  0.9: bigger:9.8:3\n": 1,
+	}
+	for str, alerts := range cases {
+		var opt analyzer.QuantileOpts
+		if err := yaml.Unmarshal([]byte(str), &opt); err != nil {
+			t.Fatal(err)
+		}
+		ana, err := analyzer.NewQuatileAna(&opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, a := range ana.Ranks {
+			if a != nil {
+				n++
+			}
+		}
+		if len(ana.Ranks) != 2 || n != alerts {
+			t.Errorf("Expected 2 targets with %d alerts of %q, got %v.", alerts, str, ana.Ranks)
+		}
+	}
+}
+
 func generateRandomData(min, max float64, length int) []*pushFunc.DataPair {
 	min, max = math.Abs(min), math.Abs(max)
 	if min > max {
@@ -0,0 +1,107 @@
+package analyzer
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+)
+
+// RateAnalyzer is a stateful analyzer, it calculates change per second
+// between each two adjacent data observed. Each time Collect is called, all
+// rates since last Collect are sent as gauges with their timestamps, so it is
+// useful as the upstream of other analyzers, such as rate -> quantile.
+//
+// Decrease of value is treated as a reset of counter if Counter is true, and
+// the rate is calculated from 0.
+type RateAnalyzer struct {
+	Desc    *collector.Desc
+	Counter bool
+
+	last  *pushFunc.DataPair
+	rates []*pushFunc.DataPair
+	mtx   sync.Mutex
+}
+
+func (r *RateAnalyzer) Describe(ch chan<- *collector.Desc) {
+	ch <- r.Desc
+}
+
+func (r *RateAnalyzer) Observe(data *pushFunc.DataPair) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	last := r.last
+	if last != nil && !data.Timestamp.After(last.Timestamp) {
+		return
+	}
+	r.last = data
+	if last == nil {
+		return
+	}
+	diff := data.Value - last.Value
+	if r.Counter && diff < 0 {
+		diff = data.Value
+	}
+	r.rates = append(r.rates, pushFunc.NewDataPair(
+		diff/data.Timestamp.Sub(last.Timestamp).Seconds(),
+		data.Timestamp,
+	))
+}
+
+func (r *RateAnalyzer) Collect(ch chan<- collector.Metric) {
+	r.mtx.Lock()
+	rates := r.rates
+	r.rates = nil
+	r.mtx.Unlock()
+
+	for _, d := range rates {
+		cm, err := collector.NewConstMetric(
+			r.Desc,
+			collector.GaugeValue,
+			d.Value,
+		)
+		if err != nil {
+			glog.Error(err)
+			return
+		}
+		ch <- collector.NewTimeStampMetric(d.Timestamp, cm)
+	}
+}
+
+// RateOpts is used to generate RateAnalyzer, ConstLabels must not contain
+// "analyzer".
+//
+// RateOpts implements interface StatefulAnaOpt
+type RateOpts struct {
+	collector.Opts `yaml:"desc"`
+	Counter        bool `yaml:"counter,omitempty"`
+}
+
+func NewRateAna(opt *RateOpts) (*RateAnalyzer, error) {
+	if err := checkOptLabels(opt.ConstLabels, []string{"analyzer"}); err != nil {
+		return nil, err
+	}
+	newLabels := collector.Labels{}
+	for n, v := range opt.ConstLabels {
+		newLabels[n] = v
+	}
+	newLabels["analyzer"] = "Rate"
+	desc := collector.NewDesc(
+		opt.Name,
+		opt.Help,
+		opt.Level,
+		opt.Priority,
+		nil,
+		newLabels,
+	)
+	return &RateAnalyzer{
+		Desc:    desc,
+		Counter: opt.Counter,
+	}, nil
+}
+
+func (ro *RateOpts) NewStatefulAna() (collector.StatefulAnalyzer, error) {
+	return NewRateAna(ro)
+}
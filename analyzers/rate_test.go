@@ -0,0 +1,53 @@
+package analyzer_test
+
+import (
+	"testing"
+	"time"
+
+	analyzer "wanggj.com/abyss/analyzers"
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+)
+
+func TestRate(t *testing.T) {
+	ra, err := analyzer.NewRateAna(&analyzer.RateOpts{
+		Opts: collector.Opts{
+			Name:  "rate",
+			Help:  "this is rate",
+			Level: collector.LevelInfo,
+		},
+		Counter: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for i, v := range []float64{0, 10, 10, 5} {
+		ra.Observe(pushFunc.NewDataPair(v, now.Add(time.Duration(i)*time.Second)))
+	}
+	// data with the same timestamp is ignored
+	ra.Observe(pushFunc.NewDataPair(100, now.Add(3*time.Second)))
+
+	ch := make(chan collector.Metric, 10)
+	ra.Collect(ch)
+	close(ch)
+	result := []float64{}
+	for m := range ch {
+		md, err := m.Write()
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, md.Gauge.GetValue())
+	}
+	// 5 is a counter reset
+	if len(result) != 3 || result[0] != 10 || result[1] != 0 || result[2] != 5 {
+		t.Errorf("Expected rates [10 0 5], got %v.", result)
+	}
+
+	ch = make(chan collector.Metric, 10)
+	ra.Collect(ch)
+	if len(ch) != 0 {
+		t.Errorf("Expected no rate after collected, got %d.", len(ch))
+	}
+}
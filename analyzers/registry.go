@@ -0,0 +1,201 @@
+package analyzer
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AnaOpt is the option decoded from AnaConfig.Opt, it must implement
+// StatefulAnaOpt, StatelessAnaOpt or both. Options embedding collector.Opts
+// implement AnaOpt.
+type AnaOpt interface {
+	SetConstLabel(name, value string)
+}
+
+// OptFactory returns a new AnaOpt filled with default values, the values are
+// kept if their fields are absent in config. AnaOpt must be a pointer to
+// struct.
+type OptFactory func() AnaOpt
+
+var (
+	anaFactories    = map[string]OptFactory{}
+	anaFactoriesMtx sync.RWMutex
+)
+
+// Register makes an analyzer available in AnaConfig by typeName, it is
+// usually called in init of the package implementing the analyzer.
+func Register(typeName string, factory OptFactory) error {
+	if typeName == "" || factory == nil {
+		return fmt.Errorf("Analyzer type name and factory must not be empty.")
+	}
+	opt := factory()
+	if v := reflect.ValueOf(opt); v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Opt of analyzer %q must be a pointer to struct, got %T.", typeName, opt)
+	}
+	_, stateful := opt.(StatefulAnaOpt)
+	_, stateless := opt.(StatelessAnaOpt)
+	if !stateful && !stateless {
+		return fmt.Errorf(
+			"Opt of analyzer %q implements neither StatefulAnaOpt nor StatelessAnaOpt.",
+			typeName,
+		)
+	}
+
+	anaFactoriesMtx.Lock()
+	defer anaFactoriesMtx.Unlock()
+	if _, ok := anaFactories[typeName]; ok {
+		return fmt.Errorf("Analyzer type %q has been registered.", typeName)
+	}
+	anaFactories[typeName] = factory
+	return nil
+}
+
+// MustRegister works like Register but panics on error
+func MustRegister(typeName string, factory OptFactory) {
+	if err := Register(typeName, factory); err != nil {
+		panic(err)
+	}
+}
+
+// newAnaOpt returns a new AnaOpt with default values of typeName
+func newAnaOpt(typeName string) (AnaOpt, error) {
+	anaFactoriesMtx.RLock()
+	factory, ok := anaFactories[typeName]
+	anaFactoriesMtx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf(
+			"Unrecongnized config type %q, available types are %v.",
+			typeName,
+			analyzerTypes(),
+		)
+	}
+	return factory(), nil
+}
+
+func analyzerTypes() []string {
+	anaFactoriesMtx.RLock()
+	defer anaFactoriesMtx.RUnlock()
+	result := make([]string, 0, len(anaFactories))
+	for t := range anaFactories {
+		result = append(result, t)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// FieldSchema describes a field of analyzer option, Name is the yaml key,
+// keys of nested options are joined by ".". Default is empty if the default
+// value is zero.
+type FieldSchema struct {
+	Name    string
+	Type    string
+	Default string
+}
+
+// AnaSchema describes an analyzer registered
+type AnaSchema struct {
+	Type      string
+	Stateful  bool
+	Stateless bool
+	Fields    []FieldSchema
+}
+
+// ListAnalyzers returns all registered analyzers ordered by type
+func ListAnalyzers() []AnaSchema {
+	types := analyzerTypes()
+	result := make([]AnaSchema, 0, len(types))
+	for _, t := range types {
+		opt, err := newAnaOpt(t)
+		if err != nil {
+			continue
+		}
+		_, stateful := opt.(StatefulAnaOpt)
+		_, stateless := opt.(StatelessAnaOpt)
+		result = append(result, AnaSchema{
+			Type:      t,
+			Stateful:  stateful,
+			Stateless: stateless,
+			Fields:    schemaFields(reflect.ValueOf(opt).Elem(), ""),
+		})
+	}
+	return result
+}
+
+var (
+	durationType  = reflect.TypeOf(time.Duration(0))
+	alertRuleType = reflect.TypeOf(AlertRule{})
+)
+
+// schemaFields returns fields of struct v, prefix is prepended to names
+func schemaFields(v reflect.Value, prefix string) []FieldSchema {
+	result := []FieldSchema{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name, flags, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fv := v.Field(i)
+
+		// nested options
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer && ft.Elem().Kind() == reflect.Struct && ft.Elem() != alertRuleType {
+			if fv.IsNil() {
+				fv = reflect.New(ft.Elem())
+			}
+			fv, ft = fv.Elem(), ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && ft != alertRuleType {
+			subPrefix := prefix + name + "."
+			if strings.Contains(flags, "inline") {
+				subPrefix = prefix
+			}
+			result = append(result, schemaFields(fv, subPrefix)...)
+			continue
+		}
+
+		field := FieldSchema{
+			Name: prefix + name,
+			Type: schemaType(ft),
+		}
+		if !fv.IsZero() {
+			field.Default = fmt.Sprint(fv.Interface())
+		}
+		result = append(result, field)
+	}
+	return result
+}
+
+func schemaType(t reflect.Type) string {
+	switch {
+	case t == durationType:
+		return "duration"
+	case t == alertRuleType || (t.Kind() == reflect.Pointer && t.Elem() == alertRuleType):
+		return "alert rule"
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Map:
+		return fmt.Sprintf("map[%s]%s", schemaType(t.Key()), schemaType(t.Elem()))
+	case reflect.Slice, reflect.Array:
+		return fmt.Sprintf("list[%s]", schemaType(t.Elem()))
+	case reflect.Pointer:
+		return schemaType(t.Elem())
+	}
+	return t.Kind().String()
+}
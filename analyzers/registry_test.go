@@ -0,0 +1,138 @@
+package analyzer_test
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+	analyzer "wanggj.com/abyss/analyzers"
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+)
+
+// lastOpts is an analyzer registered by test, it sends the last value
+type lastOpts struct {
+	collector.Opts `yaml:"desc"`
+	Scale          float64 `yaml:"scale"`
+}
+
+type lastAna struct {
+	desc  *collector.Desc
+	scale float64
+}
+
+func (l *lastAna) Describe(ch chan<- *collector.Desc) {
+	ch <- l.desc
+}
+
+func (l *lastAna) Analyze(data []*pushFunc.DataPair, ch chan<- collector.Metric) {
+	if len(data) == 0 {
+		return
+	}
+	cm, err := collector.NewConstMetric(l.desc, collector.GaugeValue, data[len(data)-1].Value*l.scale)
+	if err == nil {
+		ch <- cm
+	}
+}
+
+func (lo *lastOpts) NewStatelessAna() (collector.StatelessAnalyzer, error) {
+	return &lastAna{
+		desc:  collector.NewDesc(lo.Name, lo.Help, lo.Level, lo.Priority, nil, lo.ConstLabels),
+		scale: lo.Scale,
+	}, nil
+}
+
+func init() {
+	analyzer.MustRegister("test_last", func() analyzer.AnaOpt {
+		return &lastOpts{Scale: 1}
+	})
+}
+
+func TestRegister(t *testing.T) {
+	cfg := `
+analyzers:
+- type: test_last
+  opt:
+    desc:
+      name: last
+      help: this is last
+      level: 2
+`
+	var cfgs TestAnaConfigs
+	if err := yaml.UnmarshalStrict([]byte(cfg), &cfgs); err != nil {
+		t.Fatal(err)
+	}
+	sla, err := analyzer.GetSlaFromConfig(111, cfgs.AnaConfigs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// default scale is kept
+	if l := sla.(*lastAna); l.scale != 1 || !strings.Contains(l.desc.String(), `PID="111"`) {
+		t.Errorf("Got wrong analyzer %+v.", *l)
+	}
+
+	if err := analyzer.Register("test_last", func() analyzer.AnaOpt { return &lastOpts{} }); err == nil {
+		t.Error("Expected error of duplicated type.")
+	}
+	if err := analyzer.Register("test_bad", func() analyzer.AnaOpt { return &collector.Opts{} }); err == nil {
+		t.Error("Expected error of opt not implementing AnaOpts.")
+	}
+	if _, err := analyzer.GetAnaOptFromConfig(111, analyzer.AnaConfig{Type: "nonexist"}); err == nil {
+		t.Error("Expected error of unknown type.")
+	}
+}
+
+func TestStrictDecode(t *testing.T) {
+	cfg := `
+analyzers:
+- type: topk
+  opt:
+    desc:
+      name: topk
+      help: this is topk
+      level: 2
+    k: 5
+    windw: 1m
+`
+	var cfgs TestAnaConfigs
+	if err := yaml.UnmarshalStrict([]byte(cfg), &cfgs); err != nil {
+		t.Fatal(err)
+	}
+	_, err := analyzer.GetAnaOptFromConfig(111, cfgs.AnaConfigs[0])
+	if err == nil || !strings.Contains(err.Error(), "line 10") || !strings.Contains(err.Error(), "windw") {
+		t.Errorf("Expected error of unknown field at line 10, got %v.", err)
+	}
+}
+
+func TestListAnalyzers(t *testing.T) {
+	var topk *analyzer.AnaSchema
+	types := []string{}
+	for i, s := range analyzer.ListAnalyzers() {
+		types = append(types, s.Type)
+		if s.Type == "topk" {
+			topk = &analyzer.ListAnalyzers()[i]
+		}
+	}
+	for _, expected := range []string{"aggregation", "anomaly", "histogram", "quantile", "test_last", "topk", "trend"} {
+		if !strings.Contains(strings.Join(types, ","), expected) {
+			t.Errorf("Analyzer %s not listed in %v.", expected, types)
+		}
+	}
+	if topk == nil || !topk.Stateful || !topk.Stateless {
+		t.Fatalf("Got wrong topk schema %+v.", topk)
+	}
+
+	fields := map[string]analyzer.FieldSchema{}
+	for _, f := range topk.Fields {
+		fields[f.Name] = f
+	}
+	if f := fields["desc.constLabels"]; f.Type != "map[string]string" {
+		t.Errorf("Got wrong field %+v.", f)
+	}
+	if f := fields["mode"]; f.Type != "string" || f.Default != "count" {
+		t.Errorf("Got wrong field %+v.", f)
+	}
+	if f := fields["window"]; f.Type != "duration" {
+		t.Errorf("Got wrong field %+v.", f)
+	}
+}
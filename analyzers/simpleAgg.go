@@ -73,7 +73,7 @@ type AggregationOpts struct {
 	collector.Opts `yaml:"desc"`
 	Duration       time.Duration `yaml:"duration"`
 	Type           string        `yaml:"type"`
-	Alert          string        `yaml:"alert,omitempty"`
+	Alert          *AlertRule    `yaml:"alert,omitempty"`
 }
 
 func NewAggregation(opt *AggregationOpts) (*Aggregation, error) {
@@ -104,7 +104,7 @@ func NewAggregation(opt *AggregationOpts) (*Aggregation, error) {
 		newLabels,
 	)
 
-	alert, err := NewAlertFromStr(
+	alert, err := NewAlert(
 		&opt.Opts,
 		collector.Labels{"analyzer": opt.Type},
 		opt.Alert,
@@ -0,0 +1,291 @@
+package analyzer
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+)
+
+const (
+	maxTopK                = 100
+	defaultTopKCapacityMul = 10
+)
+
+// topkEntry is a counter of key in spaceSaving, err is the maximal
+// overestimation of count
+type topkEntry struct {
+	key   string
+	count float64
+	err   float64
+	idx   int
+}
+
+// spaceSaving implements the Space-Saving algorithm, it keeps at most
+// capacity counters, when a new key comes and it is full, the smallest
+// counter is replaced by the new key and inherits its count as err.
+type spaceSaving struct {
+	capacity int
+	entries  map[string]*topkEntry
+	// minHeap orders entries by count
+	minHeap topkHeap
+}
+
+type topkHeap []*topkEntry
+
+func (h topkHeap) Len() int           { return len(h) }
+func (h topkHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h topkHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].idx = i
+	h[j].idx = j
+}
+func (h *topkHeap) Push(x interface{}) {
+	e := x.(*topkEntry)
+	e.idx = len(*h)
+	*h = append(*h, e)
+}
+func (h *topkHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{
+		capacity: capacity,
+		entries:  make(map[string]*topkEntry, capacity),
+		minHeap:  make(topkHeap, 0, capacity),
+	}
+}
+
+func (s *spaceSaving) insert(key string, weight float64) {
+	if e, ok := s.entries[key]; ok {
+		e.count += weight
+		heap.Fix(&s.minHeap, e.idx)
+		return
+	}
+	if len(s.minHeap) < s.capacity {
+		e := &topkEntry{key: key, count: weight}
+		s.entries[key] = e
+		heap.Push(&s.minHeap, e)
+		return
+	}
+	// replace the smallest counter
+	e := s.minHeap[0]
+	delete(s.entries, e.key)
+	e.key = key
+	e.err = e.count
+	e.count += weight
+	s.entries[key] = e
+	heap.Fix(&s.minHeap, 0)
+}
+
+// top returns at most k entries with the biggest counts
+func (s *spaceSaving) top(k int) []topkEntry {
+	result := make([]topkEntry, 0, len(s.minHeap))
+	for _, e := range s.minHeap {
+		result = append(result, *e)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].count != result[j].count {
+			return result[i].count > result[j].count
+		}
+		return result[i].key < result[j].key
+	})
+	if len(result) > k {
+		result = result[:k]
+	}
+	return result
+}
+
+func (s *spaceSaving) reset() {
+	s.entries = make(map[string]*topkEntry, s.capacity)
+	s.minHeap = s.minHeap[:0]
+}
+
+// TopKAnalyzer tracks the K most frequent (Mode "count") or most expensive
+// (Mode "sum", keys weighted by Value) keys of data, data without key are
+// ignored. The keys are sent as gauges labeled with "topk_key" and
+// "topk_rank", so only K series are generated however many keys there are.
+//
+// As a stateless analyzer, it analyzes data in past Window (all data if
+// Window is 0). As a stateful analyzer, it counts data observed in the
+// current Window and starts a new one after Window passed, it counts from
+// the beginning if Window is 0.
+type TopKAnalyzer struct {
+	Desc   *collector.Desc
+	K      int
+	Mode   string
+	Window time.Duration
+
+	labels      collector.Labels
+	opt         collector.Opts
+	summary     *spaceSaving
+	windowStart time.Time
+	mtx         sync.Mutex
+}
+
+func (t *TopKAnalyzer) Describe(ch chan<- *collector.Desc) {
+	ch <- t.Desc
+}
+
+func (t *TopKAnalyzer) insert(data *pushFunc.DataPair) {
+	if data.Key == "" {
+		return
+	}
+	weight := 1.0
+	if t.Mode == "sum" {
+		if data.Value <= 0 {
+			return
+		}
+		weight = data.Value
+	}
+	t.summary.insert(data.Key, weight)
+}
+
+func (t *TopKAnalyzer) collectMetric(ch chan<- collector.Metric) {
+	tp := time.Now()
+	for i, e := range t.summary.top(t.K) {
+		labels := collector.Labels{}
+		for n, v := range t.labels {
+			labels[n] = v
+		}
+		labels["topk_key"] = e.key
+		labels["topk_rank"] = fmt.Sprint(i + 1)
+		desc := collector.NewDesc(
+			t.opt.Name,
+			t.opt.Help,
+			t.opt.Level,
+			t.opt.Priority,
+			nil,
+			labels,
+		)
+		cm, err := collector.NewConstMetric(
+			desc,
+			collector.GaugeValue,
+			e.count,
+		)
+		if err != nil {
+			glog.Error(err)
+			continue
+		}
+		ch <- collector.NewTimeStampMetric(tp, cm)
+	}
+}
+
+func (t *TopKAnalyzer) Observe(data *pushFunc.DataPair) {
+	t.mtx.Lock()
+	t.insert(data)
+	t.mtx.Unlock()
+}
+
+func (t *TopKAnalyzer) Collect(ch chan<- collector.Metric) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.collectMetric(ch)
+	if t.Window > 0 && time.Since(t.windowStart) >= t.Window {
+		t.summary.reset()
+		t.windowStart = time.Now()
+	}
+}
+
+func (t *TopKAnalyzer) Analyze(data []*pushFunc.DataPair, ch chan<- collector.Metric) {
+	start := 0
+	if t.Window > 0 {
+		oldestTime := time.Now().Add(-t.Window)
+		start = len(data)
+		for ; start > 0 && data[start-1].Timestamp.After(oldestTime); start-- {
+		}
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for _, d := range data[start:] {
+		t.insert(d)
+	}
+	t.collectMetric(ch)
+	t.summary.reset()
+}
+
+// TopKOpts is used to generate TopKAnalyzer, Capacity is the number of
+// counters kept, the bigger it is the more accurate the result is, default
+// is 10 times of K. ConstLabels must not contain "analyzer", "topk_key" and
+// "topk_rank".
+//
+// TopKOpts implements interface StatefulAnaOpt and StatelessAnaOpt
+type TopKOpts struct {
+	collector.Opts `yaml:"desc"`
+	K              int           `yaml:"k"`
+	Mode           string        `yaml:"mode"`
+	Window         time.Duration `yaml:"window,omitempty"`
+	Capacity       int           `yaml:"capacity,omitempty"`
+}
+
+func NewTopKAna(opt *TopKOpts) (*TopKAnalyzer, error) {
+	if opt.K <= 0 || opt.K > maxTopK {
+		return nil, fmt.Errorf("TopK k must in [1, %d], got %d.", maxTopK, opt.K)
+	}
+	mode := opt.Mode
+	if mode == "" {
+		mode = "count"
+	}
+	if mode != "count" && mode != "sum" {
+		return nil, fmt.Errorf("TopK mode must be count or sum, got %q.", opt.Mode)
+	}
+	if opt.Window < 0 {
+		return nil, fmt.Errorf("TopK window must not be negative.")
+	}
+	capacity := opt.Capacity
+	if capacity == 0 {
+		capacity = opt.K * defaultTopKCapacityMul
+	}
+	if capacity < opt.K {
+		return nil, fmt.Errorf("TopK capacity %d is smaller than k %d.", capacity, opt.K)
+	}
+	if err := checkOptLabels(
+		opt.ConstLabels,
+		[]string{"analyzer", "topk_key", "topk_rank"},
+	); err != nil {
+		return nil, err
+	}
+
+	newLabels := collector.Labels{}
+	for n, v := range opt.ConstLabels {
+		newLabels[n] = v
+	}
+	newLabels["analyzer"] = "TopK"
+	desc := collector.NewDesc(
+		opt.Name,
+		opt.Help,
+		opt.Level,
+		opt.Priority,
+		nil,
+		newLabels,
+	)
+
+	return &TopKAnalyzer{
+		Desc:        desc,
+		K:           opt.K,
+		Mode:        mode,
+		Window:      opt.Window,
+		labels:      newLabels,
+		opt:         opt.Opts,
+		summary:     newSpaceSaving(capacity),
+		windowStart: time.Now(),
+	}, nil
+}
+
+func (to *TopKOpts) NewStatelessAna() (collector.StatelessAnalyzer, error) {
+	return NewTopKAna(to)
+}
+
+func (to *TopKOpts) NewStatefulAna() (collector.StatefulAnalyzer, error) {
+	return NewTopKAna(to)
+}
@@ -0,0 +1,160 @@
+package analyzer_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	analyzer "wanggj.com/abyss/analyzers"
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+)
+
+type topkResult struct {
+	key   string
+	rank  string
+	value float64
+}
+
+func newTestTopKOpts(k int, mode string) *analyzer.TopKOpts {
+	return &analyzer.TopKOpts{
+		Opts: collector.Opts{
+			Name:  "topk",
+			Help:  "this is topk",
+			Level: collector.LevelInfo,
+			ConstLabels: collector.Labels{
+				"a": "a",
+			},
+		},
+		K:    k,
+		Mode: mode,
+	}
+}
+
+func readTopK(t *testing.T, collect func(ch chan<- collector.Metric)) []topkResult {
+	metrics, _ := collectMetrics(t, collect)
+	result := []topkResult{}
+	for _, md := range metrics {
+		r := topkResult{value: md.Gauge.GetValue()}
+		for _, lp := range md.Label {
+			switch lp.GetName() {
+			case "topk_key":
+				r.key = lp.GetValue()
+			case "topk_rank":
+				r.rank = lp.GetValue()
+			}
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+func TestTopKCount(t *testing.T) {
+	ta, err := analyzer.NewTopKAna(newTestTopKOpts(2, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	data := []*pushFunc.DataPair{
+		pushFunc.NewDataPair(100, now),
+	}
+	for i, key := range []string{"read", "write", "read", "open", "write", "read"} {
+		data = append(data, pushFunc.NewKeyedDataPair(key, float64(i), now))
+	}
+
+	result := readTopK(t, func(ch chan<- collector.Metric) {
+		ta.Analyze(data, ch)
+	})
+	expected := []topkResult{{"read", "1", 3}, {"write", "2", 2}}
+	if fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v.", expected, result)
+	}
+
+	// stateless analyzer does not keep data
+	if result := readTopK(t, func(ch chan<- collector.Metric) {
+		ta.Analyze(nil, ch)
+	}); len(result) != 0 {
+		t.Errorf("Expected nothing, got %v.", result)
+	}
+}
+
+func TestTopKSum(t *testing.T) {
+	opt := newTestTopKOpts(3, "sum")
+	opt.Capacity = 5
+	ta, err := analyzer.NewTopKAna(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// heavy hitters among lots of light keys
+	for i := 0; i < 1000; i++ {
+		ta.Observe(pushFunc.NewKeyedDataPair(fmt.Sprintf("light%d", i), 1, time.Now()))
+		if i%10 == 0 {
+			ta.Observe(pushFunc.NewKeyedDataPair("heavy1", 30, time.Now()))
+			ta.Observe(pushFunc.NewKeyedDataPair("heavy2", 20, time.Now()))
+		}
+	}
+	result := readTopK(t, ta.Collect)
+	if len(result) != 3 {
+		t.Fatalf("Expected 3 keys, got %v.", result)
+	}
+	if result[0].key != "heavy1" || result[1].key != "heavy2" {
+		t.Errorf("Expected heavy keys first, got %v.", result)
+	}
+	// count of space saving is never underestimated
+	if result[0].value < 3000 || result[1].value < 2000 {
+		t.Errorf("Heavy keys are underestimated: %v.", result)
+	}
+}
+
+func TestTopKWindow(t *testing.T) {
+	opt := newTestTopKOpts(5, "count")
+	opt.Window = 100 * time.Millisecond
+	ta, err := analyzer.NewTopKAna(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stateless: data out of window are ignored
+	old := time.Now().Add(-time.Second)
+	data := []*pushFunc.DataPair{
+		pushFunc.NewKeyedDataPair("old", 1, old),
+		pushFunc.NewKeyedDataPair("new", 1, time.Now()),
+	}
+	if result := readTopK(t, func(ch chan<- collector.Metric) {
+		ta.Analyze(data, ch)
+	}); len(result) != 1 || result[0].key != "new" {
+		t.Errorf("Expected only key new, got %v.", result)
+	}
+
+	// stateful: a new window is started after Window passed
+	ta.Observe(pushFunc.NewKeyedDataPair("first", 1, time.Now()))
+	time.Sleep(opt.Window)
+	if result := readTopK(t, ta.Collect); len(result) != 1 || result[0].key != "first" {
+		t.Errorf("Expected key first, got %v.", result)
+	}
+	ta.Observe(pushFunc.NewKeyedDataPair("second", 1, time.Now()))
+	if result := readTopK(t, ta.Collect); len(result) != 1 || result[0].key != "second" {
+		t.Errorf("Expected key second in new window, got %v.", result)
+	}
+}
+
+func TestTopKOptsError(t *testing.T) {
+	opts := []*analyzer.TopKOpts{
+		newTestTopKOpts(0, "count"),
+		newTestTopKOpts(1000, "count"),
+		newTestTopKOpts(3, "avg"),
+	}
+	opt := newTestTopKOpts(3, "count")
+	opt.Capacity = 2
+	opts = append(opts, opt)
+	opt = newTestTopKOpts(3, "count")
+	opt.ConstLabels["topk_key"] = "a"
+	opts = append(opts, opt)
+
+	for _, o := range opts {
+		if _, err := analyzer.NewTopKAna(o); err == nil {
+			t.Errorf("Expected error of opt %+v.", *o)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package analyzer
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang/glog"
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+)
+
+const (
+	defaultTrendMinPoints = 3
+	// maxTimeToThreshold caps the predicted time in seconds, it is also used
+	// when data is not approaching Threshold
+	maxTimeToThreshold = float64(365 * 24 * 3600)
+)
+
+// TrendAnalyzer is a stateless analyzer, it fits a linear regression over
+// data in past Duration (all data if Duration is 0) and predicts when the
+// value will reach Threshold, which is useful to find memory or fd leaks.
+// Direction is "up" if value grows to Threshold (such as rss to a limit),
+// or "down" if value drops to it (such as free memory).
+//
+// Two gauges are sent, labeled with "trend" of "slope" (change of value per
+// second) and "time_to_threshold" (seconds), the latter is 0 if Threshold
+// has been reached and maxTimeToThreshold if data is not approaching it.
+// The time to threshold is compared by Alert.
+type TrendAnalyzer struct {
+	SlopeDesc    *collector.Desc
+	TimeLeftDesc *collector.Desc
+	Threshold    float64
+	Direction    string
+	Duration     time.Duration
+	MinPoints    int
+	alert        *Alert
+}
+
+func (t *TrendAnalyzer) Describe(ch chan<- *collector.Desc) {
+	ch <- t.SlopeDesc
+	ch <- t.TimeLeftDesc
+}
+
+// linearRegression returns slope per second and the fitted value at the
+// time of last data, ok is false if all data have the same timestamp.
+func linearRegression(data []*pushFunc.DataPair) (slope, fitted float64, ok bool) {
+	// use seconds since the first data as x to keep precision
+	base := data[0].Timestamp
+	n := float64(len(data))
+	var sumX, sumY, sumXY, sumXX float64
+	for _, d := range data {
+		x := d.Timestamp.Sub(base).Seconds()
+		sumX += x
+		sumY += d.Value
+		sumXY += x * d.Value
+		sumXX += x * x
+	}
+	denom := n*sumXX - sumX*sumX
+	if denom <= 0 {
+		return 0, 0, false
+	}
+	slope = (n*sumXY - sumX*sumY) / denom
+	intercept := (sumY - slope*sumX) / n
+	lastX := data[len(data)-1].Timestamp.Sub(base).Seconds()
+	return slope, intercept + slope*lastX, true
+}
+
+// timeToThreshold returns seconds before value reaches threshold, value
+// grows to threshold if up is true, otherwise drops to it.
+func timeToThreshold(slope, value, threshold float64, up bool) float64 {
+	if !up {
+		slope, value, threshold = -slope, -value, -threshold
+	}
+	if value >= threshold {
+		return 0
+	}
+	if slope <= 0 {
+		return maxTimeToThreshold
+	}
+	return math.Min((threshold-value)/slope, maxTimeToThreshold)
+}
+
+func (t *TrendAnalyzer) Analyze(data []*pushFunc.DataPair, ch chan<- collector.Metric) {
+	start := 0
+	if t.Duration > 0 {
+		oldestTime := time.Now().Add(-t.Duration)
+		start = len(data)
+		for ; start > 0 && data[start-1].Timestamp.After(oldestTime); start-- {
+		}
+	}
+	data = data[start:]
+	if len(data) < t.MinPoints {
+		return
+	}
+	slope, fitted, ok := linearRegression(data)
+	if !ok {
+		return
+	}
+	timeLeft := timeToThreshold(slope, fitted, t.Threshold, t.Direction == "up")
+
+	tp := time.Now()
+	for desc, v := range map[*collector.Desc]float64{
+		t.SlopeDesc:    slope,
+		t.TimeLeftDesc: timeLeft,
+	} {
+		cm, err := collector.NewConstMetric(
+			desc,
+			collector.GaugeValue,
+			v,
+		)
+		if err != nil {
+			glog.Error(err)
+			continue
+		}
+		ch <- collector.NewTimeStampMetric(tp, cm)
+	}
+
+	if t.alert != nil && t.alert.compare(timeLeft, tp) {
+		ch <- t.alert
+	}
+}
+
+// TrendOpts is used to generate TrendAnalyzer, at least MinPoints (default 3)
+// data are needed to predict, Direction is "up" by default. Alert compares
+// the time to threshold in seconds, e.g. "<" 3600 alerts if Threshold will be
+// reached within an hour.
+// ConstLabels must not contain "analyzer" and "trend".
+//
+// TrendOpts implements interface StatelessAnaOpt
+type TrendOpts struct {
+	collector.Opts `yaml:"desc"`
+	Threshold      float64       `yaml:"threshold"`
+	Direction      string        `yaml:"direction,omitempty"`
+	Duration       time.Duration `yaml:"duration,omitempty"`
+	MinPoints      int           `yaml:"minPoints,omitempty"`
+	Alert          *AlertRule    `yaml:"alert,omitempty"`
+}
+
+func NewTrendAna(opt *TrendOpts) (*TrendAnalyzer, error) {
+	if err := checkOptLabels(opt.ConstLabels, []string{"analyzer", "trend"}); err != nil {
+		return nil, err
+	}
+	if opt.Duration < 0 {
+		return nil, fmt.Errorf("Trend duration must not be negative.")
+	}
+	direction := opt.Direction
+	if direction == "" {
+		direction = "up"
+	}
+	if direction != "up" && direction != "down" {
+		return nil, fmt.Errorf("Trend direction must be up or down, got %q.", opt.Direction)
+	}
+	minPoints := opt.MinPoints
+	if minPoints == 0 {
+		minPoints = defaultTrendMinPoints
+	}
+	if minPoints < 2 {
+		return nil, fmt.Errorf("Trend minPoints must be bigger than 1, got %d.", minPoints)
+	}
+
+	newDesc := func(trend string) *collector.Desc {
+		labels := collector.Labels{}
+		for n, v := range opt.ConstLabels {
+			labels[n] = v
+		}
+		labels["analyzer"] = "Trend"
+		labels["trend"] = trend
+		return collector.NewDesc(
+			opt.Name,
+			opt.Help,
+			opt.Level,
+			opt.Priority,
+			nil,
+			labels,
+		)
+	}
+
+	alert, err := NewAlert(
+		&opt.Opts,
+		collector.Labels{"analyzer": "Trend"},
+		opt.Alert,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TrendAnalyzer{
+		SlopeDesc:    newDesc("slope"),
+		TimeLeftDesc: newDesc("time_to_threshold"),
+		Threshold:    opt.Threshold,
+		Direction:    direction,
+		Duration:     opt.Duration,
+		MinPoints:    minPoints,
+		alert:        alert,
+	}, nil
+}
+
+func (to *TrendOpts) NewStatelessAna() (collector.StatelessAnalyzer, error) {
+	return NewTrendAna(to)
+}
@@ -0,0 +1,120 @@
+package analyzer_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	analyzer "wanggj.com/abyss/analyzers"
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+)
+
+func newTestTrendOpts() *analyzer.TrendOpts {
+	return &analyzer.TrendOpts{
+		Opts: collector.Opts{
+			Name:  "trend",
+			Help:  "this is trend",
+			Level: collector.LevelInfo,
+		},
+		Threshold: 1000,
+		Alert:     &analyzer.AlertRule{Op: "<", Value: 60, Level: 3},
+	}
+}
+
+// linearData generates data growing by slope per second in past n seconds
+func linearData(start, slope float64, n int) []*pushFunc.DataPair {
+	now := time.Now()
+	result := make([]*pushFunc.DataPair, 0, n)
+	for i := 0; i < n; i++ {
+		tp := now.Add(time.Duration(i-n+1) * time.Second)
+		result = append(result, pushFunc.NewDataPair(start+slope*float64(i), tp))
+	}
+	return result
+}
+
+// analyzeTrend returns values labeled by trend and number of alerts
+func analyzeTrend(t *testing.T, ta *analyzer.TrendAnalyzer, data []*pushFunc.DataPair) (map[string]float64, int) {
+	metrics, alerts := collectMetrics(t, func(ch chan<- collector.Metric) {
+		ta.Analyze(data, ch)
+	})
+	result := map[string]float64{}
+	for _, md := range metrics {
+		for _, lp := range md.Label {
+			if lp.GetName() == "trend" {
+				result[lp.GetValue()] = md.Gauge.GetValue()
+			}
+		}
+	}
+	return result, alerts
+}
+
+func TestTrendPredict(t *testing.T) {
+	ta, err := analyzer.NewTrendAna(newTestTrendOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 10 per second from 0, reaches 1000 after 91 seconds
+	result, alerts := analyzeTrend(t, ta, linearData(0, 10, 10))
+	if math.Abs(result["slope"]-10) > 1e-6 || math.Abs(result["time_to_threshold"]-91) > 1e-6 {
+		t.Errorf("Expected slope 10 and 91s left, got %v.", result)
+	}
+	if alerts != 0 {
+		t.Errorf("Expected no alert, got %d.", alerts)
+	}
+
+	// reaches 1000 after 31 seconds, within a minute
+	result, alerts = analyzeTrend(t, ta, linearData(600, 10, 10))
+	if math.Abs(result["time_to_threshold"]-31) > 1e-6 || alerts != 1 {
+		t.Errorf("Expected 31s left with an alert, got %v and %d alerts.", result, alerts)
+	}
+
+	// decreasing data never reaches threshold, alert resolved
+	result, alerts = analyzeTrend(t, ta, linearData(600, -10, 10))
+	if result["slope"] >= 0 || result["time_to_threshold"] < 3600 || alerts != 1 {
+		t.Errorf("Expected no exhaustion with a resolved alert, got %v and %d alerts.", result, alerts)
+	}
+
+	// not enough data
+	if result, _ := analyzeTrend(t, ta, linearData(0, 1, 2)); len(result) != 0 {
+		t.Errorf("Expected nothing with 2 data, got %v.", result)
+	}
+}
+
+func TestTrendDown(t *testing.T) {
+	opt := newTestTrendOpts()
+	opt.Direction = "down"
+	opt.Threshold = 100
+	ta, err := analyzer.NewTrendAna(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, _ := analyzeTrend(t, ta, linearData(500, -20, 5))
+	if math.Abs(result["time_to_threshold"]-16) > 1e-6 {
+		t.Errorf("Expected 16s left, got %v.", result)
+	}
+	result, _ = analyzeTrend(t, ta, linearData(50, -1, 5))
+	if result["time_to_threshold"] != 0 {
+		t.Errorf("Expected threshold reached, got %v.", result)
+	}
+}
+
+func TestTrendOptsError(t *testing.T) {
+	opt := newTestTrendOpts()
+	opt.Direction = "left"
+	if _, err := analyzer.NewTrendAna(opt); err == nil {
+		t.Error("Expected error of illegal direction.")
+	}
+	opt = newTestTrendOpts()
+	opt.Alert.Level = 0
+	if _, err := analyzer.NewTrendAna(opt); err == nil {
+		t.Error("Expected error of alert level.")
+	}
+	opt = newTestTrendOpts()
+	opt.MinPoints = 1
+	if _, err := analyzer.NewTrendAna(opt); err == nil {
+		t.Error("Expected error of minPoints.")
+	}
+}
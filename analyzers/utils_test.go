@@ -0,0 +1,44 @@
+package analyzer_test
+
+import (
+	"math"
+	"testing"
+
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/module"
+)
+
+// collectMetrics drains Metrics sent by collect, it returns the written
+// Metrics and the number of Events(alerts) which are not returned.
+func collectMetrics(t *testing.T, collect func(ch chan<- collector.Metric)) ([]*module.Metric, int) {
+	ch := make(chan collector.Metric, 10)
+	go func() {
+		collect(ch)
+		close(ch)
+	}()
+	result := []*module.Metric{}
+	alerts := 0
+	for m := range ch {
+		md, err := m.Write()
+		if err != nil {
+			t.Fatalf("Metric cannot Write: %s.", err.Error())
+		}
+		if md.Event != nil {
+			alerts++
+			continue
+		}
+		result = append(result, md)
+	}
+	return result, alerts
+}
+
+// collectGauge returns value of the last Gauge and the number of alerts,
+// value is NaN if no Gauge is collected.
+func collectGauge(t *testing.T, collect func(ch chan<- collector.Metric)) (float64, int) {
+	metrics, alerts := collectMetrics(t, collect)
+	value := math.NaN()
+	for _, md := range metrics {
+		value = md.Gauge.GetValue()
+	}
+	return value, alerts
+}
@@ -3,7 +3,33 @@ package analyzer
 // This file is used to gather yaml config message for all
 // analyzers.
 //
-// All the config type can be RawMessage in AnaConfig
+// All the config type can be RawMessage in AnaConfig, analyzers out of
+// this package are added by Register, and ListAnalyzers describes the
+// options of all registered analyzers.
+
+/* analyzer config(items of slana and sfana in pusher config):
+type: string(registered analyzer type)
+opt: options of the analyzer type below
+name: string(optional, referred by input of other analyzers)
+input: string(optional, name of upstream analyzer in the same pusher,
+       gauge and counter values sent by it are the input)
+export: bool(optional, false if output is only fed into downstream, default true)
+persist: bool(optional, save state across restarts, quantile, histogram and
+         anomaly only)
+*/
+
+/* alert rule:
+either the string "OP:compareValue:Level", or
+op: string(>, >=, <, <=, ==, !=, range, outside, bigger, smaller)
+value: float
+low: float(range and outside)
+high: float(range and outside)
+recover: float(optional, >, >=, <, <= and bigger, smaller only)
+for: string(optional, must fit in time.ParseDuration)
+rate: bool(optional, compare rate of change per second)
+renotify: string(optional, must fit in time.ParseDuration)
+level: int(1-4)
+*/
 
 /* aggregation:
 name: string
@@ -12,6 +38,7 @@ level: int(0-3)
 constLabels: map[string]string
 duration: string(must fit in time.ParseDuration)
 type: string(max/min)
+alert: alert rule
 */
 
 /* quatile:
@@ -19,5 +46,91 @@ name: string
 help: string
 level: int(0-3)
 constLabels: map[string]string
-targets: list[float](0-1)
+targets: map[float(0-1)]alert rule, or list[float](0-1) without alert
+*/
+
+/* histogram:
+name: string
+help: string
+level: int(0-3)
+constLabels: map[string]string
+buckets:
+  type: string(linear/exponential/explicit)
+  start: float(first upper bound, linear and exponential)
+  width: float(linear)
+  factor: float(bigger than 1, exponential)
+  count: int(1-64, linear and exponential)
+  bounds: list[float](explicit)
+alerts: map[float(upper bound)]alert rule
+*/
+
+/* anomaly(stateful only):
+name: string
+help: string
+level: int(0-3)
+constLabels: map[string]string
+alpha: float(0-1, EWMA smoothing factor)
+warmup: int(data observed before scoring, default 10)
+seasonal:
+  period: int(data in one season)
+  beta: float(0-1, trend smoothing factor)
+  gamma: float(0-1, season smoothing factor)
+alert: alert rule(compare the number of standard deviations)
+*/
+
+/* rate(stateful only):
+name: string
+help: string
+level: int(0-3)
+constLabels: map[string]string
+counter: bool(optional, decrease of value is treated as counter reset)
+*/
+
+/* topk:
+name: string
+help: string
+level: int(0-3)
+constLabels: map[string]string
+k: int(1-100)
+mode: string(count/sum, default count)
+window: string(optional, must fit in time.ParseDuration)
+capacity: int(optional, counters kept, default 10*k)
+*/
+
+/* trend(stateless only):
+name: string
+help: string
+level: int(0-3)
+constLabels: map[string]string
+threshold: float(value to predict reaching)
+direction: string(up/down, default up)
+duration: string(optional, must fit in time.ParseDuration)
+minPoints: int(optional, default 3)
+alert: alert rule(compare time to threshold in seconds)
+*/
+
+/* derived(in ProcConfig, not in pusher):
+name: string
+help: string
+level: int(0-3)
+constLabels: map[string]string
+expr: string(pushers referred by name, e.g. "rate(errors, 30s) / rate(calls, 30s)")
+alert: alert rule
+*/
+
+/* group(in ProcConfig, not in pusher):
+pusher: string(name of pusher in the same config)
+by: string(exe/config/label)
+label: string(group value, by label only)
+sfana: list of analyzer config(stateful only, labeled by group instead of PID)
+outlier(optional, score of each member against its siblings):
+  desc:
+    name: string
+    help: string
+    level: int(0-3)
+    constLabels: map[string]string
+  method: string(mad/percentile)
+  percentile: float(0-1, percentile only)
+  minMembers: int(optional, default 3)
+  alert: alert rule(compare score of each member)
 */
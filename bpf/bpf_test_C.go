@@ -1,6 +1,7 @@
 package bpf
 
 import (
+	_ "embed"
 	"encoding/binary"
 	"fmt"
 	"os"
@@ -11,13 +12,21 @@ import (
 	"github.com/aquasecurity/libbpfgo/helpers"
 )
 
-var userFuncCountBPF = "./userFuncCount.bpf.o"
-var userFuncExecTimeBPF = "./userFuncExecTime.bpf.o"
+//go:generate ./buildbpf.sh userFuncCount
+//go:generate ./buildbpf.sh userFuncExecTime
+
+// eBPF objects built by go generate, loaded wherever tests run
+//
+//go:embed userFuncCount.bpf.o
+var userFuncCountBPF []byte
+
+//go:embed userFuncExecTime.bpf.o
+var userFuncExecTimeBPF []byte
 var targetBinary = "./test/test"
 
 func testFuncCount(binaryPath, symbol string, dur time.Duration) {
 	fmt.Println("Start test the func count.")
-	module, err := bpf.NewModuleFromFile(userFuncCountBPF)
+	module, err := bpf.NewModuleFromBuffer(userFuncCountBPF, "userFuncCount")
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -98,7 +107,7 @@ func testFuncCount(binaryPath, symbol string, dur time.Duration) {
 
 func testFuncDuration(binaryPath, symbol string, dur time.Duration) {
 	fmt.Println("Start test the func count.")
-	module, err := bpf.NewModuleFromFile(userFuncExecTimeBPF)
+	module, err := bpf.NewModuleFromBuffer(userFuncExecTimeBPF, "userFuncExecTime")
 	if err != nil {
 		fmt.Println(err)
 		return
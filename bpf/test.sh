@@ -1,7 +1,6 @@
 #!/bin/bash
 
-./buildbpf.sh userFuncCount
-./buildbpf.sh userFuncExecTime
+go generate
 
 ./buildgo.sh bpf_test
 
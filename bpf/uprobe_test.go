@@ -24,7 +24,7 @@ func BenchmarkUprobe(b *testing.B) {
 	defer eb.Close()
 
 	// setup ebpf kprobe
-	module, err := bpf.NewModuleFromFile(userFuncCountBPF)
+	module, err := bpf.NewModuleFromBuffer(userFuncCountBPF, "userFuncCount")
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -109,7 +109,7 @@ func BenchmarkUprobeFuncDur(b *testing.B) {
 	eb := ebh.NewEBPFBenchmark(b)
 	defer eb.Close()
 
-	module, err := bpf.NewModuleFromFile(userFuncExecTimeBPF)
+	module, err := bpf.NewModuleFromBuffer(userFuncExecTimeBPF, "userFuncExecTime")
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"wanggj.com/abyss/newProcTracing"
+)
+
+const defaultChildrenDepth = 1
+
+// ChildrenConfig makes descendants of a monitored process monitored with the
+// same ProcConfig, labeled by "PPID". Fork follows children running the
+// image of their parents, such as workers, and Exec follows children running
+// another program. Depth is the max generations followed, default 1. Comm
+// are globs matched against comm of children, all children are followed if
+// it is empty.
+type ChildrenConfig struct {
+	Depth int      `yaml:"depth,omitempty"`
+	Fork  bool     `yaml:"fork"`
+	Exec  bool     `yaml:"exec"`
+	Comm  []string `yaml:"comm,omitempty"`
+}
+
+func (c *ChildrenConfig) check() error {
+	if !c.Fork && !c.Exec {
+		return fmt.Errorf("At least one of fork and exec of children must be true.")
+	}
+	if c.Depth < 0 {
+		return fmt.Errorf("Depth of children must not be negative, got %d.", c.Depth)
+	}
+	for _, pattern := range c.Comm {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Bad glob %q of children comm.", pattern)
+		}
+	}
+	return nil
+}
+
+func (c *ChildrenConfig) maxDepth() int {
+	if c.Depth == 0 {
+		return defaultChildrenDepth
+	}
+	return c.Depth
+}
+
+func (c *ChildrenConfig) matchComm(comm string) bool {
+	if len(c.Comm) == 0 {
+		return true
+	}
+	for _, pattern := range c.Comm {
+		if globMatch(pattern, comm) {
+			return true
+		}
+	}
+	return false
+}
+
+// trackedProc is a monitored process whose children may be followed, depth
+// is 0 for the process adopted by itself. filename, configpath and
+// hostConfig are copied from its MonitorProc, which belongs to DataGather
+// once it is sent.
+type trackedProc struct {
+	filename   string
+	configpath string
+	hostConfig bool
+	cfg        *ChildrenConfig
+	depth      int
+}
+
+// childTracker decides which children of monitored processes are followed,
+// roots are added by Follow after their ProcConfig is loaded, and events of
+// forks and execs are handled by gatherMonitorProc.
+type childTracker struct {
+	procs map[ProcKey]*trackedProc
+	// pids indexes keys of procs by pid, as fork and exec events have no
+	// start time
+	pids map[uint32]ProcKey
+	// followCh receives pids of roots, whose existing children are found
+	// by scanning procRoot
+	followCh chan uint32
+	mtx      sync.Mutex
+}
+
+func newChildTracker() *childTracker {
+	return &childTracker{
+		procs:    map[ProcKey]*trackedProc{},
+		pids:     map[uint32]ProcKey{},
+		followCh: make(chan uint32, 128),
+	}
+}
+
+// Children tracks descendants of all monitored processes
+var Children = newChildTracker()
+
+// lookup returns the followed process of pid. c.mtx must be held.
+func (c *childTracker) lookup(pid uint32) (*trackedProc, bool) {
+	key, ok := c.pids[pid]
+	if !ok {
+		return nil, false
+	}
+	p, ok := c.procs[key]
+	return p, ok
+}
+
+// add follows process key, a stale process of the same pid is replaced.
+// c.mtx must be held.
+func (c *childTracker) add(key ProcKey, p *trackedProc) {
+	if old, ok := c.pids[key.Pid]; ok {
+		delete(c.procs, old)
+	}
+	c.procs[key] = p
+	c.pids[key.Pid] = key
+}
+
+// remove stops following process key. c.mtx must be held.
+func (c *childTracker) remove(key ProcKey) {
+	if old, ok := c.pids[key.Pid]; ok && old.Same(key) {
+		delete(c.procs, old)
+		delete(c.pids, key.Pid)
+	}
+}
+
+// Follow starts following children of mp, which must be adopted already.
+// mp is not followed if it has exited or its pid is reused by another
+// process, since its exit may have been handled before it is followed.
+func (c *childTracker) Follow(mp *MonitorProc, cfg *ChildrenConfig) {
+	st, err := readStat(mp.Pid)
+	if err != nil || st.State == 'Z' || st.State == 'X' {
+		return
+	}
+	key := ProcKey{Pid: mp.Pid, StartTime: st.StartTime}
+	if !key.Same(mp.Key()) {
+		return
+	}
+	c.mtx.Lock()
+	if old, ok := c.pids[mp.Pid]; ok && old == key {
+		c.mtx.Unlock()
+		return
+	}
+	c.add(key, &trackedProc{
+		filename:   mp.Filename,
+		configpath: mp.Configpath,
+		hostConfig: mp.HostConfig,
+		cfg:        cfg,
+	})
+	c.mtx.Unlock()
+
+	select {
+	case c.followCh <- mp.Pid:
+	default:
+		// existing children are found by rescan
+	}
+}
+
+// follow starts following process key if its parent is followed, filename
+// is empty if the child runs the image of its parent. c.mtx must be held.
+func (c *childTracker) follow(key ProcKey, ppid uint32, comm, filename string) *MonitorProc {
+	parent, ok := c.lookup(ppid)
+	if !ok || parent.depth >= parent.cfg.maxDepth() || !parent.cfg.matchComm(comm) {
+		return nil
+	}
+	if filename == "" && !parent.cfg.Fork || filename != "" && !parent.cfg.Exec {
+		return nil
+	}
+	if filename == "" {
+		filename = parent.filename
+	}
+	c.add(key, &trackedProc{
+		filename:   filename,
+		configpath: parent.configpath,
+		hostConfig: parent.hostConfig,
+		cfg:        parent.cfg,
+		depth:      parent.depth + 1,
+	})
+	return &MonitorProc{
+		Pid:        key.Pid,
+		Ppid:       ppid,
+		StartTime:  key.StartTime,
+		Filename:   filename,
+		Configpath: parent.configpath,
+		Inherited:  true,
+		HostConfig: parent.hostConfig,
+	}
+}
+
+// fork returns MonitorProc of the child if its parent is followed
+func (c *childTracker) fork(msg *newProcTracing.ForkProcMsg) *MonitorProc {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.lookup(msg.Pid); ok {
+		return nil
+	}
+	return c.follow(readProcKey(msg.Pid), msg.Ppid, msg.Comm, "")
+}
+
+// exec handles execve of process in msg. A new child is returned if it is
+// followed at exec, stop is true if a followed child runs another program
+// not followed any more.
+func (c *childTracker) exec(msg *newProcTracing.NewProcMsg) (mp *MonitorProc, stop bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	comm := execComm(msg.Filename)
+	if child, ok := c.lookup(msg.Pid); ok {
+		if child.depth == 0 {
+			return nil, false
+		}
+		if !child.cfg.Exec || !child.cfg.matchComm(comm) {
+			c.remove(c.pids[msg.Pid])
+			return nil, true
+		}
+		child.filename = msg.Filename
+		return nil, false
+	}
+	return c.follow(readProcKey(msg.Pid), msg.Ppid, comm, msg.Filename), false
+}
+
+// exit stops following process key
+func (c *childTracker) exit(key ProcKey) {
+	c.mtx.Lock()
+	c.remove(key)
+	c.mtx.Unlock()
+}
+
+// procEntry is a process read from procRoot, exe is empty if it cannot be
+// read.
+type procEntry struct {
+	key  ProcKey
+	ppid uint32
+	comm string
+	exe  string
+}
+
+// readProcEntries reads all processes in procRoot
+func readProcEntries() ([]procEntry, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	procs := []procEntry{}
+	for _, e := range entries {
+		pid, err := strconv.ParseUint(e.Name(), 10, 32)
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		st, err := readStat(uint32(pid))
+		if err != nil {
+			continue
+		}
+		exe, _ := os.Readlink(filepath.Join(procRoot, e.Name(), "exe"))
+		procs = append(procs, procEntry{
+			key:  ProcKey{Pid: uint32(pid), StartTime: st.StartTime},
+			ppid: st.Ppid,
+			comm: st.Comm,
+			exe:  exe,
+		})
+	}
+	return procs, nil
+}
+
+// existing returns children of followed processes started before they are
+// followed, such as workers forked before the ProcConfig of master is
+// loaded. A child runs another program if its exe differs from its parent.
+func (c *childTracker) existing(procs []procEntry) []*MonitorProc {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	found := []*MonitorProc{}
+	// a generation is found in each round
+	for {
+		n := len(found)
+		for _, p := range procs {
+			parent, ok := c.lookup(p.ppid)
+			if _, followed := c.lookup(p.key.Pid); followed || !ok {
+				continue
+			}
+			filename := ""
+			if p.exe != "" && p.exe != parent.filename {
+				filename = p.exe
+			}
+			if mp := c.follow(p.key, p.ppid, p.comm, filename); mp != nil {
+				found = append(found, mp)
+			}
+		}
+		if len(found) == n {
+			return found
+		}
+	}
+}
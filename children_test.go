@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wanggj.com/abyss/newProcTracing"
+)
+
+func TestChildrenFork(t *testing.T) {
+	defer func(old string) { procRoot = old }(procRoot)
+	procRoot = t.TempDir()
+	writeProc(t, "10", "/usr/sbin/nginx\x00")
+
+	c := newChildTracker()
+	root := &MonitorProc{Pid: 10, Filename: "/usr/sbin/nginx", Configpath: "/etc/nginx.yaml", HostConfig: true}
+	c.Follow(root, &ChildrenConfig{Depth: 2, Fork: true, Comm: []string{"nginx*"}})
+	if pid := <-c.followCh; pid != 10 {
+		t.Fatalf("Expected root 10 to scan, got %d.", pid)
+	}
+
+	child := c.fork(&newProcTracing.ForkProcMsg{Pid: 11, Ppid: 10, Comm: "nginx"})
+	// the config path resolved for the root is inherited
+	expected := MonitorProc{Pid: 11, Ppid: 10, Filename: "/usr/sbin/nginx", Configpath: "/etc/nginx.yaml", Inherited: true, HostConfig: true}
+	if child == nil || *child != expected {
+		t.Fatalf("Expected child %+v, got %+v.", expected, child)
+	}
+	if c.fork(&newProcTracing.ForkProcMsg{Pid: 11, Ppid: 10, Comm: "nginx"}) != nil {
+		t.Error("Expected child followed only once.")
+	}
+	if c.fork(&newProcTracing.ForkProcMsg{Pid: 12, Ppid: 11, Comm: "nginx"}) == nil {
+		t.Error("Expected grandchild followed in depth 2.")
+	}
+	if c.fork(&newProcTracing.ForkProcMsg{Pid: 13, Ppid: 12, Comm: "nginx"}) != nil {
+		t.Error("Expected depth 3 not followed.")
+	}
+	if c.fork(&newProcTracing.ForkProcMsg{Pid: 14, Ppid: 10, Comm: "logger"}) != nil {
+		t.Error("Expected comm not matched.")
+	}
+	if c.fork(&newProcTracing.ForkProcMsg{Pid: 15, Ppid: 1, Comm: "nginx"}) != nil {
+		t.Error("Expected child of unknown parent not followed.")
+	}
+
+	// exec is not followed
+	if mp, stop := c.exec(&newProcTracing.NewProcMsg{Pid: 11, Ppid: 10, Filename: "/bin/sh"}); mp != nil || !stop {
+		t.Errorf("Expected child stopped at exec, got %+v and %v.", mp, stop)
+	}
+	if mp, stop := c.exec(&newProcTracing.NewProcMsg{Pid: 10, Ppid: 1, Filename: "/usr/sbin/nginx"}); mp != nil || stop {
+		t.Errorf("Expected root kept at exec, got %+v and %v.", mp, stop)
+	}
+	c.exit(ProcKey{Pid: 12})
+	if c.fork(&newProcTracing.ForkProcMsg{Pid: 16, Ppid: 12, Comm: "nginx"}) != nil {
+		t.Error("Expected child of exited process not followed.")
+	}
+}
+
+func TestChildrenExec(t *testing.T) {
+	defer func(old string) { procRoot = old }(procRoot)
+	procRoot = t.TempDir()
+	writeProc(t, "10", "/usr/bin/supervisor\x00")
+
+	c := newChildTracker()
+	root := &MonitorProc{Pid: 10, Filename: "/usr/bin/supervisor", Configpath: "/etc/s.yaml"}
+	c.Follow(root, &ChildrenConfig{Exec: true})
+
+	// forked children are not followed until they exec
+	if c.fork(&newProcTracing.ForkProcMsg{Pid: 11, Ppid: 10, Comm: "supervisor"}) != nil {
+		t.Error("Expected fork not followed.")
+	}
+	mp, stop := c.exec(&newProcTracing.NewProcMsg{Pid: 11, Ppid: 10, Filename: "/usr/bin/worker"})
+	if mp == nil || stop || mp.Filename != "/usr/bin/worker" || !mp.Inherited {
+		t.Fatalf("Expected child followed at exec, got %+v and %v.", mp, stop)
+	}
+	// the MonitorProc sent is not changed by later execs
+	if _, stop := c.exec(&newProcTracing.NewProcMsg{Pid: 11, Ppid: 10, Filename: "/usr/bin/worker2"}); stop || mp.Filename != "/usr/bin/worker" {
+		t.Errorf("Expected child kept and %+v unchanged at exec, got %v.", mp, stop)
+	}
+
+	// children started before root followed
+	procs := []procEntry{
+		{key: ProcKey{Pid: 20, StartTime: 2000}, ppid: 10, comm: "worker", exe: "/usr/bin/worker"},
+		{key: ProcKey{Pid: 21, StartTime: 2100}, ppid: 10, comm: "supervisor", exe: "/usr/bin/supervisor"},
+		{key: ProcKey{Pid: 22, StartTime: 2200}, ppid: 20, comm: "worker", exe: "/usr/bin/worker"},
+		{key: ProcKey{Pid: 23, StartTime: 2300}, ppid: 1, comm: "init", exe: "/sbin/init"},
+	}
+	found := c.existing(procs)
+	if len(found) != 1 || found[0].Key() != (ProcKey{Pid: 20, StartTime: 2000}) {
+		t.Errorf("Expected only 20 found, got %v.", found)
+	}
+}
+
+func TestChildrenFollowExited(t *testing.T) {
+	defer func(old string) { procRoot = old }(procRoot)
+	procRoot = t.TempDir()
+	writeProc(t, "11", "")
+	zombie := "11 (app) Z 1 11 11 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 1 0 1100 0 0"
+	if err := os.WriteFile(filepath.Join(procRoot, "11", "stat"), []byte(zombie), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newChildTracker()
+	cfg := &ChildrenConfig{Fork: true}
+	c.Follow(&MonitorProc{Pid: 10}, cfg)
+	c.Follow(&MonitorProc{Pid: 11}, cfg)
+	// pid 12 is reused by a process started at 1200
+	writeProc(t, "12", "")
+	c.Follow(&MonitorProc{Pid: 12, StartTime: 900}, cfg)
+	if len(c.procs) != 0 || len(c.followCh) != 0 {
+		t.Errorf("Expected exited roots not followed, got %v.", c.procs)
+	}
+
+	// the exit of a process of the same pid does not stop the root
+	c.Follow(&MonitorProc{Pid: 12, StartTime: 1200}, cfg)
+	c.exit(ProcKey{Pid: 12, StartTime: 900})
+	if _, ok := c.procs[ProcKey{Pid: 12, StartTime: 1200}]; !ok {
+		t.Fatalf("Expected root 12 followed, got %v.", c.procs)
+	}
+	c.exit(ProcKey{Pid: 12, StartTime: 1200})
+	if len(c.procs) != 0 || len(c.pids) != 0 {
+		t.Errorf("Expected root 12 removed, got %v.", c.procs)
+	}
+}
+
+func TestChildrenConfig(t *testing.T) {
+	bad := []ChildrenConfig{
+		{},
+		{Fork: true, Depth: -1},
+		{Fork: true, Comm: []string{"["}},
+	}
+	for idx := range bad {
+		if err := bad[idx].check(); err == nil {
+			t.Errorf("Expected error of %dth config.", idx)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+	"wanggj.com/abyss/module"
+)
+
+// ConstHistogram is a histogram with fixed buckets, it is generated by
+// analyzers on the fly just like ConstSummary.
+type ConstHistogram struct {
+	desc    *Desc
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+}
+
+func (ch *ConstHistogram) Desc() *Desc {
+	return ch.desc
+}
+
+func (ch *ConstHistogram) Write() (*module.Metric, error) {
+	his := &module.Histogram{}
+	bounds := make([]float64, 0, len(ch.buckets))
+	for b := range ch.buckets {
+		bounds = append(bounds, b)
+	}
+	sort.Float64s(bounds)
+
+	his.SampleCount = proto.Uint64(ch.count)
+	his.SampleSum = proto.Float64(ch.sum)
+
+	bs := make([]*module.Bucket, 0, len(bounds))
+	for _, b := range bounds {
+		bs = append(bs, &module.Bucket{
+			CumulativeCount: proto.Uint64(ch.buckets[b]),
+			UpperBound:      proto.Float64(b),
+		})
+	}
+	his.Bucket = bs
+
+	return &module.Metric{
+		Label:     ch.desc.constLabelPairs,
+		Priority:  proto.Uint32(ch.desc.priority),
+		Histogram: his,
+	}, nil
+}
+
+// NewConstHistogram returns a histogram metric, buckets maps upper bound of
+// each bucket to the cumulative count of values no bigger than the bound.
+func NewConstHistogram(
+	desc *Desc,
+	count uint64,
+	sum float64,
+	buckets map[float64]uint64,
+) *ConstHistogram {
+	if desc == nil {
+		return nil
+	}
+	return &ConstHistogram{
+		desc:    desc,
+		count:   count,
+		sum:     sum,
+		buckets: buckets,
+	}
+}
@@ -0,0 +1,62 @@
+package collector_test
+
+import (
+	"testing"
+
+	"wanggj.com/abyss/collector"
+)
+
+func TestConstHistogram(t *testing.T) {
+	desc := collector.NewDesc(
+		"ch",
+		"this is test for consthistogram",
+		collector.LevelError,
+		234,
+		nil,
+		collector.Labels{
+			"pid":  "aaaa",
+			"type": "histogram",
+		},
+	)
+	ch := collector.NewConstHistogram(
+		desc,
+		10,
+		55.5,
+		map[float64]uint64{
+			10: 8,
+			1:  2,
+			5:  6,
+		},
+	)
+	if ch == nil {
+		t.Fatal("Got nil when generate const histogram")
+	}
+	m, err := ch.Write()
+	if err != nil {
+		t.Fatalf("Got error when write, error:%s", err.Error())
+	}
+	if m.Histogram == nil {
+		t.Fatal("Want Histogram but Metric's Histogram is null.")
+	}
+	if m.Histogram.GetSampleCount() != 10 {
+		t.Errorf("Expected sample count 10, got %d.", m.Histogram.GetSampleCount())
+	}
+
+	expected := []float64{1, 5, 10}
+	if len(m.Histogram.Bucket) != len(expected) {
+		t.Fatalf("Expected %d buckets, got %d.", len(expected), len(m.Histogram.Bucket))
+	}
+	for i, b := range m.Histogram.Bucket {
+		if b.GetUpperBound() != expected[i] {
+			t.Errorf("Buckets not ordered, expected %g, got %g.", expected[i], b.GetUpperBound())
+		}
+	}
+	t.Logf("Const histogram write result: %s", m.String())
+}
+
+func TestConstNullDescHistogram(t *testing.T) {
+	ch := collector.NewConstHistogram(nil, 100, 12334, nil)
+	if ch != nil {
+		t.Fatalf("Expected nil, but got %v.", *ch)
+	}
+}
@@ -40,6 +40,14 @@ type Opts struct {
 	Priority    uint16      `yaml:"priority"`
 }
 
+// SetConstLabel sets a const label, ConstLabels is created if it is nil.
+func (o *Opts) SetConstLabel(name, value string) {
+	if o.ConstLabels == nil {
+		o.ConstLabels = Labels{}
+	}
+	o.ConstLabels[name] = value
+}
+
 type timeStampMetric struct {
 	Metric
 	t time.Time
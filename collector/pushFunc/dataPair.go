@@ -5,8 +5,15 @@ import "time"
 type DataPair struct {
 	Value     float64
 	Timestamp time.Time
+	// Key identifies where Value comes from, such as caller, syscall, file
+	// path or remote address. It is empty if the PushFunc carries no key.
+	Key string
 }
 
 func NewDataPair(v float64, t time.Time) *DataPair {
 	return &DataPair{Value: v, Timestamp: t}
 }
+
+func NewKeyedDataPair(key string, v float64, t time.Time) *DataPair {
+	return &DataPair{Value: v, Timestamp: t, Key: key}
+}
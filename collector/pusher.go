@@ -22,6 +22,18 @@ type StatefulAnalyzer interface {
 	Observe(*pushFunc.DataPair)
 }
 
+// PersistentAnalyzer is a StatefulAnalyzer whose state can be saved and
+// restored, so that its state survives restarts of abyss.
+type PersistentAnalyzer interface {
+	StatefulAnalyzer
+
+	// MarshalState encodes the current state
+	MarshalState() ([]byte, error)
+	// UnmarshalState replaces the current state by the one encoded by
+	// MarshalState, error is returned if it does not fit the analyzer.
+	UnmarshalState([]byte) error
+}
+
 // StatelessAnalyzer is used to analysis data in a time range
 type StatelessAnalyzer interface {
 	// The same as Describe function of Collector
@@ -65,6 +77,11 @@ type Pusher struct {
 	// Pusher only keep data inside timeRange before time.Now()
 	TimeRange time.Duration
 
+	// stages are analyzers fed by output of other analyzers, keyed by the
+	// upstream analyzer, hidden analyzers only feed their output into stages
+	stages map[interface{}][]*AnaStage
+	hidden map[interface{}]bool
+
 	// closed is used to prevent Collect continue after Pusher close
 	closed bool
 }
@@ -92,11 +109,94 @@ func NewPusher(
 		StatefulAna:  statefulAnas,
 		StatelessAna: statelessAnas,
 		TimeRange:    tr,
+		stages:       map[interface{}][]*AnaStage{},
+		hidden:       map[interface{}]bool{},
 		closed:       true,
 	}
 	return result
 }
 
+// AnaStage is an analyzer whose input is the output of another analyzer of
+// the same Pusher, one of Stateful and Stateless must be set. Only gauge and
+// counter values are fed into the stage, Stateful observes each of them, and
+// Stateless analyzes those inside TimeRange of the Pusher.
+//
+// Upstream output is computed once each time Pusher is collected, then the
+// stage is collected right after it is fed.
+type AnaStage struct {
+	Stateful  StatefulAnalyzer
+	Stateless StatelessAnalyzer
+
+	// input keeps data fed into Stateless
+	input []*pushFunc.DataPair
+}
+
+// analyzer returns the analyzer of stage
+func (s *AnaStage) analyzer() interface{} {
+	if s.Stateful != nil {
+		return s.Stateful
+	}
+	return s.Stateless
+}
+
+// hasAnalyzer returns true if a is an analyzer of the Pusher
+func (p *Pusher) hasAnalyzer(a interface{}) bool {
+	for _, sfa := range p.StatefulAna {
+		if interface{}(sfa) == a {
+			return true
+		}
+	}
+	for _, sla := range p.StatelessAna {
+		if interface{}(sla) == a {
+			return true
+		}
+	}
+	for _, stages := range p.stages {
+		for _, s := range stages {
+			if s.analyzer() == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AddStage feeds output of upstream into stage, upstream must be an analyzer
+// of the Pusher, either fed by raw data or by another stage.
+func (p *Pusher) AddStage(upstream interface{}, stage *AnaStage) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if (stage.Stateful == nil) == (stage.Stateless == nil) {
+		return fmt.Errorf("Exactly one of Stateful and Stateless of AnaStage must be set.")
+	}
+	if !p.hasAnalyzer(upstream) {
+		return fmt.Errorf("Upstream analyzer %v is not in the Pusher.", upstream)
+	}
+	if p.hasAnalyzer(stage.analyzer()) {
+		return fmt.Errorf("Analyzer %v has been in the Pusher.", stage.analyzer())
+	}
+	p.stages[upstream] = append(p.stages[upstream], stage)
+	return nil
+}
+
+// Tap adds a hidden StatefulAnalyzer observing raw data of the Pusher, such
+// as analyzers shared by several pushers. It must be called before Start.
+func (p *Pusher) Tap(a StatefulAnalyzer) {
+	p.mtx.Lock()
+	p.StatefulAna = append(p.StatefulAna, a)
+	p.hidden[a] = true
+	p.mtx.Unlock()
+}
+
+// Hide stops collecting output of analyzer a, which is still fed into its
+// stages.
+func (p *Pusher) Hide(a interface{}) {
+	p.mtx.Lock()
+	p.hidden[a] = true
+	p.mtx.Unlock()
+}
+
 func (p *Pusher) receive() {
 	for d := range p.receiver {
 		p.bufMtx.Lock()
@@ -149,6 +249,15 @@ func (p *Pusher) Describe(ch chan<- *Desc) {
 	for _, a := range p.StatelessAna {
 		a.Describe(ch)
 	}
+	for _, stages := range p.stages {
+		for _, s := range stages {
+			if s.Stateful != nil {
+				s.Stateful.Describe(ch)
+			} else {
+				s.Stateless.Describe(ch)
+			}
+		}
+	}
 	if p.selfCol {
 		ch <- p.Desc
 	}
@@ -184,7 +293,7 @@ func (p *Pusher) Collect(ch chan<- Metric) {
 	for _, a := range p.StatefulAna {
 		wg.Add(1)
 		go func(a StatefulAnalyzer) {
-			a.Collect(ch)
+			p.collectAna(a, a.Collect, ch)
 			wg.Done()
 		}(a)
 	}
@@ -208,7 +317,11 @@ func (p *Pusher) Collect(ch chan<- Metric) {
 	for _, a := range p.StatelessAna {
 		wg.Add(1)
 		go func(a StatelessAnalyzer) {
-			a.Analyze(p.Data, ch)
+			p.collectAna(
+				a,
+				func(ch chan<- Metric) { a.Analyze(p.Data, ch) },
+				ch,
+			)
 			//fmt.Println("=============Analyze end========")
 			wg.Done()
 		}(a)
@@ -224,6 +337,97 @@ func (p *Pusher) Collect(ch chan<- Metric) {
 	return
 }
 
+// collectAna runs collect of analyzer a, its output is sent into ch unless a
+// is hidden, and fed into stages of a. p.mtx must be held.
+func (p *Pusher) collectAna(a interface{}, collect func(chan<- Metric), ch chan<- Metric) {
+	stages, hidden := p.stages[a], p.hidden[a]
+	if len(stages) == 0 && !hidden {
+		collect(ch)
+		return
+	}
+
+	out := make(chan Metric, dataReceiverLen)
+	go func() {
+		collect(out)
+		close(out)
+	}()
+	data := []*pushFunc.DataPair{}
+	for m := range out {
+		if !hidden {
+			ch <- m
+		}
+		if dp := metricToDataPair(m); dp != nil {
+			data = append(data, dp)
+		}
+	}
+
+	for _, s := range stages {
+		p.feedStage(s, data, ch)
+	}
+}
+
+// feedStage feeds data into stage s and collects it. p.mtx must be held.
+func (p *Pusher) feedStage(s *AnaStage, data []*pushFunc.DataPair, ch chan<- Metric) {
+	if s.Stateful != nil {
+		for _, d := range data {
+			s.Stateful.Observe(d)
+		}
+		p.collectAna(s.Stateful, s.Stateful.Collect, ch)
+		return
+	}
+
+	timeUpBound := time.Now().Add(-p.TimeRange)
+	ot := -1
+	for i, d := range s.input {
+		if d.Timestamp.Before(timeUpBound) {
+			ot = i
+		}
+	}
+	s.input = append(s.input[ot+1:], data...)
+	p.collectAna(
+		s.Stateless,
+		func(ch chan<- Metric) { s.Stateless.Analyze(s.input, ch) },
+		ch,
+	)
+}
+
+// metricToDataPair converts value of gauge or counter into DataPair, nil is
+// returned for other metrics.
+func metricToDataPair(m Metric) *pushFunc.DataPair {
+	md, err := m.Write()
+	if err != nil {
+		glog.Error(err)
+		return nil
+	}
+	var value float64
+	switch {
+	case md.Gauge != nil:
+		value = md.Gauge.GetValue()
+	case md.Counter != nil:
+		value = md.Counter.GetValue()
+	default:
+		return nil
+	}
+	tp := time.Now()
+	if md.Timestamp != nil {
+		tp = md.Timestamp.AsTime()
+	}
+	return pushFunc.NewDataPair(value, tp)
+}
+
+// Snapshot returns a copy of data kept by the Pusher, including data received
+// after last Collect, ordered by time.
+func (p *Pusher) Snapshot() []*pushFunc.DataPair {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.bufMtx.Lock()
+	defer p.bufMtx.Unlock()
+
+	result := make([]*pushFunc.DataPair, 0, len(p.Data)+len(p.dataBuf))
+	result = append(result, p.Data...)
+	return append(result, p.dataBuf...)
+}
+
 // /////////////////////////////////
 // Pusher options, used for Pusher initialization
 type PusherOpts struct {
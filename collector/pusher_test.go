@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -212,6 +213,81 @@ func TestPusherOOT(t *testing.T) {
 	})
 }
 
+// sumAna is a stateful analyzer sending sum of data observed
+type sumAna struct {
+	desc *collector.Desc
+	sum  float64
+	mtx  sync.Mutex
+}
+
+func (sa *sumAna) Describe(ch chan<- *collector.Desc) {
+	ch <- sa.desc
+}
+
+func (sa *sumAna) Observe(dp *pushFunc.DataPair) {
+	sa.mtx.Lock()
+	sa.sum += dp.Value
+	sa.mtx.Unlock()
+}
+
+func (sa *sumAna) Collect(ch chan<- collector.Metric) {
+	sa.mtx.Lock()
+	defer sa.mtx.Unlock()
+	cm, err := collector.NewConstMetric(sa.desc, collector.GaugeValue, sa.sum)
+	if err == nil {
+		ch <- cm
+	}
+}
+
+func TestPusherPipeline(t *testing.T) {
+	desc := collector.NewDesc("test", "test", collector.LevelInfo, 234, nil, nil)
+	tna := NewTestAna()
+	sum := &sumAna{
+		desc: collector.NewDesc("sum", "sum", collector.LevelInfo, 234, nil, nil),
+	}
+	tp := collector.NewPusher(
+		desc,
+		false,
+		collector.GaugeValue,
+		&testCollectFunc{},
+		nil,
+		[]collector.StatelessAnalyzer{tna},
+		time.Minute,
+	)
+	if err := tp.AddStage(tna, &collector.AnaStage{Stateful: sum}); err != nil {
+		t.Fatal(err)
+	}
+	tp.Hide(tna)
+	if err := tp.AddStage(NewTestAna(), &collector.AnaStage{Stateful: &sumAna{}}); err == nil {
+		t.Error("Expected error of unknown upstream.")
+	}
+	if err := tp.AddStage(tna, &collector.AnaStage{Stateful: sum}); err == nil {
+		t.Error("Expected error of duplicated analyzer.")
+	}
+
+	tp.Start()
+	defer tp.Stop()
+	time.Sleep(time.Second)
+
+	ch := make(chan collector.Metric, 10)
+	go func() {
+		tp.Collect(ch)
+		close(ch)
+	}()
+	result := []*module.Metric{}
+	for m := range ch {
+		if m.Desc() == tna.desc {
+			t.Error("Output of hidden analyzer is collected.")
+		}
+		pm, _ := m.Write()
+		result = append(result, pm)
+	}
+	// testAna sends each data 1, 2, 3..., which are summed by sumAna
+	if len(result) != 1 || result[0].Gauge.GetValue() < 1 {
+		t.Errorf("Expected sum of testAna output, got %v.", result)
+	}
+}
+
 var pusherYaml = []string{
 	`
 desc:
@@ -1 +1,132 @@
 package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	analyzer "wanggj.com/abyss/analyzers"
+	"wanggj.com/abyss/newProcTracing"
+	"wanggj.com/abyss/notifier"
+	"wanggj.com/abyss/state"
+)
+
+const defaultStateInterval = time.Minute
+
+var agentConfigPath = flag.String("config", "", "path of abyss agent config file")
+
+// AgentConfig is the config of abyss itself, it is loaded from the file
+// given by flag -config.
+type AgentConfig struct {
+	// Notifiers are sinks that Alerts of all processes are sent to
+	Notifiers []notifier.SinkConfig `yaml:"notifiers,omitempty"`
+	// StateDir is the directory where states of persistent analyzers are
+	// saved, states are not saved if it is empty
+	StateDir string `yaml:"stateDir,omitempty"`
+	// StateInterval is the interval of saving states, default 1m
+	StateInterval time.Duration `yaml:"stateInterval,omitempty"`
+	// RescanInterval is the interval of scanning /proc for processes want
+	// to be monitored, /proc is only scanned at startup if it is 0
+	RescanInterval time.Duration `yaml:"rescanInterval,omitempty"`
+	// DiscoveryRules is the path of rule file selecting processes to monitor
+	// without bpfMonitorFlag in their command lines, see DiscoveryRule
+	DiscoveryRules string `yaml:"discoveryRules,omitempty"`
+	// EventSource is the source of process events, "ebpf" (default),
+	// "netlink", which listens to the process connector with CAP_NET_ADMIN
+	// where eBPF is forbidden, or "proc", which polls /proc every
+	// PollInterval without privilege
+	EventSource  string        `yaml:"eventSource,omitempty"`
+	PollInterval time.Duration `yaml:"pollInterval,omitempty"`
+	// BpfObject loads the eBPF object of "ebpf" from file instead of the
+	// one embedded into abyss, for development
+	BpfObject string `yaml:"bpfObject,omitempty"`
+	// BpfBTF is the BTF file of the kernel used for CO-RE relocation, only
+	// needed if the kernel has no /sys/kernel/btf/vmlinux
+	BpfBTF string `yaml:"bpfBTF,omitempty"`
+	// Metadata selects labels of process metadata added into all metrics,
+	// see MetadataConfig
+	Metadata *MetadataConfig `yaml:"metadata,omitempty"`
+}
+
+// LoadAgentConfig reads AgentConfig from path, empty config is returned if
+// path is empty.
+func LoadAgentConfig(path string) (*AgentConfig, error) {
+	cfg := &AgentConfig{}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// setupNotifier generates Notifier from config and set it as the Notifier
+// of all Alerts, nil is returned if no sink configured.
+func setupNotifier(cfg *AgentConfig) (*notifier.Notifier, error) {
+	if len(cfg.Notifiers) == 0 {
+		return nil, nil
+	}
+	n, err := notifier.NewNotifier(cfg.Notifiers)
+	if err != nil {
+		return nil, err
+	}
+	analyzer.SetNotifier(n)
+	return n, nil
+}
+
+// StateStore saves states of persistent analyzers, nil if not configured
+var StateStore *state.Store
+
+// stateInterval is the interval of saving states into StateStore
+var stateInterval = defaultStateInterval
+
+// setupStateStore generates StateStore from config, nothing is done if no
+// state directory configured.
+func setupStateStore(cfg *AgentConfig) error {
+	if cfg.StateDir == "" {
+		return nil
+	}
+	store, err := state.NewStore(cfg.StateDir)
+	if err != nil {
+		return err
+	}
+	if cfg.StateInterval > 0 {
+		stateInterval = cfg.StateInterval
+	}
+	StateStore = store
+	return nil
+}
+
+// setupDiscovery loads discoveryRules from config, nothing is done if no
+// rule file configured.
+func setupDiscovery(cfg *AgentConfig) error {
+	if cfg.DiscoveryRules == "" {
+		return nil
+	}
+	rules, err := LoadDiscoveryRules(cfg.DiscoveryRules)
+	if err != nil {
+		return err
+	}
+	discoveryRules = rules
+	return nil
+}
+
+// setupEventSource selects eventSource by config
+func setupEventSource(cfg *AgentConfig) error {
+	source, err := newProcTracing.NewEventSource(
+		cfg.EventSource,
+		&newProcTracing.PollOpts{Interval: cfg.PollInterval},
+		&newProcTracing.BpfOpts{ObjPath: cfg.BpfObject, BTFPath: cfg.BpfBTF},
+	)
+	if err != nil {
+		return err
+	}
+	eventSource = source
+	return nil
+}
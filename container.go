@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/procfs"
+)
+
+var (
+	// last segment of cgroup path of a container, such as
+	// "docker-<id>.scope", "cri-containerd-<id>.scope" or "<id>"
+	containerIdRe = regexp.MustCompile(`^(?:([a-z-]+)-)?([0-9a-f]{64})(?:\.scope)?$`)
+	// pod segment of kubepods cgroup, systemd driver replaces "-" in uid
+	// with "_"
+	podUidRe = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+
+	// runtimes by prefix of container id, or by parent segment with
+	// cgroupfs driver
+	containerRuntimes = map[string]string{
+		"docker":         "docker",
+		"cri-containerd": "containerd",
+		"containerd":     "containerd",
+		"crio":           "cri-o",
+		"libpod":         "podman",
+	}
+)
+
+// ContainerInfo is the container a process runs in, PodUID is empty if it
+// is not run by kubernetes.
+type ContainerInfo struct {
+	ID      string
+	Runtime string
+	PodUID  string
+	Cgroup  string
+	// NsPid is the pid in the pid namespace of the process, which is the
+	// pid seen inside the container
+	NsPid uint32
+}
+
+// Labels returns labels added into all metrics of the process
+func (c *ContainerInfo) Labels() collector.Labels {
+	labels := collector.Labels{
+		"container_id": c.ID,
+		"cgroup":       c.Cgroup,
+	}
+	if c.Runtime != "" {
+		labels["container_runtime"] = c.Runtime
+	}
+	if c.PodUID != "" {
+		labels["pod_uid"] = c.PodUID
+	}
+	if c.NsPid != 0 {
+		labels["container_pid"] = fmt.Sprint(c.NsPid)
+	}
+	return labels
+}
+
+// parseContainerCgroup finds container in cgroup path, nil is returned if
+// the path is not of a container.
+func parseContainerCgroup(path string) *ContainerInfo {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for idx := len(segments) - 1; idx >= 0; idx-- {
+		match := containerIdRe.FindStringSubmatch(segments[idx])
+		if match == nil {
+			continue
+		}
+		info := &ContainerInfo{ID: match[2], Cgroup: path}
+		if match[1] != "" {
+			info.Runtime = containerRuntimes[match[1]]
+		} else if idx > 0 {
+			info.Runtime = containerRuntimes[segments[idx-1]]
+		}
+		for _, s := range segments[:idx] {
+			if m := podUidRe.FindStringSubmatch(s); m != nil {
+				info.PodUID = strings.ReplaceAll(m[1], "_", "-")
+			}
+		}
+		return info
+	}
+	return nil
+}
+
+// readNsPid returns the pid in the innermost pid namespace from NSpid of
+// /proc/[pid]/status, 0 is returned if the process is in the pid namespace
+// of abyss or the kernel has no NSpid.
+func readNsPid(dir string) (uint32, error) {
+	f, err := os.Open(filepath.Join(dir, "status"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || name != "NSpid" {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) < 2 {
+			return 0, nil
+		}
+		pid, err := strconv.ParseUint(fields[len(fields)-1], 10, 32)
+		return uint32(pid), err
+	}
+	return 0, scanner.Err()
+}
+
+// readContainerInfo returns the container of process pid, nil is returned
+// if it does not run in a container.
+func readContainerInfo(pid uint32) (*ContainerInfo, error) {
+	dir := filepath.Join(procRoot, fmt.Sprint(pid))
+	cgroups, err := readCgroups(dir)
+	if err != nil {
+		return nil, err
+	}
+	var info *ContainerInfo
+	for _, path := range cgroups {
+		if info = parseContainerCgroup(path); info != nil {
+			break
+		}
+	}
+	if info == nil {
+		return nil, nil
+	}
+	if info.NsPid, err = readNsPid(dir); err != nil {
+		return nil, fmt.Errorf("Parse status of process %d error: %s.", pid, err.Error())
+	}
+	return info, nil
+}
+
+// procConfigPath returns path of config file of mp readable by abyss.
+// Paths given by argv or env are in the mount namespace of the process, so
+// they are read through /proc/[pid]/root if the process is in another mount
+// namespace, relative ones are joined to its cwd first. Symlinks are
+// resolved inside the root of the process, so a container cannot make
+// abyss read files of the host, though one replaced after resolution is
+// not guarded. Processes in the mount namespace of abyss are trusted to
+// name any file as config. The path is kept if the namespace of abyss
+// cannot be read.
+func procConfigPath(mp *MonitorProc) (string, error) {
+	if mp.HostConfig {
+		return mp.Configpath, nil
+	}
+	dir := filepath.Join(procRoot, fmt.Sprint(mp.Pid))
+	mnt, err := os.Readlink(filepath.Join(dir, "ns", "mnt"))
+	if err != nil {
+		return "", err
+	}
+	self, err := os.Readlink(filepath.Join(procRoot, "self", "ns", "mnt"))
+	if err != nil || self == mnt {
+		return mp.Configpath, nil
+	}
+	path := mp.Configpath
+	if !filepath.IsAbs(path) {
+		// cwd is shown as the path in the mount namespace of the process
+		cwd, err := os.Readlink(filepath.Join(dir, "cwd"))
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(cwd, path)
+	}
+	root := filepath.Join(dir, "root")
+	if path, err = procfs.ResolveInRoot(root, path); err != nil {
+		return "", err
+	}
+	return filepath.Join(root, path), nil
+}
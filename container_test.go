@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testContainerId = "3f2a1b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708"
+
+func TestParseContainerCgroup(t *testing.T) {
+	cases := []struct {
+		path     string
+		expected *ContainerInfo
+	}{
+		{"/system.slice/sshd.service", nil},
+		{"/user.slice/user-1000.slice/session-2.scope", nil},
+		{
+			"/system.slice/docker-" + testContainerId + ".scope",
+			&ContainerInfo{ID: testContainerId, Runtime: "docker"},
+		},
+		{
+			"/docker/" + testContainerId,
+			&ContainerInfo{ID: testContainerId, Runtime: "docker"},
+		},
+		{
+			"/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod0b1c2d3e_4f50_6172_8394_a5b6c7d8e9f0.slice/cri-containerd-" + testContainerId + ".scope",
+			&ContainerInfo{ID: testContainerId, Runtime: "containerd", PodUID: "0b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0"},
+		},
+		{
+			"/kubepods/burstable/pod0b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0/" + testContainerId,
+			&ContainerInfo{ID: testContainerId, PodUID: "0b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0"},
+		},
+	}
+	for _, c := range cases {
+		info := parseContainerCgroup(c.path)
+		if c.expected == nil {
+			if info != nil {
+				t.Errorf("Expected no container in %s, got %+v.", c.path, info)
+			}
+			continue
+		}
+		c.expected.Cgroup = c.path
+		if info == nil || *info != *c.expected {
+			t.Errorf("Expected %+v in %s, got %+v.", c.expected, c.path, info)
+		}
+	}
+}
+
+func TestReadContainerInfo(t *testing.T) {
+	defer func(old string) { procRoot = old }(procRoot)
+	procRoot = t.TempDir()
+
+	writeProc(t, "30", "/bin/app\x00")
+	writeProc(t, "31", "/bin/app\x00")
+	files := map[string]string{
+		"30/cgroup": "0::/system.slice/docker-" + testContainerId + ".scope\n",
+		"30/status": "Name:\tapp\nNSpid:\t30\t7\n",
+		"31/cgroup": "0::/system.slice/sshd.service\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(procRoot, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := readContainerInfo(30)
+	if err != nil || info == nil || info.ID != testContainerId || info.NsPid != 7 {
+		t.Fatalf("Expected container of process 30, got %+v and %v.", info, err)
+	}
+	labels := info.Labels()
+	if labels["container_id"] != testContainerId || labels["container_runtime"] != "docker" ||
+		labels["container_pid"] != "7" {
+		t.Errorf("Unexpected labels %v.", labels)
+	}
+	if info, err := readContainerInfo(31); info != nil || err != nil {
+		t.Errorf("Expected no container of process 31, got %+v and %v.", info, err)
+	}
+}
+
+func TestProcConfigPath(t *testing.T) {
+	defer func(old string) { procRoot = old }(procRoot)
+	procRoot = t.TempDir()
+
+	links := map[string]string{
+		"self": "mnt:[1]",
+		"40":   "mnt:[1]",
+		"41":   "mnt:[2]",
+	}
+	for pid, ns := range links {
+		dir := filepath.Join(procRoot, pid, "ns")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(ns, filepath.Join(dir, "mnt")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// symlinks of the container never point out of its root
+	root := filepath.Join(procRoot, "41", "root")
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc/shadow", filepath.Join(root, "etc", "app.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../../../../etc", filepath.Join(root, "conf")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/srv", filepath.Join(procRoot, "41", "cwd")); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		mp       MonitorProc
+		expected string
+	}{
+		{MonitorProc{Pid: 40, Configpath: "/etc/app.yaml"}, "/etc/app.yaml"},
+		{MonitorProc{Pid: 41, Configpath: "/etc/app.yaml"}, filepath.Join(root, "etc", "shadow")},
+		{MonitorProc{Pid: 41, Configpath: "/conf/passwd"}, filepath.Join(root, "etc", "passwd")},
+		{MonitorProc{Pid: 41, Configpath: "conf/app.yaml"}, filepath.Join(root, "srv", "conf", "app.yaml")},
+		{MonitorProc{Pid: 41, Configpath: "/etc/app.yaml", HostConfig: true}, "/etc/app.yaml"},
+	}
+	for _, c := range cases {
+		if path, err := procConfigPath(&c.mp); err != nil || path != c.expected {
+			t.Errorf("Expected %s of %+v, got %s and %v.", c.expected, c.mp, path, err)
+		}
+	}
+	// namespace of exited process cannot be read
+	if path, err := procConfigPath(&MonitorProc{Pid: 42, Configpath: "/etc/app.yaml"}); err == nil {
+		t.Errorf("Expected error of exited process, got %s.", path)
+	}
+}
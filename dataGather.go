@@ -12,14 +12,28 @@ import (
 
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
+	analyzer "wanggj.com/abyss/analyzers"
 	"wanggj.com/abyss/collector"
 	"wanggj.com/abyss/module"
+	"wanggj.com/abyss/state"
 )
 
 // dataGather is used to generate and destory registry and collectors and from
 // collectors and write into bytes
 
-var TargetProc map[uint32]*ProcRegistry = map[uint32]*ProcRegistry{}
+// TargetProc keeps registries of monitored processes, at most one process
+// of each pid
+var TargetProc map[ProcKey]*ProcRegistry = map[ProcKey]*ProcRegistry{}
+
+// reconcileInterval is the interval of removing processes in TargetProc
+// whose exits are lost
+var reconcileInterval = time.Minute
+
+// Groups keeps group analyzers shared by processes in TargetProc
+var Groups = analyzer.NewGroupRegistry()
+
+// Exits keeps exit events of processes in TargetProc until gathered
+var Exits = NewExitEvents()
 
 // NewProcErr is used for errors when
 type NewProcErr struct {
@@ -51,6 +65,9 @@ func DataGather(logger *log.Logger, gatherInv time.Duration) error {
 		errorCh   chan error
 		dataCh    chan map[int][]*module.MetricFamily
 		ticker    time.Ticker
+		// stateCh is nil if states are not saved
+		stateCh <-chan time.Time
+		done    chan struct{}
 	)
 	ctx, cancel = context.WithCancel(context.Background())
 	defer cancel()
@@ -61,6 +78,14 @@ func DataGather(logger *log.Logger, gatherInv time.Duration) error {
 	errorCh = make(chan error, 10)
 	dataCh = make(chan map[int][]*module.MetricFamily, 10)
 	ticker = *time.NewTicker(gatherInv)
+	if StateStore != nil {
+		stateTicker := time.NewTicker(stateInterval)
+		defer stateTicker.Stop()
+		stateCh = stateTicker.C
+	}
+	done = make(chan struct{})
+	reconcileTicker := time.NewTicker(reconcileInterval)
+	defer reconcileTicker.Stop()
 
 	go func() {
 		if err := gatherMonitorProc(ctx, newProcCh, exitCh); err != nil {
@@ -72,6 +97,28 @@ func DataGather(logger *log.Logger, gatherInv time.Duration) error {
 			select {
 			case n := <-newProcCh:
 				// TODO: creat new registry and add it into ProcRegistry
+				if key, reg, ok := findTarget(n.Pid); ok {
+					if key.Same(n.Key()) {
+						logger.Println(NewProcError(n, fmt.Errorf("Duplicated process %d.", n.Pid)))
+						continue
+					}
+					// the pid is reused and the exit of the old process is lost
+					removeTarget(logger, key, reg, nil)
+				}
+				container, err := readContainerInfo(n.Pid)
+				if err != nil {
+					logger.Println(NewProcError(n, err))
+				}
+				// received messages are never mutated, the path is resolved
+				// into a copy, which children inherit by Follow
+				path, err := procConfigPath(n)
+				if err != nil {
+					logger.Println(NewProcError(n, err))
+					continue
+				}
+				resolved := *n
+				resolved.Configpath, resolved.HostConfig = path, true
+				n = &resolved
 				cfg, err := ioutil.ReadFile(n.Configpath)
 				if err != nil {
 					logger.Println(NewProcError(n, err))
@@ -79,46 +126,87 @@ func DataGather(logger *log.Logger, gatherInv time.Duration) error {
 				}
 				//fmt.Println(cfg)
 				proccfg := new(ProcConfig)
-				err = yaml.Unmarshal(cfg, &proccfg)
+				err = yaml.UnmarshalStrict(cfg, &proccfg)
 				if err != nil {
 					fmt.Println(err)
 					logger.Println(NewProcError(n, err))
 					continue
 				}
 				//fmt.Println(proccfg)
-				reg, err := NewProcRegFromConfig(n.Pid, proccfg)
+				meta, err := readMetadataLabels(n.Pid, metadataConfig)
+				if err != nil {
+					logger.Println(NewProcError(n, err))
+				}
+				reg, err := NewProcRegWithLabels(n.Pid, proccfg, meta)
 				errs := err.(collector.MultiError)
 				if len(errs) > 0 {
 					logger.Println(NewProcError(n, err))
 					continue
 				}
 
-				if _, ok := TargetProc[n.Pid]; ok {
-					err = fmt.Errorf("Duplicated Pid: %d.", n.Pid)
-					logger.Fatal(NewProcError(n, err))
-					continue
-				}
-
-				TargetProc[n.Pid] = reg
+				TargetProc[n.Key()] = reg
 				//fmt.Println(reg)
 
+				if StateStore != nil {
+					restoreState(logger, n, reg)
+				}
+				if len(proccfg.Group) > 0 {
+					err := reg.JoinGroups(Groups, n.Key(), n.Filename, n.Configpath, proccfg.Group)
+					if err != nil {
+						logger.Println(NewProcError(n, err))
+					}
+				}
+				labels := collector.Labels{}
+				// PID is labeled by pushers already, start_time tells
+				// apart processes of the same pid
+				if start, ok := n.Key().Labels()["start_time"]; ok {
+					labels["start_time"] = start
+				}
+				if n.Inherited {
+					labels["PPID"] = fmt.Sprint(n.Ppid)
+				}
+				if container != nil {
+					for k, v := range container.Labels() {
+						labels[k] = v
+					}
+				}
+				if len(labels) > 0 {
+					reg.ConstLabels = labels
+				}
 				reg.Start()
+				if proccfg.Children != nil && !n.Inherited {
+					Children.Follow(n, proccfg.Children)
+				}
 			case e := <-exitCh:
-				if reg, ok := TargetProc[e.Pid]; ok {
-					// TODO: destory registry
-					reg.Stop()
-					delete(TargetProc, e.Pid)
+				// exits of processes replaced by ones of the same pid are
+				// ignored
+				if key, reg, ok := findTarget(e.Pid); ok && key.Same(exitKey(e)) {
+					removeTarget(logger, key, reg, e)
 				}
+			case <-reconcileTicker.C:
+				reconcileTargets(logger)
+			case <-stateCh:
+				saveState(logger)
 			case <-ctx.Done():
 				for _, reg := range TargetProc {
 					reg.Stop()
 				}
+				if StateStore != nil {
+					saveState(logger)
+				}
 				close(dataCh)
 				close(errorCh)
+				close(done)
 				return
 			case <-ticker.C:
 				data := map[int][]*module.MetricFamily{}
+				regs := []interface {
+					Gather() (map[int][]*module.MetricFamily, error)
+				}{Groups, Exits}
 				for _, reg := range TargetProc {
+					regs = append(regs, reg)
+				}
+				for _, reg := range regs {
 					mfs, err := reg.Gather()
 					if err != nil {
 						errs := err.(collector.MultiError)
@@ -145,8 +233,82 @@ func DataGather(logger *log.Logger, gatherInv time.Duration) error {
 	signal.Notify(stopper, os.Interrupt, syscall.SIGTERM)
 	select {
 	case err := <-errorCh:
+		cancel()
+		<-done
 		return err
 	case <-stopper:
+		// wait for states being saved
+		cancel()
+		<-done
 		return nil
 	}
 }
+
+// findTarget returns the process of pid in TargetProc
+func findTarget(pid uint32) (ProcKey, *ProcRegistry, bool) {
+	for key, reg := range TargetProc {
+		if key.Pid == pid {
+			return key, reg, true
+		}
+	}
+	return ProcKey{}, nil, false
+}
+
+// removeTarget stops and removes the exited process key, exit is nil if
+// the exit is lost.
+func removeTarget(logger *log.Logger, key ProcKey, reg *ProcRegistry, exit *ExitProc) {
+	// TODO: destory registry
+	reg.Stop()
+	delete(TargetProc, key)
+	Groups.Leave(key)
+	if exit != nil {
+		Exits.Add(exit, reg.exitLabels())
+	}
+	// states of an exited process are useless
+	if StateStore != nil && reg.Identity != nil {
+		if err := StateStore.Remove(reg.Identity); err != nil {
+			logger.Println(err)
+		}
+	}
+}
+
+// reconcileTargets removes processes in TargetProc which are not in
+// procRoot any more or whose pids are reused, their exits are lost.
+// Processes of unknown start time are removed only if their pids are gone.
+func reconcileTargets(logger *log.Logger) {
+	for key, reg := range TargetProc {
+		st, err := readStat(key.Pid)
+		if err != nil && !os.IsNotExist(err) {
+			logger.Println(err)
+			continue
+		}
+		if err == nil && key.Same(ProcKey{Pid: key.Pid, StartTime: st.StartTime}) {
+			continue
+		}
+		logger.Println(fmt.Errorf("Exit of process %d is lost, remove it.", key.Pid))
+		removeTarget(logger, key, reg, nil)
+	}
+}
+
+// restoreState restores states of reg from StateStore, the identity of
+// process is kept in reg so that states can be saved later.
+func restoreState(logger *log.Logger, np *MonitorProc, reg *ProcRegistry) {
+	id, err := state.GetProcIdentity(np.Pid)
+	if err != nil {
+		logger.Println(NewProcError(np, err))
+		return
+	}
+	reg.Identity = id
+	if err := reg.RestoreState(StateStore); err != nil {
+		logger.Println(NewProcError(np, err))
+	}
+}
+
+// saveState saves states of all processes into StateStore
+func saveState(logger *log.Logger) {
+	for key, reg := range TargetProc {
+		if err := reg.SaveState(StateStore); err != nil {
+			logger.Println(fmt.Errorf("Save state of process %d error: %s.", key.Pid, err.Error()))
+		}
+	}
+}
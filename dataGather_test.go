@@ -5,6 +5,8 @@ import (
 	"os"
 	"testing"
 	"time"
+
+	"wanggj.com/abyss/collector"
 )
 
 func TestDataGather(t *testing.T) {
@@ -14,3 +16,70 @@ func TestDataGather(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestRemoveTargetExitLabels(t *testing.T) {
+	defer func(old *ExitEvents) { Exits = old }(Exits)
+	Exits = NewExitEvents()
+	defer func(old map[ProcKey]*ProcRegistry) { TargetProc = old }(TargetProc)
+	TargetProc = map[ProcKey]*ProcRegistry{}
+
+	key := ProcKey{Pid: 10, StartTime: 1000}
+	reg, err := NewProcRegWithLabels(key.Pid, &ProcConfig{}, collector.Labels{"exe_name": "app"})
+	if err != nil && len(err.(collector.MultiError)) > 0 {
+		t.Fatal(err)
+	}
+	reg.ConstLabels = collector.Labels{"start_time": "1000", "PPID": "1"}
+	TargetProc[key] = reg
+
+	logger := log.New(os.Stdout, "[Test] ", log.Ltime|log.Lshortfile)
+	removeTarget(logger, key, reg, &ExitProc{Pid: 10, Ppid: 1, StartTime: 10 * time.Second, Lifetime: time.Second})
+	if len(TargetProc) != 0 {
+		t.Errorf("Expected process removed, got %v.", TargetProc)
+	}
+	metrics := gatherExits(t, Exits)
+	if len(metrics) != 1 {
+		t.Fatalf("Expected 1 exit event, got %d.", len(metrics))
+	}
+	labels := map[string]string{}
+	for _, l := range metrics[0].Label {
+		labels[l.GetName()] = l.GetValue()
+	}
+	if labels["exe_name"] != "app" || labels["PPID"] != "1" || labels["start_time"] != "1000" {
+		t.Errorf("Expected registry labels in exit event, got %v.", labels)
+	}
+}
+
+func TestReconcileTargets(t *testing.T) {
+	defer func(old string) { procRoot = old }(procRoot)
+	procRoot = t.TempDir()
+	defer func(old map[ProcKey]*ProcRegistry) { TargetProc = old }(TargetProc)
+	TargetProc = map[ProcKey]*ProcRegistry{}
+
+	// 10 is alive, 11 has exited, 12 is reused by a new process and 13
+	// of unknown start time is alive
+	writeProc(t, "10", "/bin/app\x00")
+	writeProc(t, "12", "/bin/app\x00")
+	writeProc(t, "13", "/bin/app\x00")
+	keys := []ProcKey{{Pid: 10, StartTime: 1000}, {Pid: 11, StartTime: 1100}, {Pid: 12, StartTime: 1}, {Pid: 13, StartTime: 0}}
+	for _, key := range keys {
+		reg, err := NewProcRegFromConfig(key.Pid, &ProcConfig{})
+		if err != nil && len(err.(collector.MultiError)) > 0 {
+			t.Fatal(err)
+		}
+		TargetProc[key] = reg
+	}
+
+	logger := log.New(os.Stdout, "[Test] ", log.Ltime|log.Lshortfile)
+	reconcileTargets(logger)
+	if len(TargetProc) != 2 {
+		t.Errorf("Expected 2 processes left, got %v.", TargetProc)
+	}
+	for _, key := range []ProcKey{{Pid: 10, StartTime: 1000}, {Pid: 13, StartTime: 0}} {
+		if _, ok := TargetProc[key]; !ok {
+			t.Errorf("Expected %+v kept.", key)
+		}
+	}
+	if key, _, ok := findTarget(12); ok {
+		t.Errorf("Expected reused pid removed, got %+v.", key)
+	}
+}
@@ -105,6 +105,21 @@ func metric2line(measurement string, m *module.Metric, mfType module.MetricType)
 				"quantile_%2f=%f", q.GetQuantile(), q.GetValue(),
 			))
 		}
+	case module.MetricType_HISTOGRAM:
+		if m.Histogram == nil {
+			return ""
+		}
+		buckets := m.Histogram.GetBucket()
+		lpvals = make([]string, 0, len(buckets)+2)
+		lpvals = append(lpvals,
+			fmt.Sprintf("count=%d", m.Histogram.GetSampleCount()),
+			fmt.Sprintf("sum=%f", m.Histogram.GetSampleSum()),
+		)
+		for _, b := range buckets {
+			lpvals = append(lpvals, fmt.Sprintf(
+				"bucket_%g=%d", b.GetUpperBound(), b.GetCumulativeCount(),
+			))
+		}
 	}
 
 	return fmt.Sprintf(
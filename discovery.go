@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	"wanggj.com/abyss/newProcTracing"
+	"wanggj.com/abyss/procfs"
+)
+
+// maxCommLen is the max length of comm, see TASK_COMM_LEN of kernel
+const maxCommLen = 15
+
+// DiscoveryRule selects processes to monitor without changing their command
+// lines, all matchers set must match:
+//
+//	exe: path or glob of the executable, see filepath.Match
+//	comm: name of the process
+//	argv: regexp matched against arguments joined by space
+//	uid, gid: real user and group id, which execve does not change even
+//	for set-user-ID executables, so they are read at sys_enter_execve
+//	parent: glob matched against executable or comm of the parent process
+//	cgroup: regexp matched against cgroup paths of the process
+//
+// Matched processes are monitored with the ProcConfig in file Config.
+type DiscoveryRule struct {
+	Name   string  `yaml:"name"`
+	Exe    string  `yaml:"exe,omitempty"`
+	Comm   string  `yaml:"comm,omitempty"`
+	Argv   string  `yaml:"argv,omitempty"`
+	Uid    *uint32 `yaml:"uid,omitempty"`
+	Gid    *uint32 `yaml:"gid,omitempty"`
+	Parent string  `yaml:"parent,omitempty"`
+	Cgroup string  `yaml:"cgroup,omitempty"`
+	Config string  `yaml:"config"`
+
+	argv   *regexp.Regexp
+	cgroup *regexp.Regexp
+}
+
+// DiscoveryRules is the rule file, the first matched rule is used.
+type DiscoveryRules struct {
+	Rules []DiscoveryRule `yaml:"rules"`
+}
+
+// discoveryRules is used to match processes without bpfMonitorFlag, nil if
+// no rule file configured
+var discoveryRules *DiscoveryRules
+
+// LoadDiscoveryRules reads and checks rules from path
+func LoadDiscoveryRules(path string) (*DiscoveryRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := &DiscoveryRules{}
+	if err := yaml.UnmarshalStrict(data, rules); err != nil {
+		return nil, err
+	}
+	for idx := range rules.Rules {
+		if err := rules.Rules[idx].compile(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+func (r *DiscoveryRule) compile() error {
+	if r.Config == "" {
+		return fmt.Errorf("Config of discovery rule %s must not be empty.", r.Name)
+	}
+	if r.Exe == "" && r.Comm == "" && r.Argv == "" && r.Uid == nil &&
+		r.Gid == nil && r.Parent == "" && r.Cgroup == "" {
+		return fmt.Errorf("Discovery rule %s has no matcher.", r.Name)
+	}
+	for _, pattern := range []string{r.Exe, r.Parent} {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Discovery rule %s has bad glob %q.", r.Name, pattern)
+		}
+	}
+	var err error
+	if r.Argv != "" {
+		if r.argv, err = regexp.Compile(r.Argv); err != nil {
+			return fmt.Errorf("Discovery rule %s has bad argv: %s.", r.Name, err.Error())
+		}
+	}
+	if r.Cgroup != "" {
+		if r.cgroup, err = regexp.Compile(r.Cgroup); err != nil {
+			return fmt.Errorf("Discovery rule %s has bad cgroup: %s.", r.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// procInfo is what discovery rules are matched against
+type procInfo struct {
+	Pid       uint32
+	Ppid      uint32
+	StartTime uint64
+	Exe       string
+	Comm      string
+	Argv      []string
+	Uid       uint32
+	Gid       uint32
+	Cgroups   []string
+	// ParentExe and ParentComm are empty if the parent cannot be read
+	ParentExe  string
+	ParentComm string
+}
+
+func globMatch(pattern, name string) bool {
+	ok, _ := filepath.Match(pattern, name)
+	return ok
+}
+
+func (r *DiscoveryRule) match(p *procInfo) bool {
+	if r.Exe != "" && !globMatch(r.Exe, p.Exe) {
+		return false
+	}
+	if r.Comm != "" && r.Comm != p.Comm {
+		return false
+	}
+	if r.argv != nil && !r.argv.MatchString(strings.Join(p.Argv, " ")) {
+		return false
+	}
+	if r.Uid != nil && *r.Uid != p.Uid {
+		return false
+	}
+	if r.Gid != nil && *r.Gid != p.Gid {
+		return false
+	}
+	if r.Parent != "" && !globMatch(r.Parent, p.ParentExe) && !globMatch(r.Parent, p.ParentComm) {
+		return false
+	}
+	if r.cgroup != nil {
+		matched := false
+		for _, c := range p.Cgroups {
+			if r.cgroup.MatchString(c) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Match returns MonitorProc with Config of the first rule matching p, nil
+// is returned if no rule matches.
+func (rs *DiscoveryRules) Match(p *procInfo) *MonitorProc {
+	for idx := range rs.Rules {
+		if rs.Rules[idx].match(p) {
+			return &MonitorProc{
+				Pid:        p.Pid,
+				Ppid:       p.Ppid,
+				StartTime:  p.StartTime,
+				Filename:   p.Exe,
+				Configpath: rs.Rules[idx].Config,
+				HostConfig: true,
+			}
+		}
+	}
+	return nil
+}
+
+// readStatusIds returns real uid and gid in /proc/[pid]/status
+func readStatusIds(dir string) (uint32, uint32, error) {
+	f, err := os.Open(filepath.Join(dir, "status"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	var (
+		ids   [2]uint32
+		found int
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		idx := 0
+		switch {
+		case !ok:
+			continue
+		case name == "Uid":
+		case name == "Gid":
+			idx = 1
+		default:
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return 0, 0, fmt.Errorf("%s is empty", name)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return 0, 0, err
+		}
+		ids[idx] = uint32(id)
+		found++
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	if found != 2 {
+		return 0, 0, fmt.Errorf("Uid or Gid not found")
+	}
+	return ids[0], ids[1], nil
+}
+
+// readCgroups returns cgroup paths in /proc/[pid]/cgroup, lines are like
+// "hierarchy-ID:controller-list:cgroup-path".
+func readCgroups(dir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) == 3 {
+			paths = append(paths, fields[2])
+		}
+	}
+	return paths, nil
+}
+
+func readComm(dir string) string {
+	comm, err := os.ReadFile(filepath.Join(dir, "comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(comm))
+}
+
+// readProcInfo reads attributes of process pid except Exe, Comm and Argv,
+// which are given by the caller as they are changed by execve.
+func readProcInfo(pid uint32, exe, comm string, argv []string) (*procInfo, error) {
+	dir := filepath.Join(procRoot, fmt.Sprint(pid))
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	st, err := procfs.ParseStat(string(stat))
+	if err != nil {
+		return nil, fmt.Errorf("Parse stat of process %d error: %s.", pid, err.Error())
+	}
+	uid, gid, err := readStatusIds(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Parse status of process %d error: %s.", pid, err.Error())
+	}
+	cgroups, err := readCgroups(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	parentDir := filepath.Join(procRoot, fmt.Sprint(st.Ppid))
+	parentExe, _ := os.Readlink(filepath.Join(parentDir, "exe"))
+	return &procInfo{
+		Pid:        pid,
+		Ppid:       st.Ppid,
+		StartTime:  st.StartTime,
+		Exe:        exe,
+		Comm:       comm,
+		Argv:       argv,
+		Uid:        uid,
+		Gid:        gid,
+		Cgroups:    cgroups,
+		ParentExe:  parentExe,
+		ParentComm: readComm(parentDir),
+	}, nil
+}
+
+// execComm returns comm of the process after execve filename
+func execComm(filename string) string {
+	comm := filepath.Base(filename)
+	if len(comm) > maxCommLen {
+		comm = comm[:maxCommLen]
+	}
+	return comm
+}
+
+// execExe returns the executable of execve filename of process pid as
+// /proc/[pid]/exe shows it, a relative filename is resolved against the
+// cwd of the process and symlinks against its root. filename is kept if
+// it cannot be resolved.
+func execExe(pid uint32, filename string) string {
+	dir := filepath.Join(procRoot, fmt.Sprint(pid))
+	if !filepath.IsAbs(filename) {
+		cwd, err := os.Readlink(filepath.Join(dir, "cwd"))
+		if err != nil {
+			return filename
+		}
+		filename = filepath.Join(cwd, filename)
+	}
+	exe, err := procfs.ResolveInRoot(filepath.Join(dir, "root"), filename)
+	if err != nil {
+		return filename
+	}
+	return exe
+}
+
+// discoverExecMsg matches the process of execve event against
+// discoveryRules. At sys_enter_execve, exe and comm in procfs are still of
+// the old image, so they are taken from the event, exe is resolved as
+// filename may be relative or a symlink.
+func discoverExecMsg(msg *newProcTracing.NewProcMsg) (*MonitorProc, error) {
+	if discoveryRules == nil {
+		return nil, nil
+	}
+	exe := execExe(msg.Pid, msg.Filename)
+	info, err := readProcInfo(msg.Pid, exe, execComm(msg.Filename), msg.Argv)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return discoveryRules.Match(info), nil
+}
+
+// discoverProc matches running process pid against discoveryRules, args
+// are read from its cmdline.
+func discoverProc(pid uint32, args []string) (*MonitorProc, error) {
+	if discoveryRules == nil {
+		return nil, nil
+	}
+	dir := filepath.Join(procRoot, fmt.Sprint(pid))
+	exe, err := os.Readlink(filepath.Join(dir, "exe"))
+	if err != nil {
+		// kernel threads have no exe
+		return nil, nil
+	}
+	info, err := readProcInfo(pid, exe, readComm(dir), args)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return discoveryRules.Match(info), nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wanggj.com/abyss/newProcTracing"
+)
+
+const discoveryYaml = `
+rules:
+- name: nginx
+  exe: /usr/sbin/nginx*
+  parent: systemd
+  config: /etc/abyss/nginx.yaml
+- name: worker
+  argv: "--role=worker( |$)"
+  uid: 1000
+  cgroup: "^/system.slice/worker.service$"
+  config: /etc/abyss/worker.yaml
+`
+
+func loadTestRules(t *testing.T, content string) (*DiscoveryRules, error) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return LoadDiscoveryRules(path)
+}
+
+func TestDiscoveryRules(t *testing.T) {
+	rules, err := loadTestRules(t, discoveryYaml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nginx := &procInfo{Pid: 10, Ppid: 1, Exe: "/usr/sbin/nginx", ParentComm: "systemd"}
+	if mp := rules.Match(nginx); mp == nil || mp.Configpath != "/etc/abyss/nginx.yaml" {
+		t.Errorf("Expected nginx matched, got %+v.", mp)
+	}
+	nginx.ParentComm = "bash"
+	if mp := rules.Match(nginx); mp != nil {
+		t.Errorf("Expected nginx started by bash not matched, got %+v.", mp)
+	}
+
+	worker := &procInfo{
+		Pid:     11,
+		Exe:     "/opt/app/bin/app",
+		Argv:    []string{"app", "--role=worker"},
+		Uid:     1000,
+		Cgroups: []string{"/system.slice/worker.service"},
+	}
+	if mp := rules.Match(worker); mp == nil || mp.Configpath != "/etc/abyss/worker.yaml" {
+		t.Errorf("Expected worker matched, got %+v.", mp)
+	}
+	worker.Argv = []string{"app", "--role=workerpool"}
+	if mp := rules.Match(worker); mp != nil {
+		t.Errorf("Expected argv not matched, got %+v.", mp)
+	}
+
+	bad := []string{
+		"rules:\n- name: a\n  exe: /bin/a\n",
+		"rules:\n- name: a\n  config: /etc/a.yaml\n",
+		"rules:\n- name: a\n  argv: \"(\"\n  config: /etc/a.yaml\n",
+		"rules:\n- name: a\n  exe: \"[\"\n  config: /etc/a.yaml\n",
+		"rules:\n- name: a\n  exe: /bin/a\n  config: /etc/a.yaml\n  user: root\n",
+	}
+	for idx, content := range bad {
+		if _, err := loadTestRules(t, content); err == nil {
+			t.Errorf("Expected error of %dth rules.", idx)
+		}
+	}
+}
+
+func TestDiscoverProc(t *testing.T) {
+	defer func(old string) { procRoot = old }(procRoot)
+	procRoot = t.TempDir()
+	defer func(old *DiscoveryRules) { discoveryRules = old }(discoveryRules)
+	rules, err := loadTestRules(t, discoveryYaml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discoveryRules = rules
+
+	writeProc(t, "1", "/sbin/init\x00")
+	writeProc(t, "20", "/usr/sbin/nginx\x00-g\x00daemon off;\x00")
+	files := map[string]string{
+		"1/comm":    "systemd\n",
+		"20/comm":   "nginx\n",
+		"20/status": "Name:\tnginx\nUid:\t0\t0\t0\t0\nGid:\t0\t0\t0\t0\n",
+		"20/cgroup": "0::/system.slice/nginx.service\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(procRoot, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("/usr/sbin/nginx", filepath.Join(procRoot, "20", "exe")); err != nil {
+		t.Fatal(err)
+	}
+
+	procs, _, err := scanMonitorProc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := MonitorProc{Pid: 20, Ppid: 1, StartTime: 2000, Filename: "/usr/sbin/nginx", Configpath: "/etc/abyss/nginx.yaml", HostConfig: true}
+	if len(procs) != 1 || *procs[0] != expected {
+		t.Fatalf("Expected %+v discovered, got %v.", expected, procs)
+	}
+
+	// exe of execve event is the new image
+	mp, err := discoverExecMsg(&newProcTracing.NewProcMsg{
+		Pid:      20,
+		Ppid:     1,
+		Filename: "/usr/sbin/nginx-debug",
+		Argv:     []string{"nginx-debug"},
+	})
+	if err != nil || mp == nil || mp.Filename != "/usr/sbin/nginx-debug" {
+		t.Errorf("Expected execve discovered, got %+v and %v.", mp, err)
+	}
+
+	// relative filename is resolved against cwd, and symlinks in the root
+	// of the process
+	sbin := filepath.Join(procRoot, "20", "root", "usr", "sbin")
+	if err := os.MkdirAll(sbin, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/usr/sbin/nginx-1.25", filepath.Join(sbin, "app")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/usr/sbin", filepath.Join(procRoot, "20", "cwd")); err != nil {
+		t.Fatal(err)
+	}
+	mp, err = discoverExecMsg(&newProcTracing.NewProcMsg{
+		Pid:      20,
+		Ppid:     1,
+		Filename: "./app",
+		Argv:     []string{"./app"},
+	})
+	if err != nil || mp == nil || mp.Filename != "/usr/sbin/nginx-1.25" {
+		t.Errorf("Expected relative execve discovered, got %+v and %v.", mp, err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang/glog"
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/module"
+)
+
+const (
+	exitEventName     = "process_exit"
+	unknownExitStatus = "unknown"
+)
+
+// ExitEvents keeps exits of monitored processes until they are gathered,
+// each exit is written once as Event "process_exit", whose value is the
+// lifetime in seconds. Labels are PID, PPID, exit_code, signal and
+// core_dumped, exits by signal or with non-zero code are of LevelError.
+// exit_code, signal and core_dumped of exits not traced by eBPF are
+// "unknown".
+type ExitEvents struct {
+	registry *collector.Registry
+	pending  *exitCollector
+}
+
+func NewExitEvents() *ExitEvents {
+	e := &ExitEvents{
+		registry: collector.NewRegistry(),
+		pending:  &exitCollector{},
+	}
+	e.registry.Register(e.pending)
+	return e
+}
+
+// Add records exit e, labels are added into the event as well. Detached
+// processes have not exited and are ignored.
+func (e *ExitEvents) Add(exit *ExitProc, labels collector.Labels) {
+	if exit.Detached {
+		return
+	}
+	constLabels := collector.Labels{}
+	for n, v := range labels {
+		constLabels[n] = v
+	}
+	constLabels["PID"] = fmt.Sprint(exit.Pid)
+	constLabels["PPID"] = fmt.Sprint(exit.Ppid)
+	if exit.StatusUnknown {
+		constLabels["exit_code"] = unknownExitStatus
+		constLabels["signal"] = unknownExitStatus
+		constLabels["core_dumped"] = unknownExitStatus
+	} else {
+		constLabels["exit_code"] = fmt.Sprint(exit.ErrorCode)
+		constLabels["signal"] = fmt.Sprint(exit.Signal)
+		constLabels["core_dumped"] = fmt.Sprint(exit.CoreDumped)
+	}
+
+	level := collector.LevelInfo
+	if exit.Signal != 0 || exit.ErrorCode != 0 {
+		level = collector.LevelError
+	}
+	desc := collector.NewDesc(
+		exitEventName,
+		"Exit of monitored process.",
+		level,
+		0,
+		nil,
+		constLabels,
+	)
+	m, err := collector.NewConstMetric(desc, collector.EventValue, exit.Lifetime.Seconds())
+	if err != nil {
+		glog.Error(err)
+		return
+	}
+	e.pending.add(m)
+}
+
+func (e *ExitEvents) Gather() (map[int][]*module.MetricFamily, error) {
+	return e.registry.Gather()
+}
+
+// exitCollector sends exits added since last collection
+type exitCollector struct {
+	metrics []collector.Metric
+	mtx     sync.Mutex
+}
+
+func (c *exitCollector) add(m collector.Metric) {
+	c.mtx.Lock()
+	c.metrics = append(c.metrics, m)
+	c.mtx.Unlock()
+}
+
+func (c *exitCollector) Describe(ch chan<- *collector.Desc) {}
+
+func (c *exitCollector) Collect(ch chan<- collector.Metric) {
+	c.mtx.Lock()
+	metrics := c.metrics
+	c.metrics = nil
+	c.mtx.Unlock()
+	for _, m := range metrics {
+		ch <- m
+	}
+}
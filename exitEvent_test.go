@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/module"
+)
+
+func gatherExits(t *testing.T, e *ExitEvents) []*module.Metric {
+	mfs, err := e.Gather()
+	if errs, ok := err.(collector.MultiError); ok && len(errs) > 0 {
+		t.Fatal(err)
+	}
+	metrics := []*module.Metric{}
+	for _, l := range mfs {
+		for _, mf := range l {
+			if mf.GetName() != exitEventName || mf.GetType() != module.MetricType_EVENT {
+				t.Errorf("Unexpected metric family %s of type %s.", mf.GetName(), mf.GetType())
+			}
+			metrics = append(metrics, mf.Metric...)
+		}
+	}
+	return metrics
+}
+
+func checkExitLabels(t *testing.T, m *module.Metric, expected map[string]string) {
+	labels := map[string]string{}
+	for _, l := range m.Label {
+		labels[l.GetName()] = l.GetValue()
+	}
+	for n, v := range expected {
+		if labels[n] != v {
+			t.Errorf("Expected label %s=%s, got %q.", n, v, labels[n])
+		}
+	}
+}
+
+func TestExitEvents(t *testing.T) {
+	e := NewExitEvents()
+	// a detached process has not exited
+	e.Add(&ExitProc{Pid: 8, Detached: true}, nil)
+	e.Add(&ExitProc{
+		Pid:        10,
+		Ppid:       1,
+		Signal:     11,
+		CoreDumped: true,
+		StartTime:  time.Hour,
+		Lifetime:   90 * time.Second,
+	}, collector.Labels{"PPID": "1", "app": "nginx"})
+
+	metrics := gatherExits(t, e)
+	if len(metrics) != 1 {
+		t.Fatalf("Expected 1 exit event, got %d.", len(metrics))
+	}
+	m := metrics[0]
+	if m.Event.GetValue() != 90 {
+		t.Errorf("Expected lifetime 90, got %g.", m.Event.GetValue())
+	}
+	checkExitLabels(t, m, map[string]string{
+		"PID": "10", "PPID": "1", "app": "nginx",
+		"exit_code": "0", "signal": "11", "core_dumped": "true",
+	})
+	if level := m.GetPriority() >> 16; level != uint32(collector.LevelError) {
+		t.Errorf("Expected level of killed process %d, got %d.", collector.LevelError, level)
+	}
+
+	// an exit is gathered only once
+	if metrics := gatherExits(t, e); len(metrics) != 0 {
+		t.Errorf("Expected no exit event, got %d.", len(metrics))
+	}
+}
+
+func TestExitEventsUnknownStatus(t *testing.T) {
+	e := NewExitEvents()
+	// exits found by rescan have no details
+	e.Add(&ExitProc{Pid: 9, StatusUnknown: true}, collector.Labels{"app": "nginx"})
+
+	metrics := gatherExits(t, e)
+	if len(metrics) != 1 {
+		t.Fatalf("Expected 1 exit event, got %d.", len(metrics))
+	}
+	checkExitLabels(t, metrics[0], map[string]string{
+		"PID": "9", "app": "nginx",
+		"exit_code": "unknown", "signal": "unknown", "core_dumped": "unknown",
+	})
+}
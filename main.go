@@ -11,6 +11,34 @@ import (
 func main() {
 	flag.Parse()
 
+	agentCfg, err := LoadAgentConfig(*agentConfigPath)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	n, err := setupNotifier(agentCfg)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if n != nil {
+		defer n.Close()
+	}
+	if err := setupStateStore(agentCfg); err != nil {
+		fmt.Println(err)
+		return
+	}
+	procRescanInterval = agentCfg.RescanInterval
+	metadataConfig = agentCfg.Metadata
+	if err := setupEventSource(agentCfg); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := setupDiscovery(agentCfg); err != nil {
+		fmt.Println(err)
+		return
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	monCh := make(chan *MonitorProc, 10)
 	exitCh := make(chan *ExitProc, 10)
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"wanggj.com/abyss/collector"
+)
+
+// MetadataConfig switches labels of process metadata, which are resolved
+// once when a process is adopted and added into ConstLabels of all metrics
+// of the process:
+//
+//	exe_name: basename of the executable
+//	exe: path of the executable
+//	comm: name of the process
+//	uid, user: real user id and its name
+//	build_id: GNU build ID of the executable, or Go build ID
+//	hostname: hostname of the machine
+//
+// All labels are off by default. The start time is not metadata, every
+// process is labeled with "start_time" as part of its identity, see
+// ProcKey.
+type MetadataConfig struct {
+	ExeName  bool `yaml:"exeName,omitempty"`
+	Exe      bool `yaml:"exe,omitempty"`
+	Comm     bool `yaml:"comm,omitempty"`
+	Uid      bool `yaml:"uid,omitempty"`
+	User     bool `yaml:"user,omitempty"`
+	BuildId  bool `yaml:"buildId,omitempty"`
+	Hostname bool `yaml:"hostname,omitempty"`
+}
+
+// metadataConfig selects metadata labels of all processes, nil if none
+var metadataConfig *MetadataConfig
+
+// readMetadataLabels reads labels enabled by cfg of process pid. Labels
+// failed to be read are skipped, and their errors are returned with the
+// rest of labels.
+func readMetadataLabels(pid uint32, cfg *MetadataConfig) (collector.Labels, error) {
+	labels := collector.Labels{}
+	if cfg == nil {
+		return labels, nil
+	}
+	dir := filepath.Join(procRoot, fmt.Sprint(pid))
+	errs := collector.MultiError{}
+
+	if cfg.ExeName || cfg.Exe {
+		exe, err := os.Readlink(filepath.Join(dir, "exe"))
+		if err != nil {
+			errs.Append(err)
+		} else {
+			exe = strings.TrimSuffix(exe, " (deleted)")
+			if cfg.Exe {
+				labels["exe"] = exe
+			}
+			if cfg.ExeName {
+				labels["exe_name"] = filepath.Base(exe)
+			}
+		}
+	}
+	if cfg.Comm {
+		if comm := readComm(dir); comm != "" {
+			labels["comm"] = comm
+		}
+	}
+	if cfg.Uid || cfg.User {
+		uid, _, err := readStatusIds(dir)
+		if err != nil {
+			errs.Append(fmt.Errorf("Parse status of process %d error: %s.", pid, err.Error()))
+		} else {
+			if cfg.Uid {
+				labels["uid"] = fmt.Sprint(uid)
+			}
+			if cfg.User {
+				labels["user"] = fmt.Sprint(uid)
+				if u, err := user.LookupId(fmt.Sprint(uid)); err == nil {
+					labels["user"] = u.Username
+				}
+			}
+		}
+	}
+	if cfg.BuildId {
+		id, err := readBuildId(filepath.Join(dir, "exe"))
+		if err != nil {
+			errs.Append(fmt.Errorf("Read build ID of process %d error: %s.", pid, err.Error()))
+		} else if id != "" {
+			labels["build_id"] = id
+		}
+	}
+	if cfg.Hostname {
+		if host, err := os.Hostname(); err != nil {
+			errs.Append(err)
+		} else {
+			labels["hostname"] = host
+		}
+	}
+
+	if len(errs) == 0 {
+		return labels, nil
+	}
+	return labels, errs
+}
+
+// readBuildId returns the GNU build ID of ELF file path in hex, or the Go
+// build ID if it has no GNU one. Empty string is returned if it has neither.
+func readBuildId(path string) (string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if s := f.Section(".note.gnu.build-id"); s != nil {
+		data, err := s.Data()
+		if err != nil {
+			return "", err
+		}
+		// NT_GNU_BUILD_ID
+		if desc := elfNote(data, f.ByteOrder, "GNU", 3); desc != nil {
+			return hex.EncodeToString(desc), nil
+		}
+	}
+	if s := f.Section(".note.go.buildid"); s != nil {
+		data, err := s.Data()
+		if err != nil {
+			return "", err
+		}
+		if desc := elfNote(data, f.ByteOrder, "Go", 4); desc != nil {
+			return string(desc), nil
+		}
+	}
+	return "", nil
+}
+
+// elfNote returns desc of the note with name and type in data of a note
+// section, nil is returned if it is not found.
+func elfNote(data []byte, order binary.ByteOrder, name string, typ uint32) []byte {
+	align := func(n uint32) uint64 { return (uint64(n) + 3) &^ 3 }
+	for len(data) >= 12 {
+		namesz, descsz := order.Uint32(data[0:4]), order.Uint32(data[4:8])
+		t := order.Uint32(data[8:12])
+		data = data[12:]
+		if align(namesz)+align(descsz) > uint64(len(data)) {
+			return nil
+		}
+		n := bytes.TrimRight(data[:namesz], "\x00")
+		desc := data[align(namesz) : align(namesz)+uint64(descsz)]
+		if string(n) == name && t == typ {
+			return desc
+		}
+		data = data[align(namesz)+align(descsz):]
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadMetadataLabels(t *testing.T) {
+	defer func(old string) { procRoot = old }(procRoot)
+	procRoot = t.TempDir()
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(procRoot, "50")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"50/stat":    "50 (app) S 1 50 50 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 1 0 1500 1000 10",
+		"50/status":  "Name:\tapp\nUid:\t0\t0\t0\t0\nGid:\t0\t0\t0\t0\n",
+		"50/comm":    "app\n",
+		"50/cmdline": "app\x00",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(procRoot, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(exe, filepath.Join(dir, "exe")); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &MetadataConfig{
+		ExeName: true,
+		Comm:    true,
+		Uid:     true,
+		User:    true,
+		BuildId: true,
+	}
+	labels, err := readMetadataLabels(50, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"exe_name": filepath.Base(exe),
+		"comm":     "app",
+		"uid":      "0",
+		"user":     "root",
+	}
+	for n, v := range expected {
+		if labels[n] != v {
+			t.Errorf("Expected label %s=%s, got %q.", n, v, labels[n])
+		}
+	}
+	// test binary built by go has Go build ID
+	if labels["build_id"] == "" {
+		t.Error("Expected build_id of test binary.")
+	}
+	if _, ok := labels["hostname"]; ok {
+		t.Error("Expected hostname disabled.")
+	}
+
+	// labels of exited process are skipped
+	if labels, err := readMetadataLabels(51, cfg); err == nil || len(labels) != 0 {
+		t.Errorf("Expected error of exited process, got %v and %v.", labels, err)
+	}
+	if labels, err := readMetadataLabels(50, nil); err != nil || len(labels) != 0 {
+		t.Errorf("Expected no label, got %v and %v.", labels, err)
+	}
+}
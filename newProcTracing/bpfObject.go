@@ -0,0 +1,49 @@
+package newProcTracing
+
+import (
+	_ "embed"
+	"fmt"
+
+	bpf "github.com/aquasecurity/libbpfgo"
+)
+
+//go:generate ./buildbpf.sh
+
+// newProcessBPF is newProcess.bpf.o built by go generate. It is compiled
+// against vmlinux.h and reads kernel structs by BPF_CORE_READ, so libbpf
+// relocates it against BTF of the running kernel when loaded.
+//
+//go:embed newProcess.bpf.o
+var newProcessBPF []byte
+
+// BpfOpts is the options of BpfSource, all of them are empty by default.
+type BpfOpts struct {
+	// ObjPath loads the eBPF object from file instead of the embedded one,
+	// for development of newProcess.bpf.c
+	ObjPath string
+	// BTFPath is BTF of the running kernel used for CO-RE relocation, only
+	// needed if the kernel has no /sys/kernel/btf/vmlinux
+	BTFPath string
+}
+
+// newBpfModule opens the eBPF object of newProcess.bpf.c by opts
+func newBpfModule(opts *BpfOpts) (*bpf.Module, error) {
+	if opts == nil {
+		opts = &BpfOpts{}
+	}
+	args := bpf.NewModuleArgs{BPFObjName: "newProcess", BTFObjPath: opts.BTFPath}
+	if opts.ObjPath != "" {
+		args.BPFObjPath = opts.ObjPath
+		module, err := bpf.NewModuleFromFileArgs(args)
+		if err != nil {
+			return nil, fmt.Errorf("Can not open eBPF object %s: %s.", opts.ObjPath, err.Error())
+		}
+		return module, nil
+	}
+	args.BPFObjBuff = newProcessBPF
+	module, err := bpf.NewModuleFromBufferArgs(args)
+	if err != nil {
+		return nil, fmt.Errorf("Can not open embedded eBPF object: %s.", err.Error())
+	}
+	return module, nil
+}
@@ -0,0 +1,187 @@
+package newProcTracing
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+func loadObjectSpec(t *testing.T) *ebpf.CollectionSpec {
+	spec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(newProcessBPF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+// TestEmbeddedObject fails if newProcess.bpf.o is not rebuilt after
+// newProcess.bpf.c or newProcess.h changes.
+func TestEmbeddedObject(t *testing.T) {
+	spec := loadObjectSpec(t)
+	for _, name := range []string{"handle_exec", "handle_exit", "handle_fork"} {
+		if _, ok := spec.Programs[name]; !ok {
+			t.Errorf("Expected program %s in embedded object.", name)
+		}
+	}
+	for _, name := range []string{"new_proc", "exec_scratch", "exit_proc", "fork_proc"} {
+		if _, ok := spec.Maps[name]; !ok {
+			t.Errorf("Expected map %s in embedded object.", name)
+		}
+	}
+
+	// sizes and offsets in bytes expected by decoders
+	layouts := map[string]map[string]uint32{
+		"exec_record":  {"": uint32(ExecRecHdrLen + ExecRecDataLen), "data": uint32(ExecRecHdrLen)},
+		"process_exit": {"": uint32(ExitProcMsgSize), "start_time": 16, "lifetime": 24, "core_dumped": 32},
+		"process_fork": {"": uint32(ForkProcMsgSize), "comm": 8},
+	}
+	for name, layout := range layouts {
+		var s *btf.Struct
+		if err := spec.Types.TypeByName(name, &s); err != nil {
+			t.Errorf("Expected struct %s in embedded object: %s.", name, err.Error())
+			continue
+		}
+		offsets := map[string]uint32{"": s.Size}
+		for _, m := range s.Members {
+			offsets[m.Name] = m.Offset.Bytes()
+		}
+		for member, expected := range layout {
+			if offsets[member] != expected {
+				t.Errorf("Expected %s.%s at %d, got %d.", name, member, expected, offsets[member])
+			}
+		}
+	}
+}
+
+// TestEmbeddedObjectTrace loads the embedded object through the verifier
+// and decodes events of a child process, it needs root.
+func TestEmbeddedObjectTrace(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("eBPF programs can only be loaded by root.")
+	}
+	coll, err := ebpf.NewCollection(loadObjectSpec(t))
+	var verr *ebpf.VerifierError
+	if errors.As(err, &verr) {
+		t.Fatalf("%+v", verr)
+	} else if errors.Is(err, os.ErrPermission) {
+		t.Skipf("Can not load eBPF programs: %s.", err.Error())
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+
+	links := []link.Link{}
+	defer func() {
+		for _, l := range links {
+			l.Close()
+		}
+	}()
+	for _, tp := range [][3]string{
+		{"syscalls", "sys_enter_execve", "handle_exec"},
+		{"sched", "sched_process_exit", "handle_exit"},
+	} {
+		l, err := link.Tracepoint(tp[0], tp[1], coll.Programs[tp[2]], nil)
+		if err != nil {
+			t.Skipf("Can not attach tracepoint %s: %s.", tp[1], err.Error())
+		}
+		links = append(links, l)
+	}
+	l, err := link.AttachRawTracepoint(link.RawTracepointOptions{
+		Name:    "sched_process_fork",
+		Program: coll.Programs["handle_fork"],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	links = append(links, l)
+
+	readers := map[string]*ringbuf.Reader{}
+	for _, name := range []string{"new_proc", "exit_proc", "fork_proc"} {
+		rd, err := ringbuf.NewReader(coll.Maps[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rd.Close()
+		rd.SetDeadline(time.Now().Add(5 * time.Second))
+		readers[name] = rd
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", "exit 3", "abyss")
+	cmd.Env = []string{"PATH=/bin", "ABYSS_MONITOR=1"}
+	var exitErr *exec.ExitError
+	if err := cmd.Run(); !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("Expected exit code 3, got %v.", err)
+	}
+	pid := uint32(cmd.Process.Pid)
+
+	// readUntil reads records of name until found returns true
+	readUntil := func(name string, found func([]byte) bool) {
+		for {
+			rec, err := readers[name].Read()
+			if err != nil {
+				t.Fatalf("Expected record of process %d in %s: %s.", pid, name, err.Error())
+			}
+			if found(rec.RawSample) {
+				return
+			}
+		}
+	}
+
+	assembler := NewExecAssembler()
+	readUntil("new_proc", func(b []byte) bool {
+		msg, err := assembler.Add(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg == nil || msg.Pid != pid {
+			return false
+		}
+		expected := &NewProcMsg{
+			Pid:      pid,
+			Ppid:     uint32(os.Getpid()),
+			Filename: "/bin/sh",
+			Argv:     []string{"/bin/sh", "-c", "exit 3", "abyss"},
+			Envp:     []string{"ABYSS_MONITOR=1"},
+		}
+		if !reflect.DeepEqual(msg, expected) {
+			t.Errorf("Expected exec %+v, got %+v.", expected, msg)
+		}
+		return true
+	})
+	readUntil("exit_proc", func(b []byte) bool {
+		msg, err := DecodeToExitProcMsg(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Pid != pid {
+			return false
+		}
+		if msg.Ppid != uint32(os.Getpid()) || msg.ErrorCode != 3 || msg.Signal != 0 ||
+			msg.CoreDumped || msg.StartTime <= 0 || msg.Lifetime <= 0 {
+			t.Errorf("Unexpected exit %+v.", msg)
+		}
+		return true
+	})
+	readUntil("fork_proc", func(b []byte) bool {
+		msg, err := DecodeToForkProcMsg(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Pid != pid {
+			return false
+		}
+		if msg.Ppid != uint32(os.Getpid()) || msg.Comm == "" {
+			t.Errorf("Unexpected fork %+v.", msg)
+		}
+		return true
+	})
+}
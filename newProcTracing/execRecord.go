@@ -0,0 +1,191 @@
+package newProcTracing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// const variant for exec records, must sync to newProcess.h
+	ExecRecHdrLen  int = 20
+	ExecRecDataLen int = 4096
+	execRecMagic       = 0xabe0
+
+	execRecFilename uint8 = 0
+	execRecArgv     uint8 = 1
+	execRecEnvp     uint8 = 2
+
+	execRecLast      uint8 = 1
+	execRecTruncated uint8 = 2
+
+	// max execve assembled at the same time, an execve is pending only
+	// between its first and last record, so it is rarely reached
+	maxPendingExec = 1024
+)
+
+// execRecord is a decoded struct exec_record in newProcess.h, strs are
+// the NUL terminated strings in data.
+type execRecord struct {
+	pid   uint32
+	ppid  uint32
+	seq   uint16
+	kind  uint8
+	flags uint8
+	strs  []string
+}
+
+// decodeExecRecord decodes the record at the beginning of b, size of the
+// record is returned as well.
+func decodeExecRecord(b []byte) (*execRecord, int, error) {
+	if len(b) < ExecRecHdrLen {
+		return nil, 0, fmt.Errorf("Exec record is shorter than header, size is %d, expect at least %d.", len(b), ExecRecHdrLen)
+	}
+	if magic := binary.LittleEndian.Uint16(b[8:10]); magic != execRecMagic {
+		return nil, 0, fmt.Errorf("Bad magic %#x of exec record.", magic)
+	}
+	rec := &execRecord{
+		pid:   binary.LittleEndian.Uint32(b[:4]),
+		ppid:  binary.LittleEndian.Uint32(b[4:8]),
+		seq:   binary.LittleEndian.Uint16(b[10:12]),
+		kind:  b[12],
+		flags: b[13],
+	}
+	count := int(binary.LittleEndian.Uint16(b[14:16]))
+	size := int(binary.LittleEndian.Uint16(b[16:18]))
+	if rec.kind > execRecEnvp {
+		return nil, 0, fmt.Errorf("Unknown kind %d of exec record.", rec.kind)
+	}
+	if size > ExecRecDataLen || ExecRecHdrLen+size > len(b) {
+		return nil, 0, fmt.Errorf("Data of exec record overflows, size is %d, %d bytes left.", size, len(b)-ExecRecHdrLen)
+	}
+	data := b[ExecRecHdrLen : ExecRecHdrLen+size]
+	rec.strs = make([]string, 0, count)
+	for len(data) > 0 {
+		idx := bytes.IndexByte(data, 0)
+		if idx < 0 {
+			return nil, 0, fmt.Errorf("String of exec record is not terminated.")
+		}
+		rec.strs = append(rec.strs, string(data[:idx]))
+		data = data[idx+1:]
+	}
+	if len(rec.strs) != count {
+		return nil, 0, fmt.Errorf("Exec record has %d strings, expect %d.", len(rec.strs), count)
+	}
+	if rec.kind == execRecFilename && count > 1 {
+		return nil, 0, fmt.Errorf("Exec record has %d filenames.", count)
+	}
+	return rec, ExecRecHdrLen + size, nil
+}
+
+// DecodeToNewProcMsg decodes records of an execve into NewProcMsg, msg is
+// the records concatenated in order of seq, the last one must have
+// EXEC_REC_LAST set.
+func DecodeToNewProcMsg(msg []byte) (*NewProcMsg, error) {
+	var res *NewProcMsg
+	kind := execRecFilename
+	for seq := 0; ; seq++ {
+		rec, size, err := decodeExecRecord(msg)
+		if err != nil {
+			return nil, err
+		}
+		msg = msg[size:]
+		if int(rec.seq) != seq {
+			return nil, fmt.Errorf("Exec record of process %d out of order, seq is %d, expect %d.", rec.pid, rec.seq, seq)
+		}
+		if res == nil {
+			res = &NewProcMsg{Pid: rec.pid, Ppid: rec.ppid}
+		} else if rec.pid != res.Pid {
+			return nil, fmt.Errorf("Exec record of process %d mixed into process %d.", rec.pid, res.Pid)
+		}
+		if rec.kind < kind {
+			return nil, fmt.Errorf("Exec record of process %d out of order, kind %d after %d.", rec.pid, rec.kind, kind)
+		}
+		kind = rec.kind
+
+		switch rec.kind {
+		case execRecFilename:
+			if len(rec.strs) > 0 {
+				if res.Filename != "" {
+					return nil, fmt.Errorf("Exec record of process %d has more than one filename.", rec.pid)
+				}
+				res.Filename = rec.strs[0]
+			}
+		case execRecArgv:
+			res.Argv = append(res.Argv, rec.strs...)
+		case execRecEnvp:
+			res.Envp = append(res.Envp, rec.strs...)
+		}
+		if rec.flags&execRecTruncated != 0 {
+			res.Truncated = true
+		}
+		if rec.flags&execRecLast != 0 {
+			break
+		}
+		if len(msg) == 0 {
+			return nil, fmt.Errorf("Exec records of process %d end without the last one.", res.Pid)
+		}
+	}
+	if len(msg) != 0 {
+		return nil, fmt.Errorf("%d bytes left after the last exec record of process %d.", len(msg), res.Pid)
+	}
+	return res, nil
+}
+
+// pendingExec is the records of an execve received so far
+type pendingExec struct {
+	buf  []byte
+	next uint16
+}
+
+// ExecAssembler joins records received from ringbuf into NewProcMsg,
+// records of the same execve are in order, but may interleave with records
+// of other processes.
+type ExecAssembler struct {
+	pending map[uint32]*pendingExec
+}
+
+func NewExecAssembler() *ExecAssembler {
+	return &ExecAssembler{pending: map[uint32]*pendingExec{}}
+}
+
+// Add adds a record, NewProcMsg is returned if it is the last record of an
+// execve. Records of an execve are dropped if any of them is lost.
+func (a *ExecAssembler) Add(b []byte) (*NewProcMsg, error) {
+	rec, size, err := decodeExecRecord(b)
+	if err != nil {
+		return nil, err
+	}
+	if size != len(b) {
+		return nil, fmt.Errorf("%d bytes left after exec record of process %d.", len(b)-size, rec.pid)
+	}
+
+	p, ok := a.pending[rec.pid]
+	if rec.seq == 0 {
+		// previous execve of the process is dropped if not ended
+		if !ok && len(a.pending) >= maxPendingExec {
+			for pid := range a.pending {
+				delete(a.pending, pid)
+				break
+			}
+		}
+		p = &pendingExec{}
+		a.pending[rec.pid] = p
+	} else if !ok || rec.seq != p.next {
+		delete(a.pending, rec.pid)
+		return nil, fmt.Errorf("Exec record %d of process %d is out of order, execve is dropped.", rec.seq, rec.pid)
+	}
+	p.buf = append(p.buf, b...)
+	p.next++
+
+	if rec.flags&execRecLast == 0 {
+		return nil, nil
+	}
+	delete(a.pending, rec.pid)
+	return DecodeToNewProcMsg(p.buf)
+}
+
+// Pending returns number of execve not ended
+func (a *ExecAssembler) Pending() int {
+	return len(a.pending)
+}
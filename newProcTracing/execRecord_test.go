@@ -0,0 +1,157 @@
+package newProcTracing
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// record encodes struct exec_record like the eBPF program
+func record(pid uint32, seq uint16, kind, flags uint8, strs ...string) []byte {
+	data := []byte{}
+	for _, s := range strs {
+		data = append(append(data, s...), 0)
+	}
+	b := make([]byte, ExecRecHdrLen, ExecRecHdrLen+len(data))
+	binary.LittleEndian.PutUint32(b[0:4], pid)
+	binary.LittleEndian.PutUint32(b[4:8], 1)
+	binary.LittleEndian.PutUint16(b[8:10], execRecMagic)
+	binary.LittleEndian.PutUint16(b[10:12], seq)
+	b[12], b[13] = kind, flags
+	binary.LittleEndian.PutUint16(b[14:16], uint16(len(strs)))
+	binary.LittleEndian.PutUint16(b[16:18], uint16(len(data)))
+	return append(b, data...)
+}
+
+func execRecords(pid uint32) [][]byte {
+	long := strings.Repeat("a", 2000)
+	return [][]byte{
+		record(pid, 0, execRecFilename, 0, "/bin/app"),
+		record(pid, 1, execRecArgv, 0, "/bin/app", "-bpfMonitor", long),
+		record(pid, 2, execRecArgv, 0, "-bpfMonConfig", "/etc/app.yaml"),
+		record(pid, 3, execRecEnvp, execRecLast, "ABYSS_MONITOR=1"),
+	}
+}
+
+func TestDecodeToNewProcMsg(t *testing.T) {
+	msg, err := DecodeToNewProcMsg(concat(execRecords(42)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &NewProcMsg{
+		Pid:      42,
+		Ppid:     1,
+		Filename: "/bin/app",
+		Argv:     []string{"/bin/app", "-bpfMonitor", strings.Repeat("a", 2000), "-bpfMonConfig", "/etc/app.yaml"},
+		Envp:     []string{"ABYSS_MONITOR=1"},
+	}
+	if !reflect.DeepEqual(msg, expected) {
+		t.Errorf("Expected %+v, got %+v.", expected, msg)
+	}
+
+	bad := map[string][]byte{
+		"empty":     nil,
+		"no last":   concat(execRecords(42)[:3]...),
+		"seq gap":   concat(execRecords(42)[0], execRecords(42)[2], execRecords(42)[3]),
+		"mixed pid": concat(execRecords(42)[0], execRecords(43)[1], execRecords(42)[2], execRecords(42)[3]),
+		"trailing":  append(concat(execRecords(42)...), 0),
+		"kind":      concat(record(42, 0, execRecArgv, 0), record(42, 1, execRecFilename, execRecLast, "/bin/app")),
+		"unterminated": func() []byte {
+			b := record(42, 0, execRecFilename, execRecLast, "/bin/app")
+			return b[:len(b)-1]
+		}(),
+	}
+	for name, b := range bad {
+		if _, err := DecodeToNewProcMsg(b); err == nil {
+			t.Errorf("Expected error of %s records.", name)
+		}
+	}
+}
+
+func TestExecAssembler(t *testing.T) {
+	a := NewExecAssembler()
+	r42, r43 := execRecords(42), execRecords(43)
+	// records of processes interleave
+	for idx := 0; idx < 3; idx++ {
+		for _, rec := range [][]byte{r42[idx], r43[idx]} {
+			if msg, err := a.Add(rec); msg != nil || err != nil {
+				t.Fatalf("Expected record %d pending, got %v and %v.", idx, msg, err)
+			}
+		}
+	}
+	if msg, err := a.Add(r43[3]); err != nil || msg == nil || msg.Pid != 43 || len(msg.Argv) != 5 {
+		t.Errorf("Expected execve of process 43, got %+v and %v.", msg, err)
+	}
+	if msg, err := a.Add(r42[3]); err != nil || msg == nil || msg.Pid != 42 {
+		t.Errorf("Expected execve of process 42, got %+v and %v.", msg, err)
+	}
+
+	// a lost record drops the execve
+	a.Add(r42[0])
+	if _, err := a.Add(r42[2]); err == nil {
+		t.Error("Expected error of lost record.")
+	}
+	if msg, err := a.Add(r42[3]); msg != nil || err == nil {
+		t.Errorf("Expected dropped execve, got %+v and %v.", msg, err)
+	}
+
+	// a new execve replaces the unfinished one
+	a.Add(r42[0])
+	a.Add(r42[1])
+	for _, rec := range r42 {
+		a.Add(rec)
+	}
+	if a.Pending() != 0 {
+		t.Errorf("Expected no pending execve, got %d.", a.Pending())
+	}
+
+	truncated := record(44, 0, execRecFilename, execRecLast|execRecTruncated, "/bin/app")
+	if msg, err := a.Add(truncated); err != nil || msg == nil || !msg.Truncated || len(msg.Argv) != 0 {
+		t.Errorf("Expected truncated execve, got %+v and %v.", msg, err)
+	}
+}
+
+func concat(bufs ...[]byte) []byte {
+	res := []byte{}
+	for _, b := range bufs {
+		res = append(res, b...)
+	}
+	return res
+}
+
+func FuzzDecodeToNewProcMsg(f *testing.F) {
+	f.Add(concat(execRecords(42)...))
+	f.Add(record(1, 0, execRecFilename, execRecLast))
+	f.Add(record(1, 0, execRecFilename, execRecLast|execRecTruncated, "/bin/sh"))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		msg, err := DecodeToNewProcMsg(b)
+		if err != nil {
+			return
+		}
+		if msg == nil {
+			t.Fatal("Expected message without error.")
+		}
+		for _, arg := range append(append([]string{msg.Filename}, msg.Argv...), msg.Envp...) {
+			if strings.IndexByte(arg, 0) >= 0 {
+				t.Fatalf("String %q contains NUL.", arg)
+			}
+		}
+		// records of a message are assembled into the same message
+		a := NewExecAssembler()
+		var assembled *NewProcMsg
+		for len(b) > 0 {
+			_, size, err := decodeExecRecord(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if assembled, err = a.Add(b[:size]); err != nil {
+				t.Fatal(err)
+			}
+			b = b[size:]
+		}
+		if !reflect.DeepEqual(msg, assembled) {
+			t.Fatalf("Expected %+v assembled, got %+v.", msg, assembled)
+		}
+	})
+}
@@ -0,0 +1,359 @@
+package newProcTracing
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	glog "github.com/golang/glog"
+	"wanggj.com/abyss/procfs"
+)
+
+// const variant of process connector, see linux/connector.h and
+// linux/cn_proc.h
+const (
+	cnIdxProc          = 1
+	cnValProc          = 1
+	procCnMcastListen  = 1
+	cnMsgLen           = 20
+	procEventHdrLen    = 16
+	procEventFork      = 0x00000001
+	procEventExec      = 0x00000002
+	procEventExit      = 0x80000000
+	netlinkRecvBufSize = 64 * 1024
+)
+
+// NetlinkSource receives events from the process connector (CN_PROC) of
+// netlink, it needs CAP_NET_ADMIN but no eBPF. Exec events carry only pids,
+// so argv and env are read from procRoot and processes exiting right after
+// execve are missed. The exit of a process is reported once its leader and
+// all other threads have exited, as the leader may exit before them by
+// pthread_exit, or while its exit_group is still killing them.
+type NetlinkSource struct {
+	procRoot string
+	file     *os.File
+	// pending keeps exits of leaders whose threads are still running by
+	// pid, they are reported by the exit of the last thread. Only the
+	// routine of run accesses it.
+	pending map[uint32]*ExitProcMsg
+}
+
+// threadExitMsg is the exit of a thread other than the leader, Pid is the
+// pid of its process
+type threadExitMsg struct {
+	ExitProcMsg
+}
+
+// NewNetlinkSource returns NetlinkSource reading processes from procRoot,
+// default "/proc".
+func NewNetlinkSource(procRoot string) *NetlinkSource {
+	if procRoot == "" {
+		procRoot = "/proc"
+	}
+	return &NetlinkSource{procRoot: procRoot, pending: map[uint32]*ExitProcMsg{}}
+}
+
+func (s *NetlinkSource) Start(
+	ctx context.Context,
+	execCh chan<- *NewProcMsg,
+	exitCh chan<- *ExitProcMsg,
+	forkCh chan<- *ForkProcMsg,
+) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM, syscall.NETLINK_CONNECTOR)
+	if err != nil {
+		return fmt.Errorf("Can not create netlink socket: %s.", err.Error())
+	}
+	// kernel assigns the port id if Pid is 0
+	addr := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: cnIdxProc}
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("Can not bind netlink socket: %s.", err.Error())
+	}
+	if err := syscall.Sendto(fd, procCnMcastMsg(procCnMcastListen), 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("Can not listen to process connector: %s.", err.Error())
+	}
+	// reads are interrupted by Close if fd is nonblocking
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	file := os.NewFile(uintptr(fd), "cn_proc")
+	s.file = file
+
+	go func() {
+		<-ctx.Done()
+		file.SetReadDeadline(time.Now())
+	}()
+	go s.run(ctx, file, execCh, exitCh, forkCh)
+	return nil
+}
+
+func (s *NetlinkSource) Close() {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+}
+
+// procCnMcastMsg generates netlink message of cn_msg with op, such as
+// PROC_CN_MCAST_LISTEN.
+func procCnMcastMsg(op uint32) []byte {
+	b := make([]byte, syscall.NLMSG_HDRLEN+cnMsgLen+4)
+	binary.LittleEndian.PutUint32(b[0:4], uint32(len(b)))
+	binary.LittleEndian.PutUint16(b[4:6], syscall.NLMSG_DONE)
+	cn := b[syscall.NLMSG_HDRLEN:]
+	binary.LittleEndian.PutUint32(cn[0:4], cnIdxProc)
+	binary.LittleEndian.PutUint32(cn[4:8], cnValProc)
+	binary.LittleEndian.PutUint16(cn[16:18], 4)
+	binary.LittleEndian.PutUint32(cn[cnMsgLen:], op)
+	return b
+}
+
+func (s *NetlinkSource) run(
+	ctx context.Context,
+	file *os.File,
+	execCh chan<- *NewProcMsg,
+	exitCh chan<- *ExitProcMsg,
+	forkCh chan<- *ForkProcMsg,
+) {
+	buf := make([]byte, netlinkRecvBufSize)
+	for {
+		n, err := file.Read(buf)
+		if ctx.Err() != nil {
+			glog.Info("Routine \"NetlinkSource\" exit.\n")
+			return
+		}
+		if err != nil {
+			// ENOBUFS means events are dropped by kernel
+			glog.Warningf("Receive from process connector error: %s.", err.Error())
+			continue
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			glog.Warning(err.Error())
+			continue
+		}
+		for _, m := range msgs {
+			if m.Header.Type != syscall.NLMSG_DONE {
+				continue
+			}
+			s.handle(ctx, m.Data, execCh, exitCh, forkCh)
+		}
+	}
+}
+
+// handle sends the message of proc_event in cn_msg data
+func (s *NetlinkSource) handle(
+	ctx context.Context,
+	data []byte,
+	execCh chan<- *NewProcMsg,
+	exitCh chan<- *ExitProcMsg,
+	forkCh chan<- *ForkProcMsg,
+) {
+	ev, err := decodeProcEvent(data)
+	if err != nil {
+		glog.Warning(err.Error())
+		return
+	}
+	switch msg := ev.(type) {
+	case *ForkProcMsg:
+		if forkCh == nil {
+			return
+		}
+		msg.Comm = readProcComm(s.procRoot, msg.Pid)
+		select {
+		case forkCh <- msg:
+		case <-ctx.Done():
+		}
+	case *NewProcMsg:
+		if !readExecProc(s.procRoot, msg) {
+			return
+		}
+		select {
+		case execCh <- msg:
+		case <-ctx.Done():
+		}
+	case *ExitProcMsg:
+		// the leader is a zombie until other threads exit
+		readExitTimes(s.procRoot, msg)
+		if !threadGroupExited(s.procRoot, msg.Pid) {
+			s.pending[msg.Pid] = msg
+			return
+		}
+		select {
+		case exitCh <- msg:
+		case <-ctx.Done():
+		}
+	case *threadExitMsg:
+		exit, ok := s.pending[msg.Pid]
+		if !ok || !threadGroupExited(s.procRoot, msg.Pid) {
+			return
+		}
+		delete(s.pending, msg.Pid)
+		// the status of the process is the one of its last thread, the
+		// leader exits with 0 by pthread_exit
+		exit.ErrorCode, exit.Signal, exit.CoreDumped = msg.ErrorCode, msg.Signal, msg.CoreDumped
+		readExitTimes(s.procRoot, exit)
+		select {
+		case exitCh <- exit:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// decodeProcEvent decodes cn_msg with struct proc_event into ForkProcMsg,
+// NewProcMsg, ExitProcMsg or threadExitMsg, other events of threads and
+// other events are decoded into nil. Only pids are set in messages.
+func decodeProcEvent(data []byte) (interface{}, error) {
+	if len(data) < cnMsgLen {
+		return nil, fmt.Errorf("Connector message is shorter than header, size is %d.", len(data))
+	}
+	idx, val := binary.LittleEndian.Uint32(data[0:4]), binary.LittleEndian.Uint32(data[4:8])
+	if idx != cnIdxProc || val != cnValProc {
+		return nil, nil
+	}
+	size := int(binary.LittleEndian.Uint16(data[16:18]))
+	data = data[cnMsgLen:]
+	if size > len(data) || size < procEventHdrLen {
+		return nil, fmt.Errorf("Process event unfit to connector message, size is %d, %d bytes left.", size, len(data))
+	}
+	what := binary.LittleEndian.Uint32(data[0:4])
+	event := data[procEventHdrLen:size]
+	u32 := func(i int) uint32 { return binary.LittleEndian.Uint32(event[i*4 : i*4+4]) }
+	need := map[uint32]int{procEventFork: 16, procEventExec: 8, procEventExit: 16}[what]
+	if len(event) < need {
+		return nil, fmt.Errorf("Process event %#x is too short, size is %d, expect %d.", what, len(event), need)
+	}
+
+	switch what {
+	case procEventFork:
+		// parent_pid, parent_tgid, child_pid, child_tgid
+		if u32(2) != u32(3) {
+			return nil, nil
+		}
+		return &ForkProcMsg{Pid: u32(3), Ppid: u32(1)}, nil
+	case procEventExec:
+		// process_pid, process_tgid
+		return &NewProcMsg{Pid: u32(1)}, nil
+	case procEventExit:
+		// process_pid, process_tgid, exit_code, exit_signal, where
+		// exit_code is wait status and exit_signal is sent to parent
+		code := u32(2)
+		exit := &ExitProcMsg{
+			Pid:        u32(1),
+			ErrorCode:  int(code>>8) & 0xff,
+			Signal:     int(code & 0x7f),
+			CoreDumped: code&0x80 != 0,
+		}
+		if u32(0) != u32(1) {
+			return &threadExitMsg{*exit}, nil
+		}
+		return exit, nil
+	}
+	return nil, nil
+}
+
+func readProcComm(root string, pid uint32) string {
+	comm, err := os.ReadFile(filepath.Join(root, fmt.Sprint(pid), "comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(comm))
+}
+
+// readExecProc fills msg of an exec event from root, false is returned if
+// the process has exited.
+func readExecProc(root string, msg *NewProcMsg) bool {
+	dir := filepath.Join(root, fmt.Sprint(msg.Pid))
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return false
+	}
+	st, err := procfs.ParseStat(string(stat))
+	if err != nil {
+		glog.Warningf("Parse stat of process %d error: %s.", msg.Pid, err.Error())
+		return false
+	}
+	cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+	if err != nil {
+		return false
+	}
+	msg.Ppid = st.Ppid
+	msg.Argv = splitCStrings(string(cmdline))
+	// exe is not readable without privilege, argv[0] is used instead
+	if msg.Filename, err = os.Readlink(filepath.Join(dir, "exe")); err != nil && len(msg.Argv) > 0 {
+		msg.Filename = msg.Argv[0]
+	}
+	// environ is not readable without privilege
+	if environ, err := os.ReadFile(filepath.Join(dir, "environ")); err == nil {
+		for _, env := range splitCStrings(string(environ)) {
+			if strings.HasPrefix(env, EnvPrefix) {
+				msg.Envp = append(msg.Envp, env)
+			}
+		}
+	}
+	return true
+}
+
+// threadGroupExited returns true if process pid has been reaped or all its
+// threads have exited.
+func threadGroupExited(root string, pid uint32) bool {
+	taskDir := filepath.Join(root, fmt.Sprint(pid), "task")
+	tasks, err := os.ReadDir(taskDir)
+	if err != nil {
+		return true
+	}
+	for _, task := range tasks {
+		stat, err := os.ReadFile(filepath.Join(taskDir, task.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		st, err := procfs.ParseStat(string(stat))
+		if err != nil {
+			continue
+		}
+		// zombie or dead
+		if st.State != 'Z' && st.State != 'X' {
+			return false
+		}
+	}
+	return true
+}
+
+// readExitTimes fills Ppid, StartTime and Lifetime of msg from root, the
+// exited process is readable until it is reaped. They are left zero if it
+// has been reaped.
+func readExitTimes(root string, msg *ExitProcMsg) {
+	stat, err := os.ReadFile(filepath.Join(root, fmt.Sprint(msg.Pid), "stat"))
+	if err != nil {
+		return
+	}
+	st, err := procfs.ParseStat(string(stat))
+	if err != nil {
+		return
+	}
+	uptime, err := os.ReadFile(filepath.Join(root, "uptime"))
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(string(uptime))
+	if len(fields) == 0 {
+		return
+	}
+	up, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return
+	}
+	msg.Ppid = st.Ppid
+	msg.StartTime = procfs.TicksToDuration(st.StartTime)
+	if now := time.Duration(up * float64(time.Second)); now > msg.StartTime {
+		msg.Lifetime = now - msg.StartTime
+	}
+}
@@ -0,0 +1,193 @@
+package newProcTracing
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// procEventMsg generates cn_msg of proc_event what with fields of union
+func procEventMsg(what uint32, fields ...uint32) []byte {
+	b := make([]byte, cnMsgLen+procEventHdrLen+4*len(fields))
+	binary.LittleEndian.PutUint32(b[0:4], cnIdxProc)
+	binary.LittleEndian.PutUint32(b[4:8], cnValProc)
+	binary.LittleEndian.PutUint16(b[16:18], uint16(len(b)-cnMsgLen))
+	binary.LittleEndian.PutUint32(b[cnMsgLen:], what)
+	for i, f := range fields {
+		binary.LittleEndian.PutUint32(b[cnMsgLen+procEventHdrLen+4*i:], f)
+	}
+	return b
+}
+
+func TestDecodeProcEvent(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     []byte
+		expected interface{}
+	}{
+		{"fork", procEventMsg(procEventFork, 10, 10, 11, 11), &ForkProcMsg{Pid: 11, Ppid: 10}},
+		{"thread", procEventMsg(procEventFork, 10, 10, 12, 10), nil},
+		{"exec", procEventMsg(procEventExec, 11, 11), &NewProcMsg{Pid: 11}},
+		{"exit", procEventMsg(procEventExit, 11, 11, 3<<8, 17), &ExitProcMsg{Pid: 11, ErrorCode: 3}},
+		{"killed", procEventMsg(procEventExit, 11, 11, 0x80|9, 17), &ExitProcMsg{Pid: 11, Signal: 9, CoreDumped: true}},
+		{"thread exit", procEventMsg(procEventExit, 12, 11, 9, 0), &threadExitMsg{ExitProcMsg{Pid: 11, Signal: 9}}},
+		// PROC_EVENT_UID
+		{"other", procEventMsg(0x4, 11, 11, 0, 0), nil},
+	}
+	for _, c := range cases {
+		ev, err := decodeProcEvent(c.data)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err.Error())
+		}
+		if !reflect.DeepEqual(ev, c.expected) {
+			t.Errorf("%s: expected %#v, got %#v", c.name, c.expected, ev)
+		}
+	}
+
+	// ack of PROC_CN_MCAST_LISTEN has no event
+	ack := procEventMsg(0, 0)
+	if ev, err := decodeProcEvent(ack); err != nil || ev != nil {
+		t.Errorf("expected ack ignored, got %#v, %v", ev, err)
+	}
+	for _, data := range [][]byte{
+		procEventMsg(procEventExec, 11, 11)[:cnMsgLen-1],
+		procEventMsg(procEventExec, 11, 11)[:cnMsgLen+procEventHdrLen],
+		procEventMsg(procEventExit, 11, 11),
+	} {
+		if _, err := decodeProcEvent(data); err == nil {
+			t.Errorf("expected error of %v", data)
+		}
+	}
+}
+
+func TestProcCnMcastMsg(t *testing.T) {
+	msgs, err := syscall.ParseNetlinkMessage(procCnMcastMsg(procCnMcastListen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Header.Type != syscall.NLMSG_DONE {
+		t.Fatalf("unexpected messages %+v", msgs)
+	}
+	if msgs[0].Header.Pid != 0 {
+		t.Errorf("Expected port id assigned by kernel, got %d.", msgs[0].Header.Pid)
+	}
+	data := msgs[0].Data
+	if len(data) != cnMsgLen+4 || binary.LittleEndian.Uint32(data[cnMsgLen:]) != procCnMcastListen {
+		t.Errorf("unexpected cn_msg %v", data)
+	}
+}
+
+// writeTask generates thread tid of process pid in root with state
+func writeTask(t *testing.T, root string, pid, tid uint32, state string) {
+	dir := filepath.Join(root, fmt.Sprint(pid), "task", fmt.Sprint(tid))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	stat := fmt.Sprintf("%d (app) %s 1 %d %d 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 1 0 250 1000 10", tid, state, pid, pid)
+	if err := os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestThreadGroupExited(t *testing.T) {
+	root := t.TempDir()
+	if !threadGroupExited(root, 11) {
+		t.Error("Expected reaped process exited.")
+	}
+	// the leader calls pthread_exit
+	writeTask(t, root, 11, 11, "Z")
+	writeTask(t, root, 11, 12, "S")
+	if threadGroupExited(root, 11) {
+		t.Error("Expected process with a running thread alive.")
+	}
+	writeTask(t, root, 11, 12, "X")
+	if !threadGroupExited(root, 11) {
+		t.Error("Expected process without running threads exited.")
+	}
+}
+
+func TestNetlinkThreadGroupExit(t *testing.T) {
+	s := NewNetlinkSource(t.TempDir())
+	exitCh := make(chan *ExitProcMsg, 2)
+	handle := func(data []byte) *ExitProcMsg {
+		s.handle(context.Background(), data, nil, exitCh, nil)
+		select {
+		case exit := <-exitCh:
+			return exit
+		default:
+			return nil
+		}
+	}
+
+	// exit_group of the leader is handled before thread 12 is killed
+	writeTask(t, s.procRoot, 11, 11, "Z")
+	writeTask(t, s.procRoot, 11, 12, "R")
+	if exit := handle(procEventMsg(procEventExit, 11, 11, 9, 17)); exit != nil {
+		t.Fatalf("Expected exit pending, got %+v.", exit)
+	}
+	if err := os.RemoveAll(filepath.Join(s.procRoot, "11", "task", "12")); err != nil {
+		t.Fatal(err)
+	}
+	exit := handle(procEventMsg(procEventExit, 12, 11, 9, 0))
+	if !reflect.DeepEqual(exit, &ExitProcMsg{Pid: 11, Signal: 9}) {
+		t.Errorf("Expected exit reported by the last thread, got %+v.", exit)
+	}
+	if len(s.pending) != 0 {
+		t.Errorf("Expected no pending exit, got %v.", s.pending)
+	}
+
+	// the leader exits last, threads of other processes are ignored
+	if exit := handle(procEventMsg(procEventExit, 14, 13, 0, 0)); exit != nil {
+		t.Errorf("Expected thread exit ignored, got %+v.", exit)
+	}
+	exit = handle(procEventMsg(procEventExit, 13, 13, 3<<8, 17))
+	if !reflect.DeepEqual(exit, &ExitProcMsg{Pid: 13, ErrorCode: 3}) {
+		t.Errorf("Expected exit of leader reported, got %+v.", exit)
+	}
+}
+
+func TestNetlinkReadProc(t *testing.T) {
+	root := t.TempDir()
+	writePollProc(t, root, 12, 10, 250, "/bin/sh", "sh\x00-c\x00ls\x00", "PATH=/bin\x00ABYSS_MONITOR=1\x00")
+	if err := os.WriteFile(filepath.Join(root, "uptime"), []byte("10.50 20.00\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &NewProcMsg{Pid: 12}
+	if !readExecProc(root, msg) {
+		t.Fatal("expected process 12 read")
+	}
+	expected := &NewProcMsg{
+		Pid:      12,
+		Ppid:     10,
+		Filename: "/bin/sh",
+		Argv:     []string{"sh", "-c", "ls"},
+		Envp:     []string{"ABYSS_MONITOR=1"},
+	}
+	if !reflect.DeepEqual(msg, expected) {
+		t.Errorf("expected %+v, got %+v", expected, msg)
+	}
+	if readExecProc(root, &NewProcMsg{Pid: 13}) {
+		t.Error("expected exited process 13 skipped")
+	}
+	if comm := readProcComm(root, 12); comm != "" {
+		t.Errorf("expected empty comm, got %q", comm)
+	}
+
+	exit := &ExitProcMsg{Pid: 12}
+	readExitTimes(root, exit)
+	if exit.Ppid != 10 || exit.StartTime != 2500*time.Millisecond || exit.Lifetime != 8*time.Second {
+		t.Errorf("unexpected exit %+v", exit)
+	}
+	exit = &ExitProcMsg{Pid: 13}
+	readExitTimes(root, exit)
+	if !reflect.DeepEqual(exit, &ExitProcMsg{Pid: 13}) {
+		t.Errorf("expected reaped process unchanged, got %+v", exit)
+	}
+}
@@ -12,39 +12,115 @@ struct {
 	__uint(max_entries, 256 * 1024);
 } new_proc SEC(".maps");
 
-const volatile unsigned long long max_argv_number = 19;
+/* exec_record is too big for stack */
+struct {
+	__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+	__uint(max_entries, 1);
+	__type(key, u32);
+	__type(value, struct exec_record);
+} exec_scratch SEC(".maps");
+
+static __always_inline int has_env_prefix(const char *env)
+{
+	/* compared as an integer to keep branches few for the verifier */
+	union {
+		char c[8];
+		u64 v;
+	} prefix = {}, expected = { .c = ENV_PREFIX };
+
+	if (bpf_probe_read_user(prefix.c, ENV_PREFIX_LEN, env) != 0)
+		return 0;
+	return prefix.v == expected.v;
+}
+
+/* send rec and start the next record of kind */
+static __always_inline void flush_record(struct exec_record *rec, unsigned char kind)
+{
+	unsigned int size = rec->size;
+
+	if (size > EXEC_REC_DATA_LEN)
+		size = EXEC_REC_DATA_LEN;
+	bpf_ringbuf_output(&new_proc, rec, EXEC_REC_HDR_LEN + size, 0);
+	rec->seq++;
+	rec->kind = kind;
+	rec->flags &= ~EXEC_REC_LAST;
+	rec->count = 0;
+	rec->size = 0;
+}
+
+/* append string at user address str into rec, rec is flushed if it may
+ * not hold a string of MAX_STR_LEN */
+static __always_inline void append_str(struct exec_record *rec, const char *str)
+{
+	unsigned int off = rec->size;
+	long len;
+
+	if (off > EXEC_REC_DATA_LEN - MAX_STR_LEN) {
+		flush_record(rec, rec->kind);
+		off = 0;
+	}
+	/* make the verifier know the bound */
+	off &= EXEC_REC_DATA_LEN - 1;
+	if (off > EXEC_REC_DATA_LEN - MAX_STR_LEN)
+		return;
+	len = bpf_probe_read_user_str(&rec->data[off], MAX_STR_LEN, str);
+	if (len <= 0) {
+		rec->flags |= EXEC_REC_TRUNCATED;
+		return;
+	}
+	if (len == MAX_STR_LEN)
+		rec->flags |= EXEC_REC_TRUNCATED;
+	rec->size = off + len;
+	rec->count++;
+}
 
 SEC("tp/syscalls/sys_enter_execve")
 int handle_exec(struct exec_args *ctx)
 {
 	struct task_struct * task;
-	struct process *proc;
+	struct exec_record *rec;
+	u32 zero = 0;
+	int i;
 
-	/* reverse sample from BPF ringbuf */
-	proc = bpf_ringbuf_reserve(&new_proc, sizeof(*proc), 0);
-	if (!proc)
+	rec = bpf_map_lookup_elem(&exec_scratch, &zero);
+	if (!rec)
 		return 0;
 
-	/* fill out the sample with data */
-	proc->pid = bpf_get_current_pid_tgid() >> 32;
-
+	rec->pid = bpf_get_current_pid_tgid() >> 32;
 	task = (struct task_struct *)bpf_get_current_task();
-	proc->ppid = BPF_CORE_READ(task, real_parent, tgid);
-	
-	bpf_probe_read_str(&proc->filename, sizeof(proc->filename),ctx->filename);
-	
-	for (int i = 1; i <= max_argv_number; i++)
-	{
+	rec->ppid = BPF_CORE_READ(task, real_parent, tgid);
+	rec->magic = EXEC_REC_MAGIC;
+	rec->seq = 0;
+	rec->kind = EXEC_REC_FILENAME;
+	rec->flags = 0;
+	rec->count = 0;
+	rec->size = 0;
+	rec->pad = 0;
+
+	append_str(rec, ctx->filename);
+	flush_record(rec, EXEC_REC_ARGV);
+
+	for (i = 0; i < MAX_ARGV_NUM; i++) {
 		const char *arg_ptr = NULL;
-		long res = bpf_probe_read(&arg_ptr, sizeof(arg_ptr), &ctx->argv[i]);
-		if (res != 0) break;
-		bpf_probe_read_str(&proc->argv[i - 1], sizeof(proc->argv[i - 1]), arg_ptr);
+		if (bpf_probe_read_user(&arg_ptr, sizeof(arg_ptr), &ctx->argv[i]) != 0 || !arg_ptr)
+			break;
+		append_str(rec, arg_ptr);
 	}
+	if (i == MAX_ARGV_NUM)
+		rec->flags |= EXEC_REC_TRUNCATED;
+	flush_record(rec, EXEC_REC_ENVP);
 
-	/* successfully submit it to user-space for tracing argv of new peocess */
-	bpf_ringbuf_submit(proc, 0);
-
-	// bpf_printk("execve send a message, size %d.\n", sizeof(*proc));
+	/* copy env entries used to opt in, such as ABYSS_MONITOR=1 */
+	for (i = 0; i < MAX_ENVP_SCAN && rec->count < MAX_ENVP_NUM; i++) {
+		const char *env_ptr = NULL;
+		if (bpf_probe_read_user(&env_ptr, sizeof(env_ptr), &ctx->envp[i]) != 0 || !env_ptr)
+			break;
+		if (!has_env_prefix(env_ptr))
+			continue;
+		append_str(rec, env_ptr);
+	}
+	rec->flags |= EXEC_REC_LAST;
+	flush_record(rec, EXEC_REC_ENVP);
 	return 0;
 }
 
@@ -54,29 +130,73 @@ struct {
 	__uint(max_entries, 4*1024);
 } exit_proc SEC(".maps");
 
+/* keep layout of struct process_exit in BTF to check it against decoder */
+const struct process_exit *unused_exit __attribute__((unused));
+
 SEC("tracepoint/sched/sched_process_exit")
 int handle_exit(struct trace_event_raw_sched_process_template *args)
 {
 	struct task_struct * task;
 	struct process_exit *e;
-	
+	int code;
+
+	task = (struct task_struct *)bpf_get_current_task();
+	/* the tracepoint fires for every thread, and the group leader may
+	 * exit before other threads, so only the last thread is reported */
+	if (BPF_CORE_READ(task, signal, live.counter) != 0)
+		return 0;
+
 	/* reserve buffer in ringbuf */
 	e = bpf_ringbuf_reserve(&exit_proc, sizeof(*e), 0);
 	if (!e)
 		return 0;
-	
+
 	/* fill out reserved ringbuf struct */
 	e->pid = bpf_get_current_pid_tgid() >> 32;
-	
-	task = (struct task_struct *)bpf_get_current_task();
 	e->ppid = BPF_CORE_READ(task, real_parent, tgid);
 
-	e->error_code = BPF_CORE_READ(task, exit_code);
-	e->error_code = e->error_code >> 8;
+	/* same encoding as wait status */
+	code = BPF_CORE_READ(task, exit_code);
+	e->exit_code = (code >> 8) & 0xff;
+	e->exit_signal = code & 0x7f;
+	e->core_dumped = (code & 0x80) != 0;
+	__builtin_memset(e->pad, 0, sizeof(e->pad));
+
+	e->start_time = BPF_CORE_READ(task, group_leader, start_boottime);
+	e->lifetime = bpf_ktime_get_boot_ns() - e->start_time;
 
 	/* submit ringbuf msg */
 	bpf_ringbuf_submit(e, 0);
-	
+
 	// bpf_printk("Exit send a message.");
 	return 0;
 }
+
+/* maps and program used to follow children of monitored processes */
+struct {
+	__uint(type, BPF_MAP_TYPE_RINGBUF);
+	__uint(max_entries, 64*1024);
+} fork_proc SEC(".maps");
+
+const struct process_fork *unused_fork __attribute__((unused));
+
+SEC("raw_tp/sched_process_fork")
+int BPF_PROG(handle_fork, struct task_struct *parent, struct task_struct *child)
+{
+	struct process_fork *f;
+
+	/* new threads are not processes */
+	if (BPF_CORE_READ(child, pid) != BPF_CORE_READ(child, tgid))
+		return 0;
+
+	f = bpf_ringbuf_reserve(&fork_proc, sizeof(*f), 0);
+	if (!f)
+		return 0;
+
+	f->pid = BPF_CORE_READ(child, tgid);
+	f->ppid = BPF_CORE_READ(parent, tgid);
+	BPF_CORE_READ_STR_INTO(&f->comm, child, comm);
+
+	bpf_ringbuf_submit(f, 0);
+	return 0;
+}
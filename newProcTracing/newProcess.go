@@ -1,9 +1,11 @@
 package newProcTracing
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
 	"fmt"
+	"time"
 
 	bpf "github.com/aquasecurity/libbpfgo"
 	glog "github.com/golang/glog"
@@ -11,30 +13,59 @@ import (
 )
 
 const (
-	// const variant for  execve message
-	MaxFileNameLen int = 127
-	MaxArgvNum     int = 19
-	MaxArgvLen     int = 127
-	NewProcMsgSize int = 8 + MaxFileNameLen + MaxArgvLen*MaxArgvNum
-
 	// const variant for exit message
-	ExitProcMsgSize int = 4 * 3
+	ExitProcMsgSize int = 4*4 + 8*2 + 8
+
+	// const variant for fork message
+	TaskCommLen     int = 16
+	ForkProcMsgSize int = 8 + TaskCommLen
 )
 
 // message received from eBPF program attached to
-// tracepoint sys_enter_execve, must sync to
-// struct process in newProcess.h
+// tracepoint sys_enter_execve, assembled from struct exec_record in
+// newProcess.h
 type NewProcMsg struct {
 	Pid      uint32
 	Ppid     uint32
 	Filename string
-	Argv     [][]byte
+	// Argv includes argv[0]
+	Argv []string
+	// Envp are env entries with prefix "ABYSS_", in form of "NAME=value"
+	Envp []string
+	// Truncated is true if some strings are truncated or dropped by the
+	// eBPF program
+	Truncated bool
 }
 
+// message received from eBPF program attached to tracepoint
+// sched_process_exit, only the last thread of a process is sent, must sync
+// to struct process_exit in newProcess.h.
 type ExitProcMsg struct {
-	Pid       uint32
-	Ppid      uint32
+	Pid  uint32
+	Ppid uint32
+	// ErrorCode is the exit status, valid if Signal is 0
 	ErrorCode int
+	// Signal is the signal which killed the process, 0 if it exited
+	Signal     int
+	CoreDumped bool
+	// StartTime is the time since boot when the process started
+	StartTime time.Duration
+	Lifetime  time.Duration
+	// StatusUnknown is true if the exit is not traced by eBPF, such as one
+	// found by rescan, ErrorCode, Signal, CoreDumped and Lifetime are zero
+	StatusUnknown bool
+	// Detached is true if the process is still running but not monitored
+	// any more, such as a child execs a program not followed
+	Detached bool
+}
+
+// message received from eBPF program attached to tracepoint
+// sched_process_fork, only new processes are sent, must sync to struct
+// process_fork in newProcess.h
+type ForkProcMsg struct {
+	Pid  uint32
+	Ppid uint32
+	Comm string
 }
 
 // object loaded from ePBF program, keeped unitl process exit
@@ -44,22 +75,12 @@ type NewProcBPFObjs struct {
 	bpfMaps  []*bpf.RingBuffer
 }
 
-// decode bytes into struct NewProcMsg, len of bytes must equal to NewProcMsgSize
-func DecodeToNewProcMsg(msg []byte) (*NewProcMsg, error) {
-	if len(msg) < NewProcMsgSize {
-		return nil, fmt.Errorf("New process message can not fit into struct NewProcMsg, size of message is %d, expect %d", len(msg), NewProcMsgSize)
-	}
-	res := &NewProcMsg{
-		Pid:      binary.LittleEndian.Uint32(msg[:4]),
-		Ppid:     binary.LittleEndian.Uint32(msg[4:8]),
-		Filename: string(msg[8 : 8+MaxFileNameLen]),
-		Argv:     make([][]byte, MaxArgvNum),
+// cString returns bytes before the first NUL
+func cString(b []byte) string {
+	if idx := bytes.IndexByte(b, 0); idx >= 0 {
+		b = b[:idx]
 	}
-	for i := 0; i < MaxArgvNum; i++ {
-		startOff := 8 + MaxFileNameLen + i*MaxArgvLen
-		res.Argv[i] = msg[startOff : startOff+MaxArgvLen]
-	}
-	return res, nil
+	return string(b)
 }
 
 // decode bytes into struct ExitProcMsg, len of bytes must equal to ExitProcMsgSize
@@ -68,16 +89,40 @@ func DecodeToExitProcMsg(msg []byte) (*ExitProcMsg, error) {
 		return nil, fmt.Errorf("Exit process message unfit to struct ExitProcMsg, size of message is %d, expect %d.", len(msg), ExitProcMsgSize)
 	}
 	res := &ExitProcMsg{
-		Pid:       binary.LittleEndian.Uint32(msg[:4]),
-		Ppid:      binary.LittleEndian.Uint32(msg[4:8]),
-		ErrorCode: int(binary.LittleEndian.Uint32(msg[8:])),
+		Pid:        binary.LittleEndian.Uint32(msg[:4]),
+		Ppid:       binary.LittleEndian.Uint32(msg[4:8]),
+		ErrorCode:  int(binary.LittleEndian.Uint32(msg[8:12])),
+		Signal:     int(binary.LittleEndian.Uint32(msg[12:16])),
+		StartTime:  time.Duration(binary.LittleEndian.Uint64(msg[16:24])),
+		Lifetime:   time.Duration(binary.LittleEndian.Uint64(msg[24:32])),
+		CoreDumped: msg[32] != 0,
 	}
 	return res, nil
 }
 
+// decode bytes into struct ForkProcMsg, len of bytes must equal to ForkProcMsgSize
+func DecodeToForkProcMsg(msg []byte) (*ForkProcMsg, error) {
+	if len(msg) < ForkProcMsgSize {
+		return nil, fmt.Errorf("Fork process message unfit to struct ForkProcMsg, size of message is %d, expect %d.", len(msg), ForkProcMsgSize)
+	}
+	return &ForkProcMsg{
+		Pid:  binary.LittleEndian.Uint32(msg[:4]),
+		Ppid: binary.LittleEndian.Uint32(msg[4:8]),
+		Comm: cString(msg[8:ForkProcMsgSize]),
+	}, nil
+}
+
 // load eBPF program to tracepoint sys_enter_execve and sys_enter_exit
-// need two channel to receive message from eBPF program
-func LoadBpfProgram(ctx context.Context, execMsgCh chan<- *NewProcMsg, exitMsgCh chan<- *ExitProcMsg) (*NewProcBPFObjs, error) {
+// need two channel to receive message from eBPF program. Forks are traced
+// only if forkMsgCh is not nil and the eBPF object has program "handle_fork".
+// The embedded object is loaded if opts is nil.
+func LoadBpfProgram(
+	ctx context.Context,
+	opts *BpfOpts,
+	execMsgCh chan<- *NewProcMsg,
+	exitMsgCh chan<- *ExitProcMsg,
+	forkMsgCh chan<- *ForkProcMsg,
+) (*NewProcBPFObjs, error) {
 	blo := &NewProcBPFObjs{}
 	var (
 		err                    error
@@ -88,7 +133,7 @@ func LoadBpfProgram(ctx context.Context, execMsgCh chan<- *NewProcMsg, exitMsgCh
 		exitRingbuf            *bpf.RingBuffer
 	)
 
-	bpfModule, err = bpf.NewModuleFromFile("/home/ivic/program/abyss/newProcTracing/newProcess.bpf.o")
+	bpfModule, err = newBpfModule(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -141,6 +186,12 @@ func LoadBpfProgram(ctx context.Context, execMsgCh chan<- *NewProcMsg, exitMsgCh
 	go receiveExecMsg(ctx, execByteCh, execMsgCh)
 	go receiveExitMsg(ctx, exitByteCh, exitMsgCh)
 
+	if forkMsgCh != nil {
+		if err := loadForkProgram(ctx, blo, forkMsgCh); err != nil {
+			glog.Warningf("Children of processes are not followed: %s", err.Error())
+		}
+	}
+
 	fmt.Println("Load succeed.")
 	return blo, nil
 
@@ -155,6 +206,29 @@ moduleAndProgErr:
 	return nil, err
 }
 
+// loadForkProgram attaches "handle_fork", which is missing in objects built
+// before forks are traced.
+func loadForkProgram(ctx context.Context, blo *NewProcBPFObjs, forkMsgCh chan<- *ForkProcMsg) error {
+	prog, err := blo.module.GetProgram("handle_fork")
+	if err != nil {
+		return errors.WithMessage(err, "Can not load eBPF program \"handle_fork\".")
+	}
+	if _, err := prog.AttachRawTracepoint("sched_process_fork"); err != nil {
+		return errors.WithMessage(err, "Can not attach program \"handle_fork\" to tracepoint.")
+	}
+	blo.programs = append(blo.programs, prog)
+
+	forkByteCh := make(chan []byte)
+	forkRingbuf, err := blo.module.InitRingBuf("fork_proc", forkByteCh)
+	if err != nil {
+		return err
+	}
+	blo.bpfMaps = append(blo.bpfMaps, forkRingbuf)
+	forkRingbuf.Start()
+	go receiveForkMsg(ctx, forkByteCh, forkMsgCh)
+	return nil
+}
+
 func CloseBpfObject(obj *NewProcBPFObjs) {
 	obj.module.Close()
 	for _, m := range obj.bpfMaps {
@@ -164,14 +238,18 @@ func CloseBpfObject(obj *NewProcBPFObjs) {
 }
 
 func receiveExecMsg(ctx context.Context, mapCh chan []byte, msgCh chan<- *NewProcMsg) {
+	assembler := NewExecAssembler()
 	for {
 		select {
 		case p := <-mapCh:
-			msg, err := DecodeToNewProcMsg(p)
+			msg, err := assembler.Add(p)
 			if err != nil {
 				glog.Warning(err.Error())
 				continue
 			}
+			if msg == nil {
+				continue
+			}
 			msgCh <- msg
 		case <-ctx.Done():
 			glog.Info("Routine \"receiveExecMsg\" exit.\n")
@@ -198,13 +276,30 @@ func receiveExitMsg(ctx context.Context, mapCh chan []byte, msgCh chan<- *ExitPr
 	}
 }
 
+func receiveForkMsg(ctx context.Context, mapCh chan []byte, msgCh chan<- *ForkProcMsg) {
+	for {
+		select {
+		case p := <-mapCh:
+			msg, err := DecodeToForkProcMsg(p)
+			if err != nil {
+				glog.Warning(err.Error())
+				continue
+			}
+			msgCh <- msg
+		case <-ctx.Done():
+			glog.Info("Routine \"receiveForkMsg\" exit.\n")
+			return
+		}
+	}
+}
+
 //func main() {
 //	flag.Parse()
 //
 //	ctx, cancel := context.WithCancel(context.Background())
 //	execCh, exitCh := make(chan *NewProcMsg, 10), make(chan *ExitProcMsg, 10)
 //
-//	obj, err := LoadBpfProgram(ctx, execCh, exitCh)
+//	obj, err := LoadBpfProgram(ctx, nil, execCh, exitCh, nil)
 //	if err != nil {
 //		glog.Error(err.Error())
 //	}
@@ -1,15 +1,43 @@
 #ifndef __NEWPROCESS_
 #define __NEWPROCESS_
 
-#define MAX_FILENAME_LEN 127
-#define MAX_ARGV_NUM 19
-#define MAX_ARGV_LEN 127
+/* an execve is sent as several exec_records of the same pid, strings of
+ * each record are of the same kind, in order of filename, argv and envp,
+ * seq is the index of record and the last one has EXEC_REC_LAST set */
+#define EXEC_REC_MAGIC 0xabe0
+#define EXEC_REC_DATA_LEN 4096
+#define EXEC_REC_HDR_LEN 20
+/* a string longer than MAX_STR_LEN is truncated */
+#define MAX_STR_LEN 1024
+#define MAX_ARGV_NUM 256
 
-struct process {
+#define EXEC_REC_FILENAME 0
+#define EXEC_REC_ARGV 1
+#define EXEC_REC_ENVP 2
+
+#define EXEC_REC_LAST 1
+/* some strings are truncated or dropped */
+#define EXEC_REC_TRUNCATED 2
+
+/* only env entries with ENV_PREFIX are copied */
+#define MAX_ENVP_NUM 4
+/* number of env entries scanned for ENV_PREFIX */
+#define MAX_ENVP_SCAN 64
+#define ENV_PREFIX "ABYSS_"
+#define ENV_PREFIX_LEN 6
+
+struct exec_record {
 	int pid;
 	int ppid;
-	char filename[MAX_FILENAME_LEN];
-	char argv[MAX_ARGV_NUM][MAX_ARGV_LEN];
+	unsigned short magic;
+	unsigned short seq;
+	unsigned char kind;
+	unsigned char flags;
+	/* number of strings and bytes of data */
+	unsigned short count;
+	unsigned short size;
+	unsigned short pad;
+	char data[EXEC_REC_DATA_LEN];
 };
 
 struct exec_args {
@@ -23,10 +51,27 @@ struct exec_args {
 	const char *const *envp;
 };
 
+/* exit of the last thread of a process */
 struct process_exit {
 	int pid;
 	int ppid;
-	int error_code;
+	/* exit status, valid if exit_signal is 0 */
+	int exit_code;
+	/* signal which killed the process, 0 if it exited */
+	int exit_signal;
+	/* nanoseconds since boot when the process started, and how long it ran */
+	unsigned long long start_time;
+	unsigned long long lifetime;
+	unsigned char core_dumped;
+	unsigned char pad[7];
+};
+
+#define TASK_COMM_LEN 16
+
+struct process_fork {
+	int pid;
+	int ppid;
+	char comm[TASK_COMM_LEN];
 };
 
 struct exit_args {
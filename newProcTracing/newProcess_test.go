@@ -0,0 +1,28 @@
+package newProcTracing
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestDecodeToExitProcMsg(t *testing.T) {
+	b := make([]byte, ExitProcMsgSize)
+	binary.LittleEndian.PutUint32(b[0:4], 42)
+	binary.LittleEndian.PutUint32(b[4:8], 1)
+	binary.LittleEndian.PutUint32(b[12:16], 11)
+	binary.LittleEndian.PutUint64(b[16:24], uint64(time.Hour))
+	binary.LittleEndian.PutUint64(b[24:32], uint64(time.Minute))
+	b[32] = 1
+	msg, err := DecodeToExitProcMsg(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ExitProcMsg{Pid: 42, Ppid: 1, Signal: 11, CoreDumped: true, StartTime: time.Hour, Lifetime: time.Minute}
+	if *msg != expected {
+		t.Errorf("Expected %+v, got %+v.", expected, *msg)
+	}
+	if _, err := DecodeToExitProcMsg(b[:12]); err == nil {
+		t.Error("Expected error of short message.")
+	}
+}
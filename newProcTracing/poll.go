@@ -0,0 +1,238 @@
+package newProcTracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	glog "github.com/golang/glog"
+	"wanggj.com/abyss/procfs"
+)
+
+const defaultPollInterval = time.Second
+
+// PollOpts is the options of PollSource, Interval is default 1s and
+// ProcRoot is default "/proc".
+type PollOpts struct {
+	Interval time.Duration
+	ProcRoot string
+}
+
+// pollProc is a process read from ProcRoot, start is in clock ticks after
+// boot and tells processes of the same pid apart.
+type pollProc struct {
+	ppid    uint32
+	start   uint64
+	comm    string
+	exe     string
+	cmdline string
+}
+
+// PollSource finds events of processes by comparing snapshots of ProcRoot,
+// it needs no privilege but misses processes living shorter than
+// Interval. A new process is execed if its exe or cmdline differs from its
+// parent, otherwise only forked. Exits have no details, as exit status
+// cannot be read from ProcRoot.
+type PollSource struct {
+	opts  PollOpts
+	procs map[uint32]*pollProc
+}
+
+func NewPollSource(opts *PollOpts) *PollSource {
+	s := &PollSource{opts: PollOpts{Interval: defaultPollInterval, ProcRoot: "/proc"}}
+	if opts != nil && opts.Interval > 0 {
+		s.opts.Interval = opts.Interval
+	}
+	if opts != nil && opts.ProcRoot != "" {
+		s.opts.ProcRoot = opts.ProcRoot
+	}
+	return s
+}
+
+// Start takes the first snapshot, processes in it are not sent
+func (s *PollSource) Start(
+	ctx context.Context,
+	execCh chan<- *NewProcMsg,
+	exitCh chan<- *ExitProcMsg,
+	forkCh chan<- *ForkProcMsg,
+) error {
+	procs, err := readPollProcs(s.opts.ProcRoot)
+	if err != nil {
+		return err
+	}
+	s.procs = procs
+	go s.run(ctx, execCh, exitCh, forkCh)
+	return nil
+}
+
+func (s *PollSource) Close() {}
+
+func (s *PollSource) run(
+	ctx context.Context,
+	execCh chan<- *NewProcMsg,
+	exitCh chan<- *ExitProcMsg,
+	forkCh chan<- *ForkProcMsg,
+) {
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			glog.Info("Routine \"PollSource\" exit.\n")
+			return
+		}
+		execs, exits, forks, err := s.poll()
+		if err != nil {
+			glog.Warning(err.Error())
+			continue
+		}
+		for _, msg := range exits {
+			select {
+			case exitCh <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for _, msg := range forks {
+			if forkCh == nil {
+				break
+			}
+			select {
+			case forkCh <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for _, msg := range execs {
+			select {
+			case execCh <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// poll takes a new snapshot and returns events since the last one, in
+// order of pid.
+func (s *PollSource) poll() ([]*NewProcMsg, []*ExitProcMsg, []*ForkProcMsg, error) {
+	procs, err := readPollProcs(s.opts.ProcRoot)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	old := s.procs
+	s.procs = procs
+
+	execs, exits, forks := []*NewProcMsg{}, []*ExitProcMsg{}, []*ForkProcMsg{}
+	for _, pid := range sortedPids(old) {
+		p, q := old[pid], procs[pid]
+		// pid may be reused by a new process
+		if q == nil || q.start != p.start {
+			exits = append(exits, &ExitProcMsg{
+				Pid:           pid,
+				Ppid:          p.ppid,
+				StartTime:     procfs.TicksToDuration(p.start),
+				StatusUnknown: true,
+			})
+		}
+	}
+	for _, pid := range sortedPids(procs) {
+		p := procs[pid]
+		if q, ok := old[pid]; ok && q.start == p.start {
+			if q.exe != p.exe || q.cmdline != p.cmdline {
+				execs = append(execs, s.execMsg(pid, p))
+			}
+			continue
+		}
+		forks = append(forks, &ForkProcMsg{Pid: pid, Ppid: p.ppid, Comm: p.comm})
+		parent, ok := procs[p.ppid]
+		if !ok {
+			parent, ok = old[p.ppid]
+		}
+		if !ok || parent.exe != p.exe || parent.cmdline != p.cmdline {
+			execs = append(execs, s.execMsg(pid, p))
+		}
+	}
+	return execs, exits, forks, nil
+}
+
+// execMsg generates NewProcMsg of p, env entries are read only for execs
+func (s *PollSource) execMsg(pid uint32, p *pollProc) *NewProcMsg {
+	msg := &NewProcMsg{
+		Pid:      pid,
+		Ppid:     p.ppid,
+		Filename: p.exe,
+		Argv:     splitCStrings(p.cmdline),
+	}
+	// environ is not readable without privilege
+	environ, err := os.ReadFile(filepath.Join(s.opts.ProcRoot, fmt.Sprint(pid), "environ"))
+	if err == nil {
+		for _, env := range splitCStrings(string(environ)) {
+			if strings.HasPrefix(env, EnvPrefix) {
+				msg.Envp = append(msg.Envp, env)
+			}
+		}
+	}
+	return msg
+}
+
+// EnvPrefix is the prefix of env entries captured, see ENV_PREFIX in
+// newProcess.h
+const EnvPrefix = "ABYSS_"
+
+// splitCStrings splits NUL terminated strings
+func splitCStrings(s string) []string {
+	s = strings.TrimRight(s, "\x00")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\x00")
+}
+
+func sortedPids(procs map[uint32]*pollProc) []uint32 {
+	pids := make([]uint32, 0, len(procs))
+	for pid := range procs {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	return pids
+}
+
+// readPollProcs reads all processes in root, processes exited during
+// reading are skipped.
+func readPollProcs(root string) (map[uint32]*pollProc, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	procs := map[uint32]*pollProc{}
+	for _, e := range entries {
+		pid, err := strconv.ParseUint(e.Name(), 10, 32)
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, e.Name())
+		stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+		if err != nil {
+			continue
+		}
+		st, err := procfs.ParseStat(string(stat))
+		if err != nil {
+			glog.Warningf("Parse stat of process %d error: %s.", pid, err.Error())
+			continue
+		}
+		p := &pollProc{ppid: st.Ppid, start: st.StartTime, comm: st.Comm}
+		// kernel threads have no exe and cmdline
+		p.exe, _ = os.Readlink(filepath.Join(dir, "exe"))
+		cmdline, _ := os.ReadFile(filepath.Join(dir, "cmdline"))
+		p.cmdline = string(cmdline)
+		procs[uint32(pid)] = p
+	}
+	return procs, nil
+}
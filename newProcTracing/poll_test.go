@@ -0,0 +1,116 @@
+package newProcTracing
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writePollProc generates process pid in root
+func writePollProc(t *testing.T, root string, pid, ppid uint32, start uint64, exe, cmdline, environ string) {
+	dir := filepath.Join(root, fmt.Sprint(pid))
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"stat":    fmt.Sprintf("%d (%s) S %d %d %d 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 1 0 %d 1000 10", pid, filepath.Base(exe), ppid, pid, pid, start),
+		"cmdline": cmdline,
+		"environ": environ,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(exe, filepath.Join(dir, "exe")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPollSource(t *testing.T) {
+	root := t.TempDir()
+	writePollProc(t, root, 1, 0, 1, "/sbin/init", "/sbin/init\x00", "")
+	writePollProc(t, root, 10, 1, 100, "/bin/app", "app\x00-v\x00", "")
+	s := NewPollSource(&PollOpts{ProcRoot: root})
+	procs, err := readPollProcs(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.procs = procs
+
+	// 11 is a worker forked by 10, 12 is execed by 10
+	writePollProc(t, root, 11, 10, 200, "/bin/app", "app\x00-v\x00", "")
+	writePollProc(t, root, 12, 10, 201, "/bin/sh", "sh\x00-c\x00ls\x00", "PATH=/bin\x00ABYSS_MONITOR=1\x00")
+	execs, exits, forks, err := s.poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedForks := []*ForkProcMsg{{Pid: 11, Ppid: 10, Comm: "app"}, {Pid: 12, Ppid: 10, Comm: "sh"}}
+	expectedExecs := []*NewProcMsg{{
+		Pid:      12,
+		Ppid:     10,
+		Filename: "/bin/sh",
+		Argv:     []string{"sh", "-c", "ls"},
+		Envp:     []string{"ABYSS_MONITOR=1"},
+	}}
+	if !reflect.DeepEqual(forks, expectedForks) || !reflect.DeepEqual(execs, expectedExecs) || len(exits) != 0 {
+		t.Errorf("Unexpected events %v, %v and %v.", execs, exits, forks)
+	}
+
+	// 11 execs another program, 10 exits and pid 12 is reused
+	writePollProc(t, root, 11, 10, 200, "/bin/cat", "cat\x00", "")
+	writePollProc(t, root, 12, 1, 300, "/bin/sh", "sh\x00", "")
+	if err := os.RemoveAll(filepath.Join(root, "10")); err != nil {
+		t.Fatal(err)
+	}
+	execs, exits, forks, err = s.poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// start times identify the exited processes, not the one reusing pid 12
+	expectedExits := []*ExitProcMsg{
+		{Pid: 10, Ppid: 1, StartTime: time.Second, StatusUnknown: true},
+		{Pid: 12, Ppid: 10, StartTime: 2010 * time.Millisecond, StatusUnknown: true},
+	}
+	if !reflect.DeepEqual(exits, expectedExits) {
+		t.Errorf("Expected exits %v, got %v.", expectedExits, exits)
+	}
+	if len(forks) != 1 || forks[0].Pid != 12 {
+		t.Errorf("Expected fork of reused pid 12, got %v.", forks)
+	}
+	if len(execs) != 2 || execs[0].Pid != 11 || execs[0].Filename != "/bin/cat" || execs[1].Pid != 12 {
+		t.Errorf("Expected execs of 11 and 12, got %v.", execs)
+	}
+
+	// nothing changed
+	if execs, exits, forks, err = s.poll(); err != nil || len(execs)+len(exits)+len(forks) != 0 {
+		t.Errorf("Expected no event, got %v, %v, %v and %v.", execs, exits, forks, err)
+	}
+}
+
+func TestNewEventSource(t *testing.T) {
+	for name, expected := range map[string]EventSource{
+		"":            &BpfSource{},
+		SourceBpf:     &BpfSource{},
+		SourceProc:    NewPollSource(nil),
+		SourceNetlink: NewNetlinkSource(""),
+	} {
+		s, err := NewEventSource(name, nil, nil)
+		if err != nil || reflect.TypeOf(s) != reflect.TypeOf(expected) {
+			t.Errorf("Expected %T of %q, got %T and %v.", expected, name, s, err)
+		}
+	}
+	if _, err := NewEventSource("nonexist", nil, nil); err == nil {
+		t.Error("Expected error of unknown source.")
+	}
+	s, err := NewEventSource(SourceBpf, nil, &BpfOpts{ObjPath: "newProcess.bpf.o"})
+	if err != nil || s.(*BpfSource).opts.ObjPath != "newProcess.bpf.o" {
+		t.Errorf("Expected object path of BpfSource, got %+v and %v.", s, err)
+	}
+}
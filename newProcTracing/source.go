@@ -0,0 +1,139 @@
+package newProcTracing
+
+import (
+	"context"
+	"fmt"
+)
+
+// EventSource delivers events of processes. Messages are sent into channels
+// given to Start until ctx is done, forkCh may be nil if forks are not
+// needed. Close releases resources after ctx is done.
+type EventSource interface {
+	Start(
+		ctx context.Context,
+		execCh chan<- *NewProcMsg,
+		exitCh chan<- *ExitProcMsg,
+		forkCh chan<- *ForkProcMsg,
+	) error
+	Close()
+}
+
+// names of sources selected by config
+const (
+	SourceBpf     = "ebpf"
+	SourceProc    = "proc"
+	SourceNetlink = "netlink"
+)
+
+// NewEventSource returns the source of name, SourceBpf is used if name is
+// empty. opts is used by SourceProc, and its ProcRoot by SourceNetlink.
+// bpfOpts is used by SourceBpf only.
+func NewEventSource(name string, opts *PollOpts, bpfOpts *BpfOpts) (EventSource, error) {
+	switch name {
+	case "", SourceBpf:
+		s := &BpfSource{}
+		if bpfOpts != nil {
+			s.opts = *bpfOpts
+		}
+		return s, nil
+	case SourceProc:
+		return NewPollSource(opts), nil
+	case SourceNetlink:
+		if opts == nil {
+			return NewNetlinkSource(""), nil
+		}
+		return NewNetlinkSource(opts.ProcRoot), nil
+	default:
+		return nil, fmt.Errorf("Unknown process event source %q.", name)
+	}
+}
+
+// BpfSource traces processes by eBPF programs in newProcess.bpf.o, it needs
+// CAP_BPF and a kernel with ringbuf and BTF.
+type BpfSource struct {
+	opts BpfOpts
+	obj  *NewProcBPFObjs
+}
+
+func (s *BpfSource) Start(
+	ctx context.Context,
+	execCh chan<- *NewProcMsg,
+	exitCh chan<- *ExitProcMsg,
+	forkCh chan<- *ForkProcMsg,
+) error {
+	obj, err := LoadBpfProgram(ctx, &s.opts, execCh, exitCh, forkCh)
+	if err != nil {
+		return err
+	}
+	s.obj = obj
+	return nil
+}
+
+func (s *BpfSource) Close() {
+	if s.obj != nil {
+		CloseBpfObject(s.obj)
+		s.obj = nil
+	}
+}
+
+// FakeSource is an in-memory source for tests, messages given to Exec,
+// Exit and Fork are sent as they are. They block until the message is
+// received, and must be called after Start.
+type FakeSource struct {
+	ctx    context.Context
+	execCh chan<- *NewProcMsg
+	exitCh chan<- *ExitProcMsg
+	forkCh chan<- *ForkProcMsg
+	// started is closed by Start
+	started chan struct{}
+}
+
+func NewFakeSource() *FakeSource {
+	return &FakeSource{started: make(chan struct{})}
+}
+
+func (s *FakeSource) Start(
+	ctx context.Context,
+	execCh chan<- *NewProcMsg,
+	exitCh chan<- *ExitProcMsg,
+	forkCh chan<- *ForkProcMsg,
+) error {
+	s.ctx, s.execCh, s.exitCh, s.forkCh = ctx, execCh, exitCh, forkCh
+	close(s.started)
+	return nil
+}
+
+func (s *FakeSource) Close() {}
+
+// Started returns a channel closed after Start
+func (s *FakeSource) Started() <-chan struct{} {
+	return s.started
+}
+
+func (s *FakeSource) Exec(msg *NewProcMsg) {
+	<-s.started
+	select {
+	case s.execCh <- msg:
+	case <-s.ctx.Done():
+	}
+}
+
+func (s *FakeSource) Exit(msg *ExitProcMsg) {
+	<-s.started
+	select {
+	case s.exitCh <- msg:
+	case <-s.ctx.Done():
+	}
+}
+
+// Fork drops msg if forks are not needed
+func (s *FakeSource) Fork(msg *ForkProcMsg) {
+	<-s.started
+	if s.forkCh == nil {
+		return
+	}
+	select {
+	case s.forkCh <- msg:
+	case <-s.ctx.Done():
+	}
+}
@@ -0,0 +1,102 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	analyzer "wanggj.com/abyss/analyzers"
+)
+
+const (
+	defaultCommandTimeout = 10 * time.Second
+)
+
+// CommandOpts is used to generate CommandSink. Notification is passed to
+// the command by environment variables:
+//
+//	ABYSS_ALERT_METRIC, ABYSS_ALERT_VALUE, ABYSS_ALERT_RULE, ABYSS_ALERT_LEVEL,
+//	ABYSS_ALERT_STATE, ABYSS_ALERT_TIMESTAMP(unix seconds),
+//	ABYSS_ALERT_FIRING_START(unix seconds),
+//	ABYSS_ALERT_FIRING_DURATION(seconds), ABYSS_ALERT_LABELS(JSON)
+//	ABYSS_LABEL_<NAME> for each label
+//
+// and the JSON payload is written into its stdin.
+type CommandOpts struct {
+	Path    string        `yaml:"path"`
+	Args    []string      `yaml:"args,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+type CommandSink struct {
+	path    string
+	args    []string
+	timeout time.Duration
+}
+
+func NewCommandSink(opt *CommandOpts) (*CommandSink, error) {
+	if opt.Path == "" {
+		return nil, fmt.Errorf("Command path must not be empty.")
+	}
+	path, err := exec.LookPath(opt.Path)
+	if err != nil {
+		return nil, err
+	}
+	timeout := opt.Timeout
+	if timeout <= 0 {
+		timeout = defaultCommandTimeout
+	}
+	return &CommandSink{
+		path:    path,
+		args:    opt.Args,
+		timeout: timeout,
+	}, nil
+}
+
+func commandEnv(n *analyzer.Notification) ([]string, error) {
+	labels, err := json.Marshal(n.Labels)
+	if err != nil {
+		return nil, err
+	}
+	env := []string{
+		"ABYSS_ALERT_METRIC=" + n.Metric,
+		fmt.Sprintf("ABYSS_ALERT_VALUE=%g", n.Value),
+		"ABYSS_ALERT_RULE=" + n.Rule,
+		fmt.Sprintf("ABYSS_ALERT_LEVEL=%d", n.Level),
+		"ABYSS_ALERT_STATE=" + n.State,
+		fmt.Sprintf("ABYSS_ALERT_TIMESTAMP=%d", n.Timestamp.Unix()),
+		fmt.Sprintf("ABYSS_ALERT_FIRING_START=%d", n.FiringStart.Unix()),
+		fmt.Sprintf("ABYSS_ALERT_FIRING_DURATION=%d", int64(n.FiringDuration.Seconds())),
+		"ABYSS_ALERT_LABELS=" + string(labels),
+	}
+	for k, v := range n.Labels {
+		env = append(env, "ABYSS_LABEL_"+strings.ToUpper(k)+"="+v)
+	}
+	return env, nil
+}
+
+func (c *CommandSink) Send(ctx context.Context, n *analyzer.Notification) error {
+	env, err := commandEnv(n)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.path, c.args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(payload)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Command %s error: %s, output: %q.", c.path, err.Error(), out)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	analyzer "wanggj.com/abyss/analyzers"
+)
+
+// FileOpts is used to generate FileSink, Notifications are appended into
+// Path as JSON lines.
+type FileOpts struct {
+	Path string `yaml:"path"`
+}
+
+type FileSink struct {
+	file *os.File
+	mtx  sync.Mutex
+}
+
+func NewFileSink(opt *FileOpts) (*FileSink, error) {
+	if opt.Path == "" {
+		return nil, fmt.Errorf("File path must not be empty.")
+	}
+	f, err := os.OpenFile(opt.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: f}, nil
+}
+
+func (f *FileSink) Send(ctx context.Context, n *analyzer.Notification) error {
+	line, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	_, err = f.file.Write(line)
+	return err
+}
+
+func (f *FileSink) Close() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.file.Close()
+}
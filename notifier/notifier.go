@@ -0,0 +1,191 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	analyzer "wanggj.com/abyss/analyzers"
+	"wanggj.com/abyss/collector"
+)
+
+const (
+	sinkQueueLen         = 64
+	defaultRetryInterval = time.Second
+)
+
+// Sink sends Notification to somewhere outside abyss, Send is called in a
+// routine owned by the sink, it will be retried if error returned.
+type Sink interface {
+	Send(context.Context, *analyzer.Notification) error
+}
+
+// SinkConfig is the config of a sink, Opt is decoded depending on Type:
+//
+//	webhook: WebhookOpts
+//	command: CommandOpts
+//	file: FileOpts
+//
+// Only Notifications whose level is no less than MinLevel are sent, failed
+// Send is retried Retry times with RetryInterval between.
+type SinkConfig struct {
+	Type          string              `yaml:"type"`
+	MinLevel      int                 `yaml:"minLevel,omitempty"`
+	Retry         int                 `yaml:"retry,omitempty"`
+	RetryInterval time.Duration       `yaml:"retryInterval,omitempty"`
+	Opt           analyzer.RawMessage `yaml:"opt"`
+}
+
+// NewSinkFromConfig generates Sink depending on config.Type
+func NewSinkFromConfig(config SinkConfig) (Sink, error) {
+	var (
+		result Sink
+		err    error
+	)
+	switch config.Type {
+	case "webhook":
+		opt := WebhookOpts{}
+		if err = config.Opt.Unmarshal(&opt); err != nil {
+			break
+		}
+		result, err = NewWebhookSink(&opt)
+	case "command":
+		opt := CommandOpts{}
+		if err = config.Opt.Unmarshal(&opt); err != nil {
+			break
+		}
+		result, err = NewCommandSink(&opt)
+	case "file":
+		opt := FileOpts{}
+		if err = config.Opt.Unmarshal(&opt); err != nil {
+			break
+		}
+		result, err = NewFileSink(&opt)
+	default:
+		err = fmt.Errorf("Unrecongnized sink type %q", config.Type)
+	}
+	return result, err
+}
+
+// sinkWorker owns a routine sending Notifications to a sink
+type sinkWorker struct {
+	name          string
+	sink          Sink
+	minLevel      int
+	retry         int
+	retryInterval time.Duration
+	queue         chan *analyzer.Notification
+}
+
+func (w *sinkWorker) run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case n := <-w.queue:
+			w.send(ctx, n)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *sinkWorker) send(ctx context.Context, n *analyzer.Notification) {
+	var err error
+	for i := 0; i <= w.retry; i++ {
+		if err = w.sink.Send(ctx, n); err == nil {
+			return
+		}
+		glog.Warningf("Sink %s send notification error: %s.", w.name, err.Error())
+		if i == w.retry {
+			break
+		}
+		select {
+		case <-time.After(w.retryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+	glog.Errorf("Sink %s drop notification of %s after %d retries.", w.name, n.Metric, w.retry)
+}
+
+// Notifier dispatches Notifications to all sinks, it implements
+// analyzer.Notifier. Each sink has its own queue and routine, so a slow sink
+// never blocks others, Notification is dropped if the queue is full.
+type Notifier struct {
+	workers []*sinkWorker
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func NewNotifier(configs []SinkConfig) (*Notifier, error) {
+	errs := collector.MultiError{}
+	n := &Notifier{}
+	for idx, cfg := range configs {
+		if cfg.Retry < 0 {
+			errs.Append(fmt.Errorf("Init %dth sink error: retry must not be negative.", idx))
+			continue
+		}
+		sink, err := NewSinkFromConfig(cfg)
+		if err != nil {
+			errs.Append(fmt.Errorf("Init %dth sink error: %s", idx, err.Error()))
+			continue
+		}
+		interval := cfg.RetryInterval
+		if interval <= 0 {
+			interval = defaultRetryInterval
+		}
+		n.workers = append(n.workers, &sinkWorker{
+			name:          fmt.Sprintf("%d(%s)", idx, cfg.Type),
+			sink:          sink,
+			minLevel:      cfg.MinLevel,
+			retry:         cfg.Retry,
+			retryInterval: interval,
+			queue:         make(chan *analyzer.Notification, sinkQueueLen),
+		})
+	}
+	if len(errs) > 0 {
+		for _, w := range n.workers {
+			closeSink(w.sink)
+		}
+		return nil, errs
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n.cancel = cancel
+	n.wg.Add(len(n.workers))
+	for _, w := range n.workers {
+		go w.run(ctx, &n.wg)
+	}
+	return n, nil
+}
+
+func (n *Notifier) Notify(msg *analyzer.Notification) {
+	for _, w := range n.workers {
+		if msg.Level < w.minLevel {
+			continue
+		}
+		select {
+		case w.queue <- msg:
+		default:
+			glog.Warningf("Sink %s queue is full, drop notification of %s.", w.name, msg.Metric)
+		}
+	}
+}
+
+// Close stops all sink routines, Notifications in queues are dropped.
+func (n *Notifier) Close() {
+	n.cancel()
+	n.wg.Wait()
+	for _, w := range n.workers {
+		closeSink(w.sink)
+	}
+}
+
+// closeSink closes sink if it holds resources, such as FileSink
+func closeSink(sink Sink) {
+	if c, ok := sink.(interface{ Close() error }); ok {
+		c.Close()
+	}
+}
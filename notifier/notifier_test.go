@@ -0,0 +1,248 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	analyzer "wanggj.com/abyss/analyzers"
+	"wanggj.com/abyss/collector"
+	"wanggj.com/abyss/collector/pushFunc"
+)
+
+func testNotification(level int) *analyzer.Notification {
+	return &analyzer.Notification{
+		Metric:      "latency(alert)",
+		Labels:      map[string]string{"PID": "111", "state": "firing"},
+		Value:       12.5,
+		Rule:        "bigger:10:3",
+		Level:       level,
+		State:       "firing",
+		Timestamp:   time.Now(),
+		FiringStart: time.Now(),
+	}
+}
+
+// waitFor waits until cond is true or timeout
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout when waiting for notification.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	var (
+		mtx      sync.Mutex
+		requests int
+		received []*analyzer.Notification
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.Header.Get("X-Token") != "abc" {
+			t.Errorf("Header not set, got %q.", r.Header.Get("X-Token"))
+		}
+		n := &analyzer.Notification{}
+		if err := json.NewDecoder(r.Body).Decode(n); err != nil {
+			t.Error(err)
+		}
+		received = append(received, n)
+	}))
+	defer server.Close()
+
+	cfg := `
+- type: webhook
+  minLevel: 3
+  retry: 2
+  retryInterval: 10ms
+  opt:
+    url: ` + server.URL + `
+    headers:
+      X-Token: abc
+`
+	var cfgs []SinkConfig
+	if err := yaml.Unmarshal([]byte(cfg), &cfgs); err != nil {
+		t.Fatal(err)
+	}
+	n, err := NewNotifier(cfgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	// filtered by level
+	n.Notify(testNotification(2))
+	n.Notify(testNotification(3))
+	waitFor(t, func() bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return len(received) == 1
+	})
+	mtx.Lock()
+	defer mtx.Unlock()
+	if requests != 2 {
+		t.Errorf("Expected 2 requests with a retry, got %d.", requests)
+	}
+	if r := received[0]; r.Metric != "latency(alert)" || r.Rule != "bigger:10:3" || r.Level != 3 || r.Labels["PID"] != "111" {
+		t.Errorf("Received wrong notification %+v.", *r)
+	}
+}
+
+func TestCommandSink(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	sink, err := NewCommandSink(&CommandOpts{
+		Path: "sh",
+		Args: []string{"-c", `echo "$ABYSS_ALERT_METRIC $ABYSS_ALERT_LEVEL $ABYSS_LABEL_PID" > ` + out},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testNotification(4)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "latency(alert) 4 111" {
+		t.Errorf("Command got wrong env %q.", got)
+	}
+
+	if _, err := NewCommandSink(&CommandOpts{Path: filepath.Join(dir, "nonexist")}); err == nil {
+		t.Error("Expected error of nonexistent command.")
+	}
+}
+
+func TestFileSinkWithAlert(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.jsonl")
+	n, err := NewNotifier([]SinkConfig{{
+		Type: "file",
+		Opt:  rawOpt(t, "path: "+path),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	analyzer.SetNotifier(n)
+	defer analyzer.SetNotifier(nil)
+
+	// send by an analyzer alert
+	qa, err := analyzer.NewQuatileAna(&analyzer.QuantileOpts{
+		Opts: collector.Opts{
+			Name:  "quantile",
+			Help:  "this is quantile",
+			Level: collector.LevelInfo,
+		},
+		Ranks: map[float64]*analyzer.AlertRule{
+			0.5: {Op: ">", Value: 1, Level: 3},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan collector.Metric, 10)
+	qa.Analyze([]*pushFunc.DataPair{pushFunc.NewDataPair(5, time.Now())}, ch)
+
+	waitFor(t, func() bool {
+		info, err := os.Stat(path)
+		return err == nil && info.Size() > 0
+	})
+	n.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		msg := &analyzer.Notification{}
+		if err := json.Unmarshal(scanner.Bytes(), msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Metric != "quantile(alert)" || msg.State != "firing" || msg.Labels["quantile_rank"] != "0.5" {
+			t.Errorf("Got wrong notification %+v.", *msg)
+		}
+		lines++
+	}
+	if lines != 1 {
+		t.Errorf("Expected 1 line, got %d.", lines)
+	}
+}
+
+// rawOpt generates analyzer.RawMessage from yaml string
+func rawOpt(t *testing.T, str string) analyzer.RawMessage {
+	var cfg struct {
+		Opt analyzer.RawMessage `yaml:"opt"`
+	}
+	if err := yaml.Unmarshal([]byte("opt:\n  "+str), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	return cfg.Opt
+}
+
+func TestSinkConfigError(t *testing.T) {
+	cfgs := []SinkConfig{
+		{Type: "mail"},
+	}
+	if _, err := NewNotifier(cfgs); err == nil {
+		t.Error("Expected error of unknown sink type.")
+	}
+
+	// sinks built before the error are closed
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip(err)
+	}
+	cfgs = []SinkConfig{
+		{Type: "file", Opt: rawOpt(t, "path: "+filepath.Join(t.TempDir(), "alerts.log"))},
+		{Type: "mail"},
+	}
+	if _, err := NewNotifier(cfgs); err == nil {
+		t.Fatal("Expected error of unknown sink type.")
+	}
+	if after, _ := os.ReadDir("/proc/self/fd"); len(after) != len(fds) {
+		t.Errorf("Expected file sink closed, %d fds before, %d after.", len(fds), len(after))
+	}
+}
+
+// failSink always fails
+type failSink struct {
+	sent int
+}
+
+func (f *failSink) Send(context.Context, *analyzer.Notification) error {
+	f.sent++
+	return fmt.Errorf("Send failed.")
+}
+
+func TestSinkRetryInterval(t *testing.T) {
+	sink := &failSink{}
+	w := &sinkWorker{name: "fail", sink: sink, retry: 1, retryInterval: 200 * time.Millisecond}
+	start := time.Now()
+	w.send(context.Background(), testNotification(3))
+	// only sleep between the 2 attempts
+	if elapsed := time.Since(start); sink.sent != 2 || elapsed >= 400*time.Millisecond {
+		t.Errorf("Expected 2 attempts in one interval, got %d in %s.", sink.sent, elapsed)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	analyzer "wanggj.com/abyss/analyzers"
 	"wanggj.com/abyss/collector"
 	"wanggj.com/abyss/module"
+	"wanggj.com/abyss/state"
 )
 
 // ProcRegistry is used to register collectors and pushers.
@@ -25,6 +26,26 @@ type ProcRegistry struct {
 
 	// otherCollectors is all collectors can use pull module
 	pullerByName map[string]collector.Collector
+
+	// persistent are analyzers whose states are saved, keyed by
+	// PusherStateName and then the key of analyzer in the pusher
+	persistent map[string]map[string]collector.PersistentAnalyzer
+	// pusherByCfgName is keyed by name of pusher in config, it is nil if the
+	// name is duplicated
+	pusherByCfgName map[string]*collector.Pusher
+	// Identity is set if states need to be saved
+	Identity *state.ProcIdentity
+	// ConstLabels are added into all metrics gathered, such as PPID of
+	// inherited children
+	ConstLabels collector.Labels
+	// metaLabels are labels given to NewProcRegWithLabels, which are in
+	// metrics already and added into the exit event
+	metaLabels collector.Labels
+}
+
+// exitLabels returns labels of the exit event of the process
+func (p *ProcRegistry) exitLabels() collector.Labels {
+	return mergeLabels(p.metaLabels, p.ConstLabels)
 }
 
 // func PullerReg is used to registry a collector, which dose not
@@ -79,7 +100,115 @@ func (p *ProcRegistry) PusherUnreg(name string) {
 
 // func Gather is used to Gather module.MetricFamily from registry
 func (p *ProcRegistry) Gather() (map[int][]*module.MetricFamily, error) {
-	return p.registry.Gather()
+	mfs, err := p.registry.Gather()
+	if len(p.ConstLabels) == 0 {
+		return mfs, err
+	}
+	pairs := make([]*module.LabelPair, 0, len(p.ConstLabels))
+	for n, v := range p.ConstLabels {
+		name, value := n, v
+		pairs = append(pairs, &module.LabelPair{Name: &name, Value: &value})
+	}
+	for _, l := range mfs {
+		for _, mf := range l {
+			for _, m := range mf.Metric {
+				m.Label = append(m.Label, pairs...)
+			}
+		}
+	}
+	return mfs, err
+}
+
+// SaveState saves states of persistent analyzers into store
+func (p *ProcRegistry) SaveState(store *state.Store) error {
+	if p.Identity == nil {
+		return nil
+	}
+	errs := collector.MultiError{}
+	for pusher, anas := range p.persistent {
+		states := make(map[string][]byte, len(anas))
+		for key, a := range anas {
+			data, err := a.MarshalState()
+			if err != nil {
+				errs.Append(fmt.Errorf("Save state of %s in %s error: %s.", key, pusher, err.Error()))
+				continue
+			}
+			states[key] = data
+		}
+		errs.Append(store.Save(p.Identity, pusher, states))
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// RestoreState restores states saved by SaveState, it should be called
+// before Start.
+func (p *ProcRegistry) RestoreState(store *state.Store) error {
+	if p.Identity == nil {
+		return nil
+	}
+	errs := collector.MultiError{}
+	for pusher, anas := range p.persistent {
+		states, err := store.Load(p.Identity, pusher)
+		if err != nil {
+			errs.Append(err)
+			continue
+		}
+		for key, data := range states {
+			a, ok := anas[key]
+			if !ok {
+				continue
+			}
+			if err := a.UnmarshalState(data); err != nil {
+				errs.Append(fmt.Errorf("Restore state of %s in %s error: %s.", key, pusher, err.Error()))
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// JoinGroups adds the process into groups of cfgs, exe and config are paths
+// of its executable and config file. It must be called before Start, and
+// groups.Leave should be called when the process exits.
+func (p *ProcRegistry) JoinGroups(
+	groups *analyzer.GroupRegistry,
+	key ProcKey,
+	exe, config string,
+	cfgs []analyzer.GroupConfig,
+) error {
+	errs := collector.MultiError{}
+	for idx := range cfgs {
+		cfg := &cfgs[idx]
+		pu, ok := p.pusherByCfgName[cfg.Pusher]
+		if !ok {
+			errs.Append(fmt.Errorf("Pusher %s of group not found.", cfg.Pusher))
+			continue
+		}
+		if pu == nil {
+			errs.Append(fmt.Errorf("Pusher name %s is duplicated, cannot be referred by group.", cfg.Pusher))
+			continue
+		}
+		value, err := cfg.GroupValue(exe, config)
+		if err != nil {
+			errs.Append(err)
+			continue
+		}
+		tap, err := groups.Join(key, value, cfg)
+		if err != nil {
+			errs.Append(err)
+			continue
+		}
+		pu.Tap(tap)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
 }
 
 // func Start initialize the pushers, which need to start the pushFunc to
@@ -104,40 +233,196 @@ type PusherConfig struct {
 	SfAna                []analyzer.AnaConfig `yaml:"sfana,omitempty"`
 }
 
+// NewPusherFromConfig generates the pusher, analyzers with Input are added
+// as stages of the pipeline fed by their upstream analyzers.
 func NewPusherFromConfig(pid uint32, pc *PusherConfig) (*collector.Pusher, error) {
+	pu, _, err := newPusherFromConfig(pid, pc, nil)
+	return pu, err
+}
+
+// mergeLabels returns a new Labels with labels of all ls, later ones take
+// precedence.
+func mergeLabels(ls ...collector.Labels) collector.Labels {
+	res := collector.Labels{}
+	for _, l := range ls {
+		for n, v := range l {
+			res[n] = v
+		}
+	}
+	return res
+}
+
+// anaStateKey identifies state of an analyzer in a pusher, list is slana or
+// sfana, and idx is the index of cfg in the list.
+func anaStateKey(list string, idx int, cfg *analyzer.AnaConfig) string {
+	if cfg.Name != "" {
+		return cfg.Name
+	}
+	return fmt.Sprintf("%s.%d.%s", list, idx, cfg.Type)
+}
+
+// newPusherFromConfig generates the pusher and returns analyzers need to
+// be persisted keyed by anaStateKey. labels are added into the pusher and
+// all its analyzers.
+func newPusherFromConfig(
+	pid uint32,
+	pc *PusherConfig,
+	labels collector.Labels,
+) (*collector.Pusher, map[string]collector.PersistentAnalyzer, error) {
 	sla, sfa := []collector.StatelessAnalyzer{}, []collector.StatefulAnalyzer{}
-	for _, cfg := range pc.SlAna {
-		alz, err := analyzer.GetSlaFromConfig(pid, cfg)
+	// named is used to resolve Input, hidden analyzers are not exported
+	named, hidden := map[string]interface{}{}, []interface{}{}
+	persistent := map[string]collector.PersistentAnalyzer{}
+	addAna := func(cfg *analyzer.AnaConfig, key string, alz interface{}) error {
+		if cfg.Persist {
+			pa, ok := alz.(collector.PersistentAnalyzer)
+			if !ok {
+				return fmt.Errorf("Analyzer %s cannot be persisted.", key)
+			}
+			persistent[key] = pa
+		}
+		if cfg.Name != "" {
+			if _, ok := named[cfg.Name]; ok {
+				return fmt.Errorf("Analyzer name %s is duplicated.", cfg.Name)
+			}
+			named[cfg.Name] = alz
+		}
+		if !cfg.Exported() {
+			hidden = append(hidden, alz)
+		}
+		return nil
+	}
+	withLabels := func(cfg *analyzer.AnaConfig) analyzer.AnaConfig {
+		c := *cfg
+		c.Labels = labels
+		return c
+	}
+
+	type stageConfig struct {
+		cfg      *analyzer.AnaConfig
+		key      string
+		stateful bool
+	}
+	pending := []stageConfig{}
+	for idx := range pc.SlAna {
+		cfg := &pc.SlAna[idx]
+		if cfg.Input != "" {
+			pending = append(pending, stageConfig{cfg, anaStateKey("slana", idx, cfg), false})
+			continue
+		}
+		alz, err := analyzer.GetSlaFromConfig(pid, withLabels(cfg))
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		if err := addAna(cfg, anaStateKey("slana", idx, cfg), alz); err != nil {
+			return nil, nil, err
 		}
 		sla = append(sla, alz)
 	}
-	for _, cfg := range pc.SfAna {
-		alz, err := analyzer.GetSfaFromConfig(pid, cfg)
+	for idx := range pc.SfAna {
+		cfg := &pc.SfAna[idx]
+		if cfg.Input != "" {
+			pending = append(pending, stageConfig{cfg, anaStateKey("sfana", idx, cfg), true})
+			continue
+		}
+		alz, err := analyzer.GetSfaFromConfig(pid, withLabels(cfg))
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		if err := addAna(cfg, anaStateKey("sfana", idx, cfg), alz); err != nil {
+			return nil, nil, err
 		}
 		sfa = append(sfa, alz)
 	}
-	return collector.NewPusherFromOpts(pid, pc.PusherOpts, sla, sfa)
+	opts := pc.PusherOpts
+	opts.ConstLabels = mergeLabels(pc.ConstLabels, labels)
+	pu, err := collector.NewPusherFromOpts(pid, opts, sla, sfa)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// stages may refer to each other, add them until no stage can be added
+	for len(pending) > 0 {
+		rest := []stageConfig{}
+		for _, sc := range pending {
+			upstream, ok := named[sc.cfg.Input]
+			if !ok {
+				rest = append(rest, sc)
+				continue
+			}
+			var (
+				stage = &collector.AnaStage{}
+				alz   interface{}
+			)
+			if sc.stateful {
+				stage.Stateful, err = analyzer.GetSfaFromConfig(pid, withLabels(sc.cfg))
+				alz = stage.Stateful
+			} else {
+				stage.Stateless, err = analyzer.GetSlaFromConfig(pid, withLabels(sc.cfg))
+				alz = stage.Stateless
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := addAna(sc.cfg, sc.key, alz); err != nil {
+				return nil, nil, err
+			}
+			if err := pu.AddStage(upstream, stage); err != nil {
+				return nil, nil, err
+			}
+		}
+		if len(rest) == len(pending) {
+			inputs := make([]string, 0, len(rest))
+			for _, sc := range rest {
+				inputs = append(inputs, sc.cfg.Input)
+			}
+			return nil, nil, fmt.Errorf("Input analyzers %v not found.", inputs)
+		}
+		pending = rest
+	}
+
+	for _, alz := range hidden {
+		pu.Hide(alz)
+	}
+	return pu, persistent, nil
 }
 
 // ProcRegOpts is the config struct used to create a ProcRegistry, generate collectors
 // and register then into ProcRegistry.
 type ProcConfig struct {
 	Pusher []PusherConfig `yaml:"pushercfg,omitempty"`
+	// Derived metrics refer pushers above by their names
+	Derived []analyzer.DerivedOpts `yaml:"derived,omitempty"`
+	// Group analyzers refer pushers above by their names
+	Group []analyzer.GroupConfig `yaml:"group,omitempty"`
+	// Children makes children monitored with the same config
+	Children *ChildrenConfig `yaml:"children,omitempty"`
 }
 
 func NewProcRegFromConfig(pid uint32, cfg *ProcConfig) (*ProcRegistry, error) {
+	return NewProcRegWithLabels(pid, cfg, nil)
+}
+
+// NewProcRegWithLabels generates ProcRegistry like NewProcRegFromConfig,
+// labels are added into ConstLabels of all pushers, analyzers and derived
+// metrics of the process, such as metadata of the process.
+func NewProcRegWithLabels(pid uint32, cfg *ProcConfig, labels collector.Labels) (*ProcRegistry, error) {
 	errs := collector.MultiError{}
 	procReg := &ProcRegistry{
 		registry:     collector.NewRegistry(),
 		pusherByName: map[string]*collector.Pusher{},
 		pullerByName: map[string]collector.Collector{},
+		persistent:   map[string]map[string]collector.PersistentAnalyzer{},
+
+		pusherByCfgName: map[string]*collector.Pusher{},
+		metaLabels:      labels,
+	}
+	if cfg.Children != nil {
+		errs.Append(cfg.Children.check())
 	}
+	sources := map[string]analyzer.DataSource{}
 	for idx := range cfg.Pusher {
-		pu, err := NewPusherFromConfig(pid, &(cfg.Pusher[idx]))
+		pu, persistent, err := newPusherFromConfig(pid, &(cfg.Pusher[idx]), labels)
 		if err != nil {
 			//fmt.Printf("New Puhser error, %s.", err.Error())
 			errs.Append(err)
@@ -154,11 +439,59 @@ func NewProcRegFromConfig(pid uint32, cfg *ProcConfig) (*ProcRegistry, error) {
 			errs.Append(err)
 			continue
 		}
+		if len(persistent) > 0 {
+			procReg.persistent[PusherStateName(&(cfg.Pusher[idx]))] = persistent
+		}
+
+		// duplicated names are only reported when referred
+		name := cfg.Pusher[idx].Name
+		if _, ok := procReg.pusherByCfgName[name]; ok {
+			delete(sources, name)
+			procReg.pusherByCfgName[name] = nil
+			continue
+		}
+		sources[name] = pu
+		procReg.pusherByCfgName[name] = pu
+	}
+
+	for _, opt := range cfg.Derived {
+		refs, err := opt.Sources()
+		if err != nil {
+			errs.Append(err)
+			continue
+		}
+		if dup := procReg.duplicatedPushers(refs); len(dup) > 0 {
+			errs.Append(fmt.Errorf(
+				"Pusher name %v is duplicated, cannot be referred by derived metric %s.",
+				dup,
+				opt.Name,
+			))
+			continue
+		}
+		opt.ConstLabels = mergeLabels(opt.ConstLabels, labels)
+		d, err := analyzer.GetDerivedFromConfig(pid, opt, sources)
+		if err != nil {
+			errs.Append(err)
+			continue
+		}
+		errs.Append(procReg.PullerReg(DerivedName(pid, opt.Name), d))
 	}
 	//fmt.Println(errs.Error(), len(errs))
 	return procReg, errs
 }
 
+// duplicatedPushers returns names in names which are shared by several
+// pushers in config.
+func (p *ProcRegistry) duplicatedPushers(names []string) []string {
+	dup := []string{}
+	for _, name := range names {
+		if pu, ok := p.pusherByCfgName[name]; ok && pu == nil {
+			dup = append(dup, name)
+		}
+	}
+	return dup
+}
+
 func PusherName(pid uint32, cfg *PusherConfig) string {
 	buf := bytes.NewBufferString("Pid_")
 	fmt.Fprint(buf, pid)
@@ -167,3 +500,13 @@ func PusherName(pid uint32, cfg *PusherConfig) string {
 	fmt.Fprint(buf, fields[1])
 	return buf.String()
 }
+
+func DerivedName(pid uint32, name string) string {
+	return fmt.Sprintf("Pid_%d_derived_%s", pid, name)
+}
+
+// PusherStateName identifies states of a pusher in state.Store, pid is not
+// used as it may change.
+func PusherStateName(cfg *PusherConfig) string {
+	return cfg.Name + "_" + cfg.Pf
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -51,6 +52,95 @@ sfana:
     - 0.99
 `
 
+var pipelineYaml = `
+pusher:
+  desc:
+    name: pusher
+    help: this is a pusher
+    level: 2
+  selfcol: false
+  valuetype: 2
+  inv: 10s
+  pushFunc: procinfo:cpuUsage
+  pfinv: 300ms
+slana:
+- type: "quantile"
+  input: rate
+  opt:
+    desc:
+      name: rate_quantile
+      help: quantile of rate
+      level: 2
+    targets:
+      0.9: "none"
+sfana:
+- type: "rate"
+  name: rate
+  export: false
+  opt:
+    desc:
+      name: rate
+      help: rate of cpu usage
+      level: 2
+`
+
+func TestPipelineFromConfig(t *testing.T) {
+	var pucfg PusherConfig
+	if err := yaml.UnmarshalStrict([]byte(pipelineYaml), &pucfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPusherFromConfig(uint32(os.Getpid()), &pucfg); err != nil {
+		t.Fatal(err)
+	}
+
+	pucfg.SlAna[0].Input = "nonexist"
+	if _, err := NewPusherFromConfig(uint32(os.Getpid()), &pucfg); err == nil {
+		t.Error("Expected error of unknown input.")
+	}
+}
+
+func TestPusherLabels(t *testing.T) {
+	var pucfg PusherConfig
+	if err := yaml.UnmarshalStrict([]byte(pipelineYaml), &pucfg); err != nil {
+		t.Fatal(err)
+	}
+	pucfg.SelfCol = true
+	pu, _, err := newPusherFromConfig(111, &pucfg, collector.Labels{"exe_name": "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan *collector.Desc, 10)
+	go func() {
+		pu.Describe(ch)
+		close(ch)
+	}()
+	n := 0
+	for d := range ch {
+		n++
+		if s := d.String(); !strings.Contains(s, `exe_name="app"`) || !strings.Contains(s, `PID="111"`) {
+			t.Errorf("Expected labels of process in %s.", s)
+		}
+	}
+	if n < 2 {
+		t.Errorf("Expected descs of pusher and analyzers, got %d.", n)
+	}
+	if _, ok := pucfg.ConstLabels["exe_name"]; ok {
+		t.Error("Expected config unchanged.")
+	}
+}
+
+func TestExitLabels(t *testing.T) {
+	reg, err := NewProcRegWithLabels(10, &ProcConfig{}, collector.Labels{"exe_name": "app"})
+	if err != nil && len(err.(collector.MultiError)) > 0 {
+		t.Fatal(err)
+	}
+	reg.ConstLabels = collector.Labels{"PPID": "1"}
+	labels := reg.exitLabels()
+	if labels["exe_name"] != "app" || labels["PPID"] != "1" {
+		t.Errorf("Expected metadata labels in exit event, got %v.", labels)
+	}
+}
+
 func TestNewPusherFromConfig(t *testing.T) {
 	var pucfg PusherConfig
 	err := yaml.Unmarshal([]byte(pusherYaml), &pucfg)
@@ -118,6 +208,56 @@ func TestPusherWithAnalyzer(t *testing.T) {
 	p.Stop()
 }
 
+var dupPusherYaml = `
+pushercfg:
+- pusher:
+    desc:
+      name: usage
+      help: cpu usage
+      level: 2
+      constLabels:
+        kind: cpu
+    selfcol: true
+    valuetype: 2
+    inv: 10s
+    pushFunc: procinfo:cpuUsage
+    pfinv: 300ms
+- pusher:
+    desc:
+      name: usage
+      help: memory usage
+      level: 2
+      constLabels:
+        kind: memory
+    selfcol: true
+    valuetype: 2
+    inv: 10s
+    pushFunc: procinfo:memUsage
+    pfinv: 300ms
+derived:
+- desc:
+    name: constant
+    help: not referring pushers
+    level: 2
+  expr: "1 + 1"
+`
+
+func TestDuplicatedPusherName(t *testing.T) {
+	var cfg ProcConfig
+	if err := yaml.UnmarshalStrict([]byte(dupPusherYaml), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewProcRegFromConfig(uint32(os.Getpid()), &cfg); len(err.(collector.MultiError)) > 0 {
+		t.Fatalf("Expected duplicated name not referred allowed, got %s.", err.Error())
+	}
+
+	cfg.Derived[0].Expr = "usage * 2"
+	_, err := NewProcRegFromConfig(uint32(os.Getpid()), &cfg)
+	if errs := err.(collector.MultiError); len(errs) != 1 || !strings.Contains(errs.Error(), "duplicated") {
+		t.Errorf("Expected error of duplicated name referred, got %v.", err)
+	}
+}
+
 var regyaml = `
 pushercfg:
 - pusher:
@@ -186,10 +326,14 @@ func TestProcReg(t *testing.T) {
 	defer reg.Stop()
 
 	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	deadline := time.After(5 * time.Second)
 	for {
 		select {
 		case <-stopper:
 			return
+		case <-deadline:
+			return
 		case <-ticker.C:
 			mf, err := reg.Gather()
 			errs = err.(collector.MultiError)
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"wanggj.com/abyss/procfs"
+)
+
+// procRoot is the mount point of procfs, changed in test
+var procRoot = "/proc"
+
+// procRescanInterval is the interval of scanning procRoot for processes
+// missed by execve events, 0 means only scan at startup.
+var procRescanInterval time.Duration
+
+// monitorConfigPath returns path of config file if args contain
+// bpfMonitorFlag and bpfMonitorConfigFlag, empty string is returned if the
+// process does not want to be monitored. Flags are parsed like package
+// flag, they may start with "--", and the config path is given either as
+// "-bpfMonConfig=path" or "-bpfMonConfig path".
+func monitorConfigPath(args []string) string {
+	monitored, configPath := false, ""
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+		if strings.HasPrefix(arg, "--") {
+			arg = arg[1:]
+		}
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch name {
+		case bpfMonitorFlag:
+			monitored = !hasValue || value == "true" || value == "1"
+		case bpfMonitorConfigFlag:
+			if !hasValue {
+				if idx+1 >= len(args) {
+					continue
+				}
+				idx++
+				value = args[idx]
+			}
+			configPath = value
+		}
+	}
+	if !monitored {
+		return ""
+	}
+	return configPath
+}
+
+// monitorEnvConfigPath returns value of envConfig if envMonitor is 1 or
+// true, envs are in form of "NAME=value".
+func monitorEnvConfigPath(envs []string) string {
+	monitored, configPath := false, ""
+	for _, env := range envs {
+		name, value, ok := strings.Cut(env, "=")
+		if !ok {
+			continue
+		}
+		switch name {
+		case envMonitor:
+			monitored = value == "1" || value == "true"
+		case envConfig:
+			configPath = value
+		}
+	}
+	if !monitored {
+		return ""
+	}
+	return configPath
+}
+
+// readStat reads /proc/[pid]/stat of process pid in procRoot
+func readStat(pid uint32) (*procfs.Stat, error) {
+	stat, err := os.ReadFile(filepath.Join(procRoot, fmt.Sprint(pid), "stat"))
+	if err != nil {
+		return nil, err
+	}
+	return procfs.ParseStat(string(stat))
+}
+
+// readProcKey returns the key of process pid, fork and exec events have no
+// start time. The start time is unknown if stat cannot be read.
+func readProcKey(pid uint32) ProcKey {
+	key := ProcKey{Pid: pid}
+	if st, err := readStat(pid); err == nil {
+		key.StartTime = st.StartTime
+	}
+	return key
+}
+
+// readMonitorProc reads process pid from procRoot, processes opting in
+// neither by argv nor by env are matched against discoveryRules. nil is returned if it
+// does not want to be monitored or has exited.
+func readMonitorProc(pid uint32) (*MonitorProc, error) {
+	dir := filepath.Join(procRoot, fmt.Sprint(pid))
+	cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// kernel threads have empty cmdline
+	args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	configPath := monitorConfigPath(args)
+	if configPath == "" {
+		// environ is not readable without privilege
+		environ, err := os.ReadFile(filepath.Join(dir, "environ"))
+		if err == nil {
+			configPath = monitorEnvConfigPath(strings.Split(string(environ), "\x00"))
+		}
+	}
+	if configPath == "" {
+		return discoverProc(pid, args)
+	}
+
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	st, err := procfs.ParseStat(string(stat))
+	if err != nil {
+		return nil, fmt.Errorf("Parse stat of process %d error: %s.", pid, err.Error())
+	}
+	// exe is not readable without privilege, argv[0] is used instead
+	filename, err := os.Readlink(filepath.Join(dir, "exe"))
+	if err != nil {
+		filename = args[0]
+	}
+	return &MonitorProc{
+		Pid:        pid,
+		Ppid:       st.Ppid,
+		StartTime:  st.StartTime,
+		Filename:   filename,
+		Configpath: configPath,
+	}, nil
+}
+
+// scanMonitorProc scans procRoot for processes want to be monitored, pids
+// of all processes are returned as well.
+func scanMonitorProc() ([]*MonitorProc, map[uint32]struct{}, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, nil, err
+	}
+	procs, alive := []*MonitorProc{}, map[uint32]struct{}{}
+	for _, e := range entries {
+		pid, err := strconv.ParseUint(e.Name(), 10, 32)
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		alive[uint32(pid)] = struct{}{}
+		mp, err := readMonitorProc(uint32(pid))
+		if err != nil {
+			glog.Error(err)
+			continue
+		}
+		if mp != nil {
+			procs = append(procs, mp)
+		}
+	}
+	return procs, alive, nil
+}
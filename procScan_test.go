@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMonitorConfigPath(t *testing.T) {
+	cases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"/bin/app", "-bpfMonitor", "-bpfMonConfig=/etc/app.yaml"}, "/etc/app.yaml"},
+		{[]string{"/bin/app", "-bpfMonConfig=/etc/app.yaml", "-bpfMonitor"}, "/etc/app.yaml"},
+		{[]string{"/bin/app", "-bpfMonConfig=/etc/app.yaml"}, ""},
+		{[]string{"/bin/app", "-bpfMonitor"}, ""},
+		{[]string{""}, ""},
+		{[]string{"/bin/app", "-bpfMonitor", "-bpfMonConfig", "/etc/app.yaml"}, "/etc/app.yaml"},
+		{[]string{"/bin/app", "--bpfMonitor=true", "--bpfMonConfig=/etc/app.yaml"}, "/etc/app.yaml"},
+		{[]string{"/bin/app", "-bpfMonitor=false", "-bpfMonConfig=/etc/app.yaml"}, ""},
+		{[]string{"/bin/app", "-bpfMonitorX", "-bpfMonConfig=/etc/app.yaml"}, ""},
+		{[]string{"-bpfMonitor", "-bpfMonConfig"}, ""},
+		{[]string{"-", "--", "-b"}, ""},
+	}
+	for _, c := range cases {
+		if path := monitorConfigPath(c.args); path != c.expected {
+			t.Errorf("Expected %q of %v, got %q.", c.expected, c.args, path)
+		}
+	}
+}
+
+// writeProc generates process pid in procRoot
+func writeProc(t *testing.T, pid string, cmdline string) {
+	dir := filepath.Join(procRoot, pid)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"cmdline": cmdline,
+		// processes start at pid*100 clock ticks after boot
+		"stat": pid + " (app) S 1 " + pid + " " + pid + " 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 1 0 " + pid + "00 1000 10",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestScanMonitorProc(t *testing.T) {
+	defer func(old string) { procRoot = old }(procRoot)
+	procRoot = t.TempDir()
+
+	writeProc(t, "10", "/bin/app\x00-bpfMonitor\x00-bpfMonConfig=/etc/app.yaml\x00")
+	writeProc(t, "11", "/bin/other\x00-v\x00")
+	writeProc(t, "13", "/bin/env\x00")
+	environ := "PATH=/bin\x00ABYSS_MONITOR=1\x00ABYSS_CONFIG=/etc/env.yaml\x00"
+	if err := os.WriteFile(filepath.Join(procRoot, "13", "environ"), []byte(environ), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// kernel thread
+	writeProc(t, "12", "")
+	if err := os.MkdirAll(filepath.Join(procRoot, "sys"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	procs, alive, err := scanMonitorProc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alive) != 4 {
+		t.Errorf("Expected 4 processes alive, got %v.", alive)
+	}
+	if len(procs) != 2 {
+		t.Fatalf("Expected 2 processes to monitor, got %d.", len(procs))
+	}
+	if procs[1].Pid != 13 || procs[1].Configpath != "/etc/env.yaml" {
+		t.Errorf("Expected process 13 opted in by env, got %+v.", *procs[1])
+	}
+	expected := MonitorProc{Pid: 10, Ppid: 1, StartTime: 1000, Filename: "/bin/app", Configpath: "/etc/app.yaml"}
+	if *procs[0] != expected {
+		t.Errorf("Expected %+v, got %+v.", expected, *procs[0])
+	}
+}
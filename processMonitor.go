@@ -2,88 +2,200 @@ package main
 
 import (
 	"context"
+	"time"
 
+	"github.com/golang/glog"
 	"wanggj.com/abyss/newProcTracing"
+	"wanggj.com/abyss/procfs"
 )
 
 const (
 	bpfMonitorFlag       = "-bpfMonitor"
 	bpfMonitorConfigFlag = "-bpfMonConfig"
+
+	// processes can also opt in by env, which is inherited by children
+	// and passed through wrappers, the eBPF program only captures env
+	// with prefix "ABYSS_"
+	envMonitor = "ABYSS_MONITOR"
+	envConfig  = "ABYSS_CONFIG"
 )
 
+// ProcKey identifies a process by pid and start time
+type ProcKey = procfs.ProcKey
+
 type MonitorProc struct {
-	Pid        uint32
-	Ppid       uint32
+	Pid  uint32
+	Ppid uint32
+	// StartTime is in clock ticks after boot, see ProcKey
+	StartTime  uint64
 	Filename   string
 	Configpath string
+	// Inherited is true if the process is a child followed by Children
+	Inherited bool
+	// HostConfig is true if Configpath is in the mount namespace of
+	// abyss, such as paths of discovery rules, otherwise it is resolved by
+	// procConfigPath
+	HostConfig bool
+}
+
+func (mp *MonitorProc) Key() ProcKey {
+	return ProcKey{Pid: mp.Pid, StartTime: mp.StartTime}
 }
 
 type ExitProc = newProcTracing.ExitProcMsg
 
+// exitKey returns the identity of the exited process, StartTime is unknown
+// if the process is not in seen of gatherMonitorProc.
+func exitKey(e *ExitProc) ProcKey {
+	return ProcKey{Pid: e.Pid, StartTime: procfs.DurationToTicks(e.StartTime)}
+}
+
+// eventSource delivers events of processes, selected by agent config
+var eventSource newProcTracing.EventSource = &newProcTracing.BpfSource{}
+
 // gatherMonitorProc gathers processes that with arg "-bpfMonitor" and arg like
-// "-bpfMonConfig=xxx", which represent that the process want to be monitored by abyss
-// and path of config file is xxx. Config file will be parsed to generate collectors
+// "-bpfMonConfig=xxx" or "-bpfMonConfig xxx" (or env ABYSS_MONITOR=1 and ABYSS_CONFIG=xxx), which represent
+// that the process want to be monitored by abyss and path of config file is xxx. Config file will be parsed to generate collectors
 // to collect metrics.
+//
+// Events of processes come from eventSource. Processes started before abyss
+// are found by scanning procRoot after eventSource is started, and again
+// every procRescanInterval if it is set.
+// Every process is sent at most once until it exits, a process reusing the
+// pid of one whose exit is lost is sent as a new process. Children of
+// processes followed by Children are sent as they fork or exec.
 func gatherMonitorProc(
 	ctx context.Context,
 	mCh chan<- *MonitorProc,
 	eCh chan<- *ExitProc,
 ) error {
 	execCh, exitCh := make(chan *newProcTracing.NewProcMsg, 10), make(chan *newProcTracing.ExitProcMsg, 10)
+	forkCh := make(chan *newProcTracing.ForkProcMsg, 10)
 
-	obj, err := newProcTracing.LoadBpfProgram(ctx, execCh, exitCh)
-	if err != nil {
+	if err := eventSource.Start(ctx, execCh, exitCh, forkCh); err != nil {
 		return err
 	}
-	defer func() {
-		newProcTracing.CloseBpfObject(obj)
-	}()
+	defer eventSource.Close()
+
+	// seen are processes sent into mCh and not exited yet, keyed by pid
+	seen := map[uint32]ProcKey{}
+	send := func(msg *MonitorProc) {
+		if msg.StartTime == 0 {
+			msg.StartTime = readProcKey(msg.Pid).StartTime
+		}
+		if key, ok := seen[msg.Pid]; ok {
+			if key.Same(msg.Key()) {
+				return
+			}
+			// the exit of the old process is lost
+			Children.exit(key)
+		}
+		seen[msg.Pid] = msg.Key()
+		mCh <- msg
+	}
+	scan := func() {
+		procs, alive, err := scanMonitorProc()
+		if err != nil {
+			glog.Error(err)
+			return
+		}
+		for _, msg := range procs {
+			send(msg)
+		}
+		// exit events may be lost
+		for pid, key := range seen {
+			if _, ok := alive[pid]; !ok {
+				delete(seen, pid)
+				Children.exit(key)
+				eCh <- &ExitProc{
+					Pid:           pid,
+					StartTime:     procfs.TicksToDuration(key.StartTime),
+					StatusUnknown: true,
+				}
+			}
+		}
+	}
+	// followChildren sends children started before their parents followed
+	followChildren := func() {
+		procs, err := readProcEntries()
+		if err != nil {
+			glog.Error(err)
+			return
+		}
+		for _, msg := range Children.existing(procs) {
+			send(msg)
+		}
+	}
+	scan()
+	var rescanCh <-chan time.Time
+	if procRescanInterval > 0 {
+		ticker := time.NewTicker(procRescanInterval)
+		defer ticker.Stop()
+		rescanCh = ticker.C
+	}
 
 	for {
 		select {
 		case m := <-execCh:
-			flag := false
-			for _, arg := range m.Argv {
-				if []byte(arg)[0] == 0 {
-					break
-				}
-				str := string(arg[:len(bpfMonitorFlag)])
-				if str == bpfMonitorFlag {
-					flag = true
-					break
+			child, stop := Children.exec(m)
+			if stop {
+				// the child runs another program not followed
+				key := seen[m.Pid]
+				delete(seen, m.Pid)
+				eCh <- &ExitProc{
+					Pid:       m.Pid,
+					Ppid:      m.Ppid,
+					StartTime: procfs.TicksToDuration(key.StartTime),
+					Detached:  true,
 				}
+				continue
 			}
-			if !flag {
+			if child != nil {
+				send(child)
 				continue
 			}
 			if msg := parseExecMsg(m); msg != nil {
-				mCh <- msg
+				send(msg)
+				continue
+			}
+			msg, err := discoverExecMsg(m)
+			if err != nil {
+				glog.Error(err)
+			} else if msg != nil {
+				send(msg)
 			}
+		case m := <-forkCh:
+			if msg := Children.fork(m); msg != nil {
+				send(msg)
+			}
+		case <-Children.followCh:
+			followChildren()
 		case m := <-exitCh:
+			// exits of processes replaced in seen are forwarded, and
+			// ignored by their identity
+			if key, ok := seen[m.Pid]; ok && key.Same(exitKey(m)) {
+				delete(seen, m.Pid)
+				Children.exit(key)
+			}
 			eCh <- m
+		case <-rescanCh:
+			scan()
+			followChildren()
 		case <-ctx.Done():
 			return nil
 		}
 	}
 }
 
+// parseExecMsg returns MonitorProc if the process opts in by argv flags, or
+// by env ABYSS_MONITOR and ABYSS_CONFIG, argv flags take precedence.
 func parseExecMsg(msg *newProcTracing.NewProcMsg) *MonitorProc {
 	if msg == nil {
 		return nil
 	}
-	configPath := ""
-	for _, strbytes := range msg.Argv {
-		if strbytes[0] == 0 {
-			break
-		}
-		idx := 0
-		for idx < len(strbytes) && strbytes[idx] != 0 {
-			idx++
-		}
-		if string(strbytes[:len(bpfMonitorConfigFlag)]) == bpfMonitorConfigFlag {
-			configPath = string(strbytes[len(bpfMonitorConfigFlag)+1 : idx])
-			//fmt.Println("aaa", configPath)
-		}
+	configPath := monitorConfigPath(msg.Argv)
+	if configPath == "" {
+		configPath = monitorEnvConfigPath(msg.Envp)
 	}
 	if configPath == "" {
 		return nil
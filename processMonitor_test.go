@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wanggj.com/abyss/newProcTracing"
+)
+
+func TestParseExecMsg(t *testing.T) {
+	cases := []struct {
+		argv     []string
+		envp     []string
+		expected string
+	}{
+		{[]string{"-bpfMonitor", "-bpfMonConfig=/etc/a.yaml"}, nil, "/etc/a.yaml"},
+		{[]string{"-v"}, []string{"ABYSS_MONITOR=1", "ABYSS_CONFIG=/etc/b.yaml"}, "/etc/b.yaml"},
+		// argv flags take precedence
+		{[]string{"-bpfMonitor", "-bpfMonConfig=/etc/a.yaml"}, []string{"ABYSS_MONITOR=true", "ABYSS_CONFIG=/etc/b.yaml"}, "/etc/a.yaml"},
+		{[]string{"-v"}, []string{"ABYSS_MONITOR=0", "ABYSS_CONFIG=/etc/b.yaml"}, ""},
+		{[]string{"-v"}, []string{"ABYSS_CONFIG=/etc/b.yaml"}, ""},
+	}
+	for idx, c := range cases {
+		mp := parseExecMsg(&newProcTracing.NewProcMsg{
+			Pid:      1,
+			Filename: "/bin/app",
+			Argv:     c.argv,
+			Envp:     c.envp,
+		})
+		path := ""
+		if mp != nil {
+			path = mp.Configpath
+		}
+		if path != c.expected {
+			t.Errorf("Expected config %q of %dth message, got %q.", c.expected, idx, path)
+		}
+	}
+}
+
+func TestExitKey(t *testing.T) {
+	exit := &ExitProc{Pid: 10, StartTime: 1500 * time.Millisecond}
+	if key := exitKey(exit); key != (ProcKey{Pid: 10, StartTime: 150}) {
+		t.Errorf("Expected start time 150 of exit, got %+v.", key)
+	}
+}
+
+func TestGatherMonitorProc(t *testing.T) {
+	defer func(old string) { procRoot = old }(procRoot)
+	procRoot = t.TempDir()
+	defer func(old newProcTracing.EventSource) { eventSource = old }(eventSource)
+	source := newProcTracing.NewFakeSource()
+	eventSource = source
+	defer func(old *childTracker) { Children = old }(Children)
+	Children = newChildTracker()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mCh, eCh := make(chan *MonitorProc, 10), make(chan *ExitProc, 10)
+	errCh := make(chan error, 1)
+	go func() { errCh <- gatherMonitorProc(ctx, mCh, eCh) }()
+
+	source.Exec(&newProcTracing.NewProcMsg{Pid: 20, Ppid: 1, Filename: "/bin/other", Argv: []string{"other"}})
+	source.Exec(&newProcTracing.NewProcMsg{
+		Pid:      21,
+		Ppid:     1,
+		Filename: "/bin/app",
+		Argv:     []string{"app", "-bpfMonitor", "-bpfMonConfig", "/etc/app.yaml"},
+	})
+	source.Exit(&newProcTracing.ExitProcMsg{Pid: 21, Ppid: 1})
+
+	select {
+	case mp := <-mCh:
+		expected := MonitorProc{Pid: 21, Ppid: 1, Filename: "/bin/app", Configpath: "/etc/app.yaml"}
+		if *mp != expected {
+			t.Errorf("Expected %+v, got %+v.", expected, *mp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected process monitored.")
+	}
+	select {
+	case e := <-eCh:
+		if e.Pid != 21 {
+			t.Errorf("Expected exit of 21, got %+v.", *e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected exit of process.")
+	}
+
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Error(err)
+	}
+	if len(mCh) != 0 {
+		t.Errorf("Expected only one process monitored, got %d more.", len(mCh))
+	}
+}
+
+func TestGatherMonitorProcPoll(t *testing.T) {
+	defer func(old string) { procRoot = old }(procRoot)
+	procRoot = t.TempDir()
+	defer func(old newProcTracing.EventSource) { eventSource = old }(eventSource)
+	eventSource = newProcTracing.NewPollSource(&newProcTracing.PollOpts{
+		ProcRoot: procRoot,
+		Interval: 10 * time.Millisecond,
+	})
+	defer func(old *childTracker) { Children = old }(Children)
+	Children = newChildTracker()
+
+	writeProc(t, "21", "app\x00-bpfMonitor\x00-bpfMonConfig\x00/etc/app.yaml\x00")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mCh, eCh := make(chan *MonitorProc, 10), make(chan *ExitProc, 10)
+	go gatherMonitorProc(ctx, mCh, eCh)
+	select {
+	case mp := <-mCh:
+		if mp.Pid != 21 {
+			t.Errorf("Expected process 21 monitored, got %+v.", *mp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected process 21 monitored.")
+	}
+
+	if err := os.RemoveAll(filepath.Join(procRoot, "21")); err != nil {
+		t.Fatal(err)
+	}
+	var exit *ExitProc
+	select {
+	case exit = <-eCh:
+	case <-time.After(time.Second):
+		t.Fatal("Expected exit of process 21.")
+	}
+	if exit.Pid != 21 || !exit.StatusUnknown {
+		t.Fatalf("Expected exit of process 21 with unknown status, got %+v.", *exit)
+	}
+
+	// polled exits are reported with unknown status
+	e := NewExitEvents()
+	e.Add(exit, nil)
+	metrics := gatherExits(t, e)
+	if len(metrics) != 1 {
+		t.Fatalf("Expected 1 exit event, got %d.", len(metrics))
+	}
+	checkExitLabels(t, metrics[0], map[string]string{
+		"PID": "21", "exit_code": "unknown", "signal": "unknown", "core_dumped": "unknown",
+	})
+}
+
+func TestGatherMonitorProcReusedPid(t *testing.T) {
+	defer func(old string) { procRoot = old }(procRoot)
+	procRoot = t.TempDir()
+	defer func(old newProcTracing.EventSource) { eventSource = old }(eventSource)
+	source := newProcTracing.NewFakeSource()
+	eventSource = source
+	defer func(old *childTracker) { Children = old }(Children)
+	Children = newChildTracker()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mCh, eCh := make(chan *MonitorProc, 10), make(chan *ExitProc, 10)
+	go gatherMonitorProc(ctx, mCh, eCh)
+
+	exec := &newProcTracing.NewProcMsg{
+		Pid:      21,
+		Ppid:     1,
+		Filename: "/bin/app",
+		Argv:     []string{"app", "-bpfMonitor", "-bpfMonConfig", "/etc/app.yaml"},
+	}
+	expectMonitored := func(start uint64) {
+		select {
+		case mp := <-mCh:
+			if mp.Key() != (ProcKey{Pid: 21, StartTime: start}) {
+				t.Errorf("Expected process 21 started at %d, got %+v.", start, *mp)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected process 21 started at %d monitored.", start)
+		}
+	}
+	writeProc(t, "21", "app\x00")
+	source.Exec(exec)
+	expectMonitored(2100)
+
+	// 21 is reused after its exit is lost
+	stat := "21 (app) S 1 21 21 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 1 0 5000 1000 10"
+	if err := os.WriteFile(filepath.Join(procRoot, "21", "stat"), []byte(stat), 0600); err != nil {
+		t.Fatal(err)
+	}
+	source.Exec(exec)
+	expectMonitored(5000)
+
+	// the exit of the old process does not end the new one
+	source.Exit(&newProcTracing.ExitProcMsg{Pid: 21, Ppid: 1, StartTime: 21 * time.Second})
+	<-eCh
+	source.Exec(exec)
+	source.Exit(&newProcTracing.ExitProcMsg{Pid: 21, Ppid: 1, StartTime: 50 * time.Second})
+	<-eCh
+	if len(mCh) != 0 {
+		t.Errorf("Expected process 21 monitored once, got %d more.", len(mCh))
+	}
+}
+
+func TestGatherMonitorProcPollReusedPid(t *testing.T) {
+	defer func(old string) { procRoot = old }(procRoot)
+	procRoot = t.TempDir()
+	defer func(old newProcTracing.EventSource) { eventSource = old }(eventSource)
+	eventSource = newProcTracing.NewPollSource(&newProcTracing.PollOpts{
+		ProcRoot: procRoot,
+		Interval: 10 * time.Millisecond,
+	})
+	defer func(old *childTracker) { Children = old }(Children)
+	Children = newChildTracker()
+
+	// 21 is found by the first scan
+	writeProc(t, "21", "app\x00-bpfMonitor\x00-bpfMonConfig\x00/etc/app.yaml\x00")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mCh, eCh := make(chan *MonitorProc, 10), make(chan *ExitProc, 10)
+	go gatherMonitorProc(ctx, mCh, eCh)
+	expectMonitored := func(start uint64) {
+		select {
+		case mp := <-mCh:
+			if mp.Key() != (ProcKey{Pid: 21, StartTime: start}) {
+				t.Errorf("Expected process 21 started at %d, got %+v.", start, *mp)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected process 21 started at %d monitored.", start)
+		}
+	}
+	expectExit := func(start uint64) {
+		select {
+		case e := <-eCh:
+			if exitKey(e) != (ProcKey{Pid: 21, StartTime: start}) {
+				t.Errorf("Expected exit of process 21 started at %d, got %+v.", start, *e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected exit of process 21 started at %d.", start)
+		}
+	}
+	expectMonitored(2100)
+
+	// 21 exits and its pid is reused between two polls
+	stat := "21 (app) S 1 21 21 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 1 0 5000 1000 10"
+	if err := os.WriteFile(filepath.Join(procRoot, "21", "stat"), []byte(stat), 0600); err != nil {
+		t.Fatal(err)
+	}
+	expectExit(2100)
+	expectMonitored(5000)
+
+	if err := os.RemoveAll(filepath.Join(procRoot, "21")); err != nil {
+		t.Fatal(err)
+	}
+	expectExit(5000)
+	if len(mCh) != 0 {
+		t.Errorf("Expected no more process monitored, got %d.", len(mCh))
+	}
+}
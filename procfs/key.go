@@ -0,0 +1,28 @@
+package procfs
+
+import "fmt"
+
+// ProcKey identifies a process, a pid may be reused by a new process but
+// never at the same start time. StartTime is in clock ticks after boot, the
+// 22nd field of /proc/[pid]/stat, 0 if unknown.
+type ProcKey struct {
+	Pid       uint32
+	StartTime uint64
+}
+
+// Same reports whether k and o may be the same process, an unknown start
+// time matches any.
+func (k ProcKey) Same(o ProcKey) bool {
+	return k.Pid == o.Pid &&
+		(k.StartTime == 0 || o.StartTime == 0 || k.StartTime == o.StartTime)
+}
+
+// Labels returns labels identifying the process, "PID" and "start_time",
+// start_time is skipped if unknown.
+func (k ProcKey) Labels() map[string]string {
+	labels := map[string]string{"PID": fmt.Sprint(k.Pid)}
+	if k.StartTime != 0 {
+		labels["start_time"] = fmt.Sprint(k.StartTime)
+	}
+	return labels
+}
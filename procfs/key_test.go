@@ -0,0 +1,33 @@
+package procfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProcKey(t *testing.T) {
+	cases := []struct {
+		a, b     ProcKey
+		expected bool
+	}{
+		{ProcKey{10, 100}, ProcKey{10, 100}, true},
+		{ProcKey{10, 100}, ProcKey{10, 200}, false},
+		{ProcKey{10, 100}, ProcKey{11, 100}, false},
+		// unknown start time matches any
+		{ProcKey{10, 0}, ProcKey{10, 200}, true},
+		{ProcKey{10, 100}, ProcKey{10, 0}, true},
+	}
+	for _, c := range cases {
+		if same := c.a.Same(c.b); same != c.expected {
+			t.Errorf("Expected %v of %+v and %+v, got %v.", c.expected, c.a, c.b, same)
+		}
+	}
+
+	expected := map[string]string{"PID": "10", "start_time": "100"}
+	if labels := (ProcKey{10, 100}).Labels(); !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Expected labels %v, got %v.", expected, labels)
+	}
+	if labels := (ProcKey{10, 0}).Labels(); !reflect.DeepEqual(labels, map[string]string{"PID": "10"}) {
+		t.Errorf("Expected no start_time of unknown start time, got %v.", labels)
+	}
+}
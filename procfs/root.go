@@ -0,0 +1,68 @@
+package procfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// maxSymlinks is the max number of symlinks followed by ResolveInRoot, see
+// MAXSYMLINKS of kernel
+const maxSymlinks = 40
+
+// ResolveInRoot resolves symlinks of absolute path as the kernel does for a
+// process whose root directory is root, e.g. /proc/[pid]/root. ".." never
+// goes above root and absolute symlinks restart at root, so the returned
+// path, which is relative to root, never escapes it. A missing component
+// and the ones after it are kept as they are, an error is returned if ".."
+// follows it as it cannot be resolved.
+func ResolveInRoot(root, path string) (string, error) {
+	resolved := "/"
+	pending := strings.Split(path, "/")
+	links := 0
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, name)
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if os.IsNotExist(err) && !hasDotDot(pending) {
+			return filepath.Join(append([]string{next}, pending...)...), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", &os.PathError{Op: "resolve", Path: path, Err: syscall.ELOOP}
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return resolved, nil
+}
+
+func hasDotDot(names []string) bool {
+	for _, name := range names {
+		if name == ".." {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package procfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveInRoot(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc", "app"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"etc/abs":     "/etc/app",
+		"etc/rel":     "app/../app",
+		"etc/escape":  "../../../../../etc/passwd",
+		"etc/hostdir": "/proc/1/root/etc",
+		"etc/loop":    "loop",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := map[string]string{
+		"/etc/app/a.yaml":         "/etc/app/a.yaml",
+		"/etc/abs/a.yaml":         "/etc/app/a.yaml",
+		"/etc/rel/a.yaml":         "/etc/app/a.yaml",
+		"/../../etc/abs/a.yaml":   "/etc/app/a.yaml",
+		"/etc/escape":             "/etc/passwd",
+		"/etc/hostdir/passwd":     "/proc/1/root/etc/passwd",
+		"/etc/app/missing/a.yaml": "/etc/app/missing/a.yaml",
+	}
+	for path, expected := range cases {
+		resolved, err := ResolveInRoot(root, path)
+		if err != nil || resolved != expected {
+			t.Errorf("Expected %s resolved to %s, got %s and %v.", path, expected, resolved, err)
+		}
+	}
+	for _, path := range []string{"/etc/loop", "/missing/../etc/abs"} {
+		if _, err := ResolveInRoot(root, path); err == nil {
+			t.Errorf("Expected error of %s.", path)
+		}
+	}
+}
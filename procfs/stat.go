@@ -0,0 +1,61 @@
+// Package procfs parses files of procfs shared by the agent and event
+// sources.
+package procfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserHZ is USER_HZ, the unit of times in /proc/[pid]/stat
+const UserHZ = 100
+
+// Stat is the part of /proc/[pid]/stat used by abyss
+type Stat struct {
+	Comm string
+	// State is R, S, D, Z, X and so on
+	State byte
+	Ppid  uint32
+	// StartTime is the time the process started after boot in clock ticks
+	StartTime uint64
+}
+
+// ParseStat parses content of /proc/[pid]/stat, comm (the 2nd field) is in
+// parentheses and skipped by the last ')' as it may contain spaces.
+func ParseStat(stat string) (*Stat, error) {
+	begin, end := strings.IndexByte(stat, '('), strings.LastIndexByte(stat, ')')
+	if begin < 0 || end < begin {
+		return nil, fmt.Errorf("comm not found")
+	}
+	// fields after comm start from the 3rd
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("only %d fields after comm", len(fields))
+	}
+	ppid, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	start, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &Stat{
+		Comm:      stat[begin+1 : end],
+		State:     fields[0][0],
+		Ppid:      uint32(ppid),
+		StartTime: start,
+	}, nil
+}
+
+// TicksToDuration converts clock ticks in /proc/[pid]/stat to Duration
+func TicksToDuration(ticks uint64) time.Duration {
+	return time.Duration(ticks) * (time.Second / UserHZ)
+}
+
+// DurationToTicks converts d to clock ticks in /proc/[pid]/stat
+func DurationToTicks(d time.Duration) uint64 {
+	return uint64(d / (time.Second / UserHZ))
+}
@@ -0,0 +1,32 @@
+package procfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseStat(t *testing.T) {
+	// comm contains spaces and ')'
+	stat := "1234 (a (b) c) S 7 1234 1234 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 1 0 98765 1000 100"
+	st, err := ParseStat(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Comm != "a (b) c" || st.State != 'S' || st.Ppid != 7 || st.StartTime != 98765 {
+		t.Errorf("Unexpected stat %+v.", *st)
+	}
+	for _, s := range []string{"1234 (a) S 1", "1234 a S 1", ""} {
+		if _, err := ParseStat(s); err == nil {
+			t.Errorf("Expected error of stat %q.", s)
+		}
+	}
+}
+
+func TestTicks(t *testing.T) {
+	if d := TicksToDuration(250); d != 2500*time.Millisecond {
+		t.Errorf("Expected 2.5s, got %s.", d)
+	}
+	if ticks := DurationToTicks(2500 * time.Millisecond); ticks != 250 {
+		t.Errorf("Expected 250 ticks, got %d.", ticks)
+	}
+}
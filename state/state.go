@@ -0,0 +1,128 @@
+// Package state saves states of stateful analyzers into a local directory,
+// so that they can be restored when the same process is adopted again after
+// abyss restarts.
+//
+// States of a process are stored in a sub directory named by hash of its
+// identity, one file for each pusher.
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"wanggj.com/abyss/procfs"
+)
+
+// ProcIdentity identifies a process across abyss restarts, pid is not used
+// since it may be reused by another process. StartTime is in clock ticks
+// after system boot, the 22nd field of /proc/[pid]/stat.
+type ProcIdentity struct {
+	Exe       string `json:"exe"`
+	StartTime uint64 `json:"startTime"`
+}
+
+// procRoot is the mount point of procfs, changed in test
+var procRoot = "/proc"
+
+// GetProcIdentity reads identity of process pid from procfs
+func GetProcIdentity(pid uint32) (*ProcIdentity, error) {
+	dir := filepath.Join(procRoot, fmt.Sprint(pid))
+	exe, err := os.Readlink(filepath.Join(dir, "exe"))
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	st, err := procfs.ParseStat(string(stat))
+	if err != nil {
+		return nil, fmt.Errorf("Parse stat of process %d error: %s.", pid, err.Error())
+	}
+	return &ProcIdentity{Exe: exe, StartTime: st.StartTime}, nil
+}
+
+func (id *ProcIdentity) key() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d", id.Exe, id.StartTime)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Store saves states into Dir
+type Store struct {
+	Dir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("State directory must not be empty.")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{Dir: dir}, nil
+}
+
+// pusherFile is the content of a state file
+type pusherFile struct {
+	Identity *ProcIdentity     `json:"identity"`
+	States   map[string][]byte `json:"states"`
+}
+
+func (s *Store) path(id *ProcIdentity, pusher string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == os.PathSeparator || r == 0 {
+			return '_'
+		}
+		return r
+	}, pusher)
+	return filepath.Join(s.Dir, id.key(), name+".json")
+}
+
+// Save writes states of analyzers of a pusher, states are keyed by
+// analyzers. The file is replaced atomically.
+func (s *Store) Save(id *ProcIdentity, pusher string, states map[string][]byte) error {
+	path := s.path(id, pusher)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(&pusherFile{Identity: id, States: states})
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load reads states saved by Save, nil is returned if nothing saved.
+func (s *Store) Load(id *ProcIdentity, pusher string) (map[string][]byte, error) {
+	data, err := os.ReadFile(s.path(id, pusher))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := &pusherFile{}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, err
+	}
+	// different identities with the same hash
+	if f.Identity == nil || *f.Identity != *id {
+		return nil, nil
+	}
+	return f.States, nil
+}
+
+// Remove deletes all states of the process, it is called when the process
+// exits.
+func (s *Store) Remove(id *ProcIdentity) error {
+	return os.RemoveAll(filepath.Join(s.Dir, id.key()))
+}
@@ -0,0 +1,70 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGetProcIdentity(t *testing.T) {
+	root := t.TempDir()
+	defer func(old string) { procRoot = old }(procRoot)
+	procRoot = root
+
+	dir := filepath.Join(root, "42")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/usr/bin/app", filepath.Join(dir, "exe")); err != nil {
+		t.Fatal(err)
+	}
+	stat := "42 (app) S 1 42 42 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 1 0 555 1000 100"
+	if err := os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0600); err != nil {
+		t.Fatal(err)
+	}
+	id, err := GetProcIdentity(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *id != (ProcIdentity{Exe: "/usr/bin/app", StartTime: 555}) {
+		t.Errorf("Got wrong identity %+v.", *id)
+	}
+	if _, err := GetProcIdentity(43); err == nil {
+		t.Error("Expected error of nonexistent process.")
+	}
+}
+
+func TestStore(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := &ProcIdentity{Exe: "/usr/bin/app", StartTime: 555}
+	states := map[string][]byte{"quantile": []byte(`{"count":1}`)}
+	if err := store.Save(id, "cpu_/a/b", states); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := store.Load(id, "cpu_/a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, states) {
+		t.Errorf("Expected %v, got %v.", states, loaded)
+	}
+	// a restarted process has a different start time
+	if loaded, err := store.Load(&ProcIdentity{Exe: id.Exe, StartTime: 556}, "cpu_/a/b"); err != nil || loaded != nil {
+		t.Errorf("Expected nothing for another process, got %v and %v.", loaded, err)
+	}
+
+	if err := store.Remove(id); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := store.Load(id, "cpu_/a/b"); err != nil || loaded != nil {
+		t.Errorf("Expected nothing after remove, got %v and %v.", loaded, err)
+	}
+	if _, err := NewStore(""); err == nil {
+		t.Error("Expected error of empty directory.")
+	}
+}